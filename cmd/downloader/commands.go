@@ -0,0 +1,273 @@
+package main
+
+import (
+	"downloader/internal/config"
+	"downloader/internal/media/m3u8/hlsutils"
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/metadata"
+	"downloader/pkg/LOG"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/Spidey120703/hls-m3u8/m3u8"
+)
+
+type Command struct {
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = map[string]Command{
+	"remux": {
+		Usage: "remux [flags] <directory|playlist.m3u8|file://...>",
+		Run:   runRemux,
+	},
+	"dump": {
+		Usage: "dump [-depth n] [-type moov,trak,...] [-summary] [-json] <file>",
+		Run:   runDump,
+	},
+	"diff": {
+		Usage: "diff [-json] <a> <b>",
+		Run:   runDiff,
+	},
+	"verify": {
+		Usage: "verify <file>",
+		Run:   runVerify,
+	},
+	"retag": {
+		Usage: "retag <file.m4a|file.m4v>...",
+		Run:   runRetag,
+	},
+}
+
+func usage() {
+	_, _ = fmt.Fprintf(os.Stderr, "Usage:\n  %s [--previews]\n", path.Base(os.Args[0]))
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		_, _ = fmt.Fprintf(os.Stderr, "  %s %s\n", path.Base(os.Args[0]), commands[name].Usage)
+	}
+}
+
+// runRemux runs the playlist and mux handlers over an already-downloaded (unencrypted)
+// HLS capture, without any network access.
+func runRemux(args []string) (err error) {
+	flags := flag.NewFlagSet("remux", flag.ExitOnError)
+	mediaType := flags.String("type", "video", "media type: video (single variant), mv (video with alternative renditions) or song")
+	output := flags.String("o", "", "output file (default: <target_path>/<name>.<ext>)")
+	title := flags.String("title", "", "title tag")
+	artist := flags.String("artist", "", "artist tag")
+	album := flags.String("album", "", "album tag")
+	cover := flags.String("cover", "", "cover image file")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("remux takes exactly one playlist or directory")
+	}
+
+	var params = hlsutils.HLSParameters{
+		TempDir: config.Get().Storage.TempPath,
+	}
+
+	var ext string
+	switch *mediaType {
+	case "video":
+		params.Type, ext = hlsutils.MediaTypeVideoOnly, ExtMP4
+	case "mv":
+		params.Type, ext = hlsutils.MediaTypeMusicVideo, ExtM4V
+	case "song":
+		params.Type, ext = hlsutils.MediaTypeSong, ExtM4A
+	default:
+		return fmt.Errorf("unknown media type: %s", *mediaType)
+	}
+
+	var uri string
+	var listType m3u8.ListType
+	if uri, listType, err = hlsutils.ResolveLocalPlaylist(flags.Arg(0)); err != nil {
+		return
+	}
+	switch listType {
+	case m3u8.MASTER:
+		params.MasterPlaylistURI = uri
+	case m3u8.MEDIA:
+		params.MediaPlaylistURI = uri
+	}
+
+	params.TargetPath = *output
+	if len(params.TargetPath) == 0 {
+		name := filepath.Base(strings.TrimSuffix(strings.TrimPrefix(flags.Arg(0), "file://"), string(filepath.Separator)))
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		params.TargetPath = path.Join(config.Get().Storage.TargetPath, name+ext)
+	}
+
+	tool := config.EncodingTool()
+	meta := &metadata.Metadata{EncodingTool: &tool}
+	for _, tag := range []struct {
+		value  string
+		target **string
+	}{
+		{*title, &meta.Title},
+		{*artist, &meta.ArtistName},
+		{*album, &meta.AlbumName},
+	} {
+		if len(tag.value) != 0 {
+			*tag.target = &tag.value
+		}
+	}
+	if len(*cover) != 0 {
+		if meta.Cover, err = os.ReadFile(*cover); err != nil {
+			return
+		}
+	}
+	params.MetaData = meta
+
+	LOG.Info.Printf("Remuxing local playlist: %s", uri)
+	if err = hlsutils.NewHTTPLiveStream(params).Execute(); err == nil {
+		LOG.Info.Printf("Remux completed, saved to: %s", params.TargetPath)
+	}
+	return
+}
+
+// runVerify checks the conformance of an MP4 file to ISO/IEC 14496-12 and prints the report
+// as JSON, failing when it holds any error.
+func runVerify(args []string) (err error) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("verify takes exactly one file")
+	}
+
+	var file *os.File
+	if file, err = os.Open(flags.Arg(0)); err != nil {
+		return
+	}
+	defer file.Close()
+	var info os.FileInfo
+	if info, err = file.Stat(); err != nil {
+		return
+	}
+
+	var report *boxtree.Report
+	if root, err := boxtree.UnmarshalStructure(file); err != nil {
+		report = &boxtree.Report{
+			Size:   uint64(info.Size()),
+			Errors: 1,
+			Issues: []boxtree.Issue{{Severity: boxtree.SeverityError, Code: "parse", Message: err.Error()}},
+		}
+	} else {
+		report = boxtree.Verify(root, uint64(info.Size()))
+	}
+	report.File = flags.Arg(0)
+
+	if err = printJSON(report); err != nil {
+		return
+	}
+	if !report.Valid() {
+		return fmt.Errorf("%s: %d errors, %d warnings", report.File, report.Errors, report.Warnings)
+	}
+	return
+}
+
+func readBoxTree(name string) (root *boxtree.BoxNode, err error) {
+	var file *os.File
+	if file, err = os.Open(name); err != nil {
+		return
+	}
+	defer file.Close()
+	if root, err = boxtree.UnmarshalStructure(file); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return
+}
+
+func printJSON(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// runDump prints the box tree of an MP4 file, as text or as JSON.
+func runDump(args []string) (err error) {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	depth := flags.Int("depth", 0, "levels of boxes to dump (default: all)")
+	types := flags.String("type", "", "comma-separated box types to dump, along with their ancestors and children")
+	summary := flags.Bool("summary", false, "summarize the sample tables instead of listing their entries")
+	asJSON := flags.Bool("json", false, "print the tree as JSON")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("dump takes exactly one file")
+	}
+
+	options := boxtree.DumpOptions{Depth: *depth, Summary: *summary}
+	if len(*types) != 0 {
+		for _, str := range strings.Split(*types, ",") {
+			var boxType mp4.BoxType
+			if boxType, err = boxtree.ParseBoxType(str); err != nil {
+				return
+			}
+			options.Types = append(options.Types, boxType)
+		}
+	}
+
+	var root *boxtree.BoxNode
+	if root, err = readBoxTree(flags.Arg(0)); err != nil {
+		return
+	}
+	nodes := boxtree.Dump(root, options)
+	if *asJSON {
+		return printJSON(nodes)
+	}
+	fmt.Print(boxtree.FormatDump(nodes))
+	return
+}
+
+// runDiff prints the box-level differences between two MP4 files, failing when there is any.
+func runDiff(args []string) (err error) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the differences as JSON")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errors.New("diff takes exactly two files")
+	}
+
+	var a, b *boxtree.BoxNode
+	if a, err = readBoxTree(flags.Arg(0)); err != nil {
+		return
+	}
+	if b, err = readBoxTree(flags.Arg(1)); err != nil {
+		return
+	}
+	differences := boxtree.Diff(a, b)
+	if *asJSON {
+		if differences == nil {
+			differences = []boxtree.Difference{}
+		}
+		if err = printJSON(differences); err != nil {
+			return
+		}
+	} else {
+		fmt.Print(boxtree.FormatDiff(differences))
+	}
+	if len(differences) > 0 {
+		return fmt.Errorf("%d differences", len(differences))
+	}
+	return
+}
@@ -13,6 +13,7 @@ import (
 	"downloader/pkg/utils"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"net/url"
 	"os"
@@ -75,6 +76,8 @@ func (fp *FullPath) String() string {
 
 type Downloader struct {
 	TargetPath string
+	// Previews downloads the preview clips of the tracks instead of the tracks
+	Previews bool
 }
 
 func (d *Downloader) DownloadAlbum(albumID string, ctx APIContext, fullPath FullPath) (err error) {
@@ -86,7 +89,7 @@ func (d *Downloader) DownloadAlbum(albumID string, ctx APIContext, fullPath Full
 
 	{
 		if len(fullPath.TargetPath) == 0 {
-			fullPath.TargetPath = d.TargetPath
+			fullPath.TargetPath = d.targetPath()
 		}
 		if len(fullPath.ArtistDir) == 0 {
 			fullPath.ArtistDir = *ctx.AppleMusic.Albums.Attributes.ArtistName
@@ -115,7 +118,7 @@ func (d *Downloader) DownloadAlbum(albumID string, ctx APIContext, fullPath Full
 		return
 	}
 
-	if ctx.AppleMusic.Albums.Attributes.EditorialArtwork != nil {
+	if ctx.AppleMusic.Albums.Attributes.EditorialArtwork != nil && !d.Previews {
 		artworks := make(map[string]*applemusic.Artwork)
 		artworks["BannerUber"] = ctx.AppleMusic.Albums.Attributes.EditorialArtwork.BannerUber
 		artworks["OriginalFlowcaseBrick"] = ctx.AppleMusic.Albums.Attributes.EditorialArtwork.OriginalFlowcaseBrick
@@ -133,7 +136,7 @@ func (d *Downloader) DownloadAlbum(albumID string, ctx APIContext, fullPath Full
 		}
 	}
 
-	if ctx.AppleMusic.Albums.Attributes.EditorialVideo != nil {
+	if ctx.AppleMusic.Albums.Attributes.EditorialVideo != nil && !d.Previews {
 		motionVideos := make(map[string]*applemusic.MotionVideo)
 		motionVideos["MotionSquareVideo1X1"] = ctx.AppleMusic.Albums.Attributes.EditorialVideo.MotionSquareVideo1X1
 		motionVideos["MotionDetailSquare"] = ctx.AppleMusic.Albums.Attributes.EditorialVideo.MotionDetailSquare
@@ -203,7 +206,7 @@ func (d *Downloader) DownloadSong(trackID string, ctx APIContext, fullPath FullP
 
 	{
 		if len(fullPath.TargetPath) == 0 {
-			fullPath.TargetPath = d.TargetPath
+			fullPath.TargetPath = d.targetPath()
 		}
 		if len(fullPath.ArtistDir) == 0 {
 			fullPath.ArtistDir = *ctx.AppleMusic.Songs.Attributes.ArtistName
@@ -219,10 +222,12 @@ func (d *Downloader) DownloadSong(trackID string, ctx APIContext, fullPath FullP
 			fullPath.DiscDir = fmt.Sprintf("Disc %d", *ctx.AppleMusic.Songs.Attributes.DiscNumber)
 		}
 
-		fullPath.TrackName = fmt.Sprintf(
-			"%d. %s",
-			*ctx.AppleMusic.Songs.Attributes.TrackNumber,
-			*ctx.AppleMusic.Songs.Attributes.Name)
+		if len(fullPath.TrackName) == 0 {
+			fullPath.TrackName = fmt.Sprintf(
+				"%d. %s",
+				*ctx.AppleMusic.Songs.Attributes.TrackNumber,
+				*ctx.AppleMusic.Songs.Attributes.Name) + config.Get().Storage.SongNameSuffix
+		}
 		fullPath.Ext = ExtM4A
 	}
 
@@ -241,25 +246,79 @@ func (d *Downloader) DownloadSong(trackID string, ctx APIContext, fullPath FullP
 		LOG.Info.Println()
 	}
 
+	var meta *metadata.Metadata
+	if meta, err = d.loadSongMetadata(trackID, &ctx, &fullPath); err != nil {
+		return
+	}
+	if d.Previews {
+		return d.DownloadPreview(ctx.AppleMusic.Songs.Attributes.Previews, hlsutils.MediaTypeSong, meta, fullPath)
+	}
+
+	var params = hlsutils.HLSParameters{
+		TempDir:     config.Get().Storage.TempPath,
+		TargetPath:  fullPath.String(),
+		Type:        hlsutils.MediaTypeSong,
+		AdamID:      trackID,
+		MetaData:    meta,
+		IsEncrypted: true,
+		Pipeline:    downloadPipeline(),
+	}
+
+	if ctx.AppleMusic.Songs.Attributes.ExtendedAssetUrls.EnhancedHls != nil {
+		params.MasterPlaylistURI = *ctx.AppleMusic.Songs.Attributes.ExtendedAssetUrls.EnhancedHls
+	} else {
+		LOG.Warn.Printf("No enhanced HLS found, falling back to download 256 kbps AAC")
+		for _, asset := range ctx.MZPlay.WebPlayback.Assets {
+			if asset.Flavor == "28:ctrp256" {
+				params.MediaPlaylistURI = asset.URL
+				params.WebPlayback = ctx.MZPlay.WebPlayback
+				break
+			}
+		}
+	}
+
+	if params.MasterPlaylistURI == "" && params.MediaPlaylistURI == "" {
+		LOG.Error.Printf("No downloadable media assets found.")
+		return
+	}
+
+	var context = hlsutils.NewHTTPLiveStream(params)
+	if err = context.Execute(); err == nil {
+		LOG.Info.Printf("Download completed, saved to: %s", context.TargetPath)
+	}
+	return
+}
+
+// loadSongMetadata fetches what the tags of a song are made of, besides its catalog data
+// and album (ctx.AppleMusic), and builds them. Given the path of the song, it also downloads
+// the lyrics along with it.
+func (d *Downloader) loadSongMetadata(trackID string, ctx *APIContext, fullPath *FullPath) (meta *metadata.Metadata, err error) {
 	if ctx.iTunes.Song == nil {
 		if ctx.iTunes.Song, err = itunes.GetITunesInfo[itunes.Song](trackID, "song"); err != nil {
 			return
 		}
 	}
+	if ctx.MZPlay.WebPlayback == nil && d.Previews {
+		// previews are tagged from the catalog only, no subscription is involved
+		ctx.MZPlay.WebPlayback = &applemusic.WebPlaybackSong{}
+	}
 	if ctx.MZPlay.WebPlayback == nil {
 		if ctx.MZPlay.WebPlayback, err = applemusic.GetWebPlayback(trackID); err != nil {
 			LOG.Error.Printf("failed to get MZPlay web playback assets: %v", err)
-			ctx.MZPlay.WebPlayback = &applemusic.WebPlaybackSong{}
+			ctx.MZPlay.WebPlayback, err = &applemusic.WebPlaybackSong{}, nil
 		}
 	}
 
 	var ttmlRaw, lyrics string
-	if *ctx.AppleMusic.Songs.Attributes.HasLyrics {
-		if err = d.DownloadLyrics(trackID, ctx, fullPath); err != nil {
-			LOG.Error.Printf("failed to download lyrics: %v", err)
+	if *ctx.AppleMusic.Songs.Attributes.HasLyrics && !d.Previews {
+		if fullPath != nil {
+			if err = d.DownloadLyrics(trackID, *ctx, *fullPath); err != nil {
+				LOG.Error.Printf("failed to download lyrics: %v", err)
+			}
 		}
 		if ttmlRaw, err = applemusic.GetLyrics(trackID); err != nil {
 			LOG.Error.Printf("failed to download lyrics: %v", err)
+			err = nil
 		}
 		if ttmlRaw != "" {
 			if lyrics, err = ttml.ExtractTextFromTTML(ttmlRaw); err != nil {
@@ -276,44 +335,15 @@ func (d *Downloader) DownloadSong(trackID string, ctx APIContext, fullPath FullP
 		}
 	}
 
-	var params = hlsutils.HLSParameters{
-		TempDir:    config.Get().Storage.TempPath,
-		TargetPath: fullPath.String(),
-		Type:       hlsutils.MediaTypeSong,
-		AdamID:     trackID,
-		MetaData: metadata.LoadSongMetadata(metadata.Context{
-			WebPlayback:     ctx.MZPlay.WebPlayback,
-			AppleMusicSongs: ctx.AppleMusic.Songs,
-			AppleMusicAlbum: ctx.AppleMusic.Albums,
-			ItunesSong:      ctx.iTunes.Song,
-			CoverData:       ctx.AlbumCoverData,
-			LyricsData:      lyrics,
-		}),
-		IsEncrypted: true,
-	}
-
-	if ctx.AppleMusic.Songs.Attributes.ExtendedAssetUrls.EnhancedHls != nil {
-		params.MasterPlaylistURI = *ctx.AppleMusic.Songs.Attributes.ExtendedAssetUrls.EnhancedHls
-	} else {
-		LOG.Warn.Printf("No enhanced HLS found, falling back to download 256 kbps AAC")
-		for _, asset := range ctx.MZPlay.WebPlayback.Assets {
-			if asset.Flavor == "28:ctrp256" {
-				params.MediaPlaylistURI = asset.URL
-				params.WebPlayback = ctx.MZPlay.WebPlayback
-				break
-			}
-		}
-	}
-
-	if params.MasterPlaylistURI == "" && params.MediaPlaylistURI == "" {
-		LOG.Error.Printf("No downloadable media assets found.")
-		return
-	}
-
-	var context = hlsutils.NewHTTPLiveStream(params)
-	if err = context.Execute(); err == nil {
-		LOG.Info.Printf("Download completed, saved to: %s", fullPath.String())
-	}
+	meta = metadata.LoadSongMetadata(metadata.Context{
+		WebPlayback:     ctx.MZPlay.WebPlayback,
+		AppleMusicSongs: ctx.AppleMusic.Songs,
+		AppleMusicAlbum: ctx.AppleMusic.Albums,
+		ItunesSong:      ctx.iTunes.Song,
+		CoverData:       ctx.AlbumCoverData,
+		LyricsData:      lyrics,
+		Preview:         d.Previews,
+	})
 	return
 }
 
@@ -330,7 +360,7 @@ func (d *Downloader) DownloadMusicVideo(trackID string, ctx APIContext, fullPath
 	var mvSrc metadata.MusicVideoType
 	{
 		if len(fullPath.TargetPath) == 0 {
-			fullPath.TargetPath = d.TargetPath
+			fullPath.TargetPath = d.targetPath()
 		}
 		if len(fullPath.ArtistDir) == 0 {
 			fullPath.ArtistDir = *ctx.AppleMusic.MusicVideos.Attributes.ArtistName
@@ -349,20 +379,24 @@ func (d *Downloader) DownloadMusicVideo(trackID string, ctx APIContext, fullPath
 			}
 
 			mvSrc = metadata.MusicVideoTypeFromAlbum
-			fullPath.TrackName = fmt.Sprintf(
-				"%d. %s",
-				*ctx.AppleMusic.MusicVideos.Attributes.TrackNumber,
-				*ctx.AppleMusic.MusicVideos.Attributes.Name)
+			if len(fullPath.TrackName) == 0 {
+				fullPath.TrackName = fmt.Sprintf(
+					"%d. %s{video_range_tag}",
+					*ctx.AppleMusic.MusicVideos.Attributes.TrackNumber,
+					*ctx.AppleMusic.MusicVideos.Attributes.Name)
+			}
 		} else {
 			if len(fullPath.DiscDir) == 0 {
 				fullPath.DiscDir = "Music Videos"
 			}
 
 			mvSrc = metadata.MusicVideoFromSongs
-			fullPath.TrackName = fmt.Sprintf(
-				"%s [%s]",
-				*ctx.AppleMusic.MusicVideos.Attributes.Name,
-				*ctx.AppleMusic.MusicVideos.Attributes.Isrc)
+			if len(fullPath.TrackName) == 0 {
+				fullPath.TrackName = fmt.Sprintf(
+					"%s [%s]{video_range_tag}",
+					*ctx.AppleMusic.MusicVideos.Attributes.Name,
+					*ctx.AppleMusic.MusicVideos.Attributes.Isrc)
+			}
 		}
 		fullPath.Ext = ExtM4V
 	}
@@ -395,6 +429,9 @@ func (d *Downloader) DownloadMusicVideo(trackID string, ctx APIContext, fullPath
 			return
 		}
 	}
+	if ctx.MZPlay.WebPlayback == nil && d.Previews {
+		ctx.MZPlay.WebPlayback = &applemusic.WebPlaybackSong{}
+	}
 	if ctx.MZPlay.WebPlayback == nil {
 		if ctx.MZPlay.WebPlayback, err = applemusic.GetWebPlayback(trackID); err != nil {
 			LOG.Error.Printf("failed to fetch HLS manifest: %v", err)
@@ -402,11 +439,12 @@ func (d *Downloader) DownloadMusicVideo(trackID string, ctx APIContext, fullPath
 		}
 	}
 
-	var coverData []byte
-	if artwork := ctx.AppleMusic.MusicVideos.Attributes.Artwork; artwork != nil {
-		if coverData, err = downloader.ReadCover(*artwork, path.Join(config.Get().Storage.TempPath, downloader.FilenameFormatUUID)); err != nil {
-			return
-		}
+	var meta *metadata.Metadata
+	if meta, err = d.loadMusicVideoMetadata(trackID, &ctx, mvSrc); err != nil {
+		return
+	}
+	if d.Previews {
+		return d.DownloadPreview(ctx.AppleMusic.MusicVideos.Attributes.Previews, hlsutils.MediaTypeMusicVideo, meta, fullPath)
 	}
 
 	var context = hlsutils.NewHTTPLiveStream(hlsutils.HLSParameters{
@@ -414,19 +452,41 @@ func (d *Downloader) DownloadMusicVideo(trackID string, ctx APIContext, fullPath
 		TargetPath:  fullPath.String(),
 		Type:        hlsutils.MediaTypeMusicVideo,
 		WebPlayback: ctx.MZPlay.WebPlayback,
-		MetaData: metadata.LoadMusicVideoMetadata(metadata.Context{
-			Type:                  mvSrc,
-			WebPlayback:           ctx.MZPlay.WebPlayback,
-			AppleMusicMusicVideos: ctx.AppleMusic.MusicVideos,
-			AppleMusicAlbum:       ctx.AppleMusic.Albums,
-			ItunesMusicVideo:      ctx.iTunes.MusicVideo,
-			CoverData:             coverData,
-		}),
+		MetaData:    meta,
 		IsEncrypted: true,
+		Pipeline:    downloadPipeline(),
 	})
 	if err = context.Execute(); err == nil {
-		LOG.Info.Printf("Download completed, saved to: %s", fullPath.String())
+		LOG.Info.Printf("Download completed, saved to: %s", context.TargetPath)
+	}
+	return
+}
+
+// loadMusicVideoMetadata fetches what the tags of a music video are made of, besides its
+// catalog data and album (ctx.AppleMusic), and builds them.
+func (d *Downloader) loadMusicVideoMetadata(trackID string, ctx *APIContext, mvSrc metadata.MusicVideoType) (meta *metadata.Metadata, err error) {
+	if ctx.iTunes.MusicVideo == nil {
+		if ctx.iTunes.MusicVideo, err = itunes.GetITunesInfo[itunes.MusicVideo](trackID, "song"); err != nil {
+			return
+		}
+	}
+
+	var coverData []byte
+	if artwork := ctx.AppleMusic.MusicVideos.Attributes.Artwork; artwork != nil {
+		if coverData, err = downloader.ReadCover(*artwork, path.Join(config.Get().Storage.TempPath, downloader.FilenameFormatUUID)); err != nil {
+			return
+		}
 	}
+
+	meta = metadata.LoadMusicVideoMetadata(metadata.Context{
+		Type:                  mvSrc,
+		WebPlayback:           ctx.MZPlay.WebPlayback,
+		AppleMusicMusicVideos: ctx.AppleMusic.MusicVideos,
+		AppleMusicAlbum:       ctx.AppleMusic.Albums,
+		ItunesMusicVideo:      ctx.iTunes.MusicVideo,
+		CoverData:             coverData,
+		Preview:               d.Previews,
+	})
 	return
 }
 
@@ -453,7 +513,7 @@ func (d *Downloader) DownloadLyrics(trackID string, ctx APIContext, fullPath Ful
 	return
 }
 
-var AppleMusicURLPattern = regexp.MustCompile(`^https://(?:beta.)?music.apple.com/(?P<storefront>[a-z]{2})/(?P<catalog_type>[a-z\-]+)/(?:[%0-9A-Za-z\-]+/)?(?P<itunes_id>[0-9]+|p\.[0-9A-Za-z]+)(?:\?(?P<query_strings>.+?))?$`)
+var AppleMusicURLPattern = regexp.MustCompile(`^https://(?:beta.)?music.apple.com/(?P<storefront>[a-z]{2})/(?P<catalog_type>[a-z\-]+)/(?:[%0-9A-Za-z\-]+/)?(?P<itunes_id>[0-9]+|p\.[0-9A-Za-z]+|pl\.[0-9A-Za-z\-]+)(?:\?(?P<query_strings>.+?))?$`)
 
 func (d *Downloader) Download(targetUrl string) (err error) {
 	submatches := utils.FindStringSubmatchMap(AppleMusicURLPattern, targetUrl)
@@ -480,6 +540,11 @@ func (d *Downloader) Download(targetUrl string) (err error) {
 		return d.DownloadSong(submatches["itunes_id"], APIContext{}, FullPath{})
 	case "music-video":
 		return d.DownloadMusicVideo(submatches["itunes_id"], APIContext{}, FullPath{})
+	case "playlist":
+		if !d.Previews {
+			return fmt.Errorf("unsupport catalog type: %s", submatches["catalog_type"])
+		}
+		return d.DownloadPlaylist(submatches["itunes_id"], FullPath{})
 	case "artist":
 		return fmt.Errorf("unsupport catalog type: %s", submatches["catalog_type"])
 	default:
@@ -487,12 +552,45 @@ func (d *Downloader) Download(targetUrl string) (err error) {
 	}
 }
 
+// hooks are run once a song or a music video is saved, not after the other HLS downloads
+// such as the motion artworks.
+var hooks []hlsutils.Hook
+
+func registerHooks() {
+	for _, hook := range config.Get().Hooks {
+		if len(hook.Command) == 0 {
+			continue
+		}
+		hooks = append(hooks, hlsutils.ExecHook(hook.Command, hook.Args...))
+	}
+}
+
+// downloadPipeline returns the pipeline of the songs and music videos, which runs the hooks.
+func downloadPipeline() *hlsutils.Pipeline {
+	return hlsutils.Registry().WithHooks(hooks...)
+}
+
 func main() {
 	var err error
 
 	if err = config.LoadConfig(); err != nil {
 		panic(err)
 	}
+	registerHooks()
+
+	if len(os.Args) > 1 {
+		if command, found := commands[os.Args[1]]; found {
+			if err = command.Run(os.Args[2:]); err != nil {
+				LOG.Error.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+		if os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+			usage()
+			return
+		}
+	}
 
 	if err = api.RefreshToken(); err != nil {
 		panic(err)
@@ -516,8 +614,14 @@ func main() {
 	//amURL := "https://music.apple.com/cn/album/kissing-someone-else/1533848159?i=1533848809"
 	//amURL := "https://music.apple.com/cn/album/%E6%A2%A6%E9%BE%99-loom-tour-2026-%E4%B8%AD%E5%9B%BD%E5%B7%A1%E6%BC%94%E7%89%B9%E5%88%AB%E7%89%88/1879820916?l=en-GB&ls"
 
+	flags := flag.NewFlagSet(path.Base(os.Args[0]), flag.ExitOnError)
+	previews := flags.Bool("previews", false, "download the unencrypted preview clips into the '"+PreviewsDir+"' folder")
+	flags.Usage = usage
+	_ = flags.Parse(os.Args[1:])
+
 	amDownloader := Downloader{
 		TargetPath: config.Get().Storage.TargetPath,
+		Previews:   *previews,
 	}
 	if err = amDownloader.Download(amURL); err != nil {
 		panic(err)
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"downloader/internal/api/applemusic"
+	"downloader/internal/config"
+	"downloader/internal/downloader"
+	"downloader/internal/media/m3u8/hlsutils"
+	"downloader/internal/media/mp4/metadata"
+	"downloader/pkg/LOG"
+	"downloader/pkg/utils"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+const PreviewsDir = "Previews"
+
+func (d *Downloader) targetPath() string {
+	if d.Previews {
+		return path.Join(d.TargetPath, PreviewsDir)
+	}
+	return d.TargetPath
+}
+
+func rewritePreview(inputPath string, targetPath string, meta *metadata.Metadata) (err error) {
+	var input, output *os.File
+	if input, err = os.Open(inputPath); err != nil {
+		return
+	}
+	defer utils.CloseQuietly(input)
+
+	if err = os.MkdirAll(path.Dir(targetPath), os.ModePerm); err != nil {
+		return
+	}
+	if output, err = os.Create(targetPath); err != nil {
+		return
+	}
+	defer utils.CloseQuietly(output)
+
+	return meta.Rewrite(input, output)
+}
+
+// DownloadPreview saves the preview clip of a track, tagged with the metadata of the full
+// track. Progressive clips are re-tagged as they are, HLS ones go through the HLS pipeline.
+func (d *Downloader) DownloadPreview(previews []applemusic.Preview, mediaType hlsutils.MediaType, meta *metadata.Metadata, fullPath FullPath) (err error) {
+	if len(previews) == 0 {
+		LOG.Warn.Printf("No preview clip available")
+		return
+	}
+	preview := previews[0]
+
+	targetPath := fullPath.String()
+	switch {
+	case preview.URL != nil:
+		// clips of different tracks may share their file names
+		if err = os.MkdirAll(config.Get().Storage.TempPath, os.ModePerm); err != nil {
+			return
+		}
+		var tempDir, tempPath string
+		if tempDir, err = os.MkdirTemp(config.Get().Storage.TempPath, "preview-*"); err != nil {
+			return
+		}
+		defer func() { _ = os.RemoveAll(tempDir) }()
+		if tempPath, err = utils.DownloadFile(*preview.URL, tempDir+"/"); err != nil {
+			return
+		}
+
+		// progressive clips are not probed, placeholders of the path resolve to nothing
+		targetPath = utils.Format(targetPath, hlsutils.UnprobedPathVariables())
+
+		if err = rewritePreview(tempPath, targetPath, meta); err != nil {
+			return
+		}
+		downloadPipeline().RunHooks(targetPath, meta)
+	case preview.HlsURL != nil:
+		context := hlsutils.NewHTTPLiveStream(hlsutils.HLSParameters{
+			TempDir:           config.Get().Storage.TempPath,
+			TargetPath:        targetPath,
+			Type:              mediaType,
+			MasterPlaylistURI: *preview.HlsURL,
+			MetaData:          meta,
+			Pipeline:          downloadPipeline(),
+		})
+		if err = context.Execute(); err != nil {
+			return
+		}
+		targetPath = context.TargetPath
+	default:
+		LOG.Warn.Printf("No preview clip available")
+		return
+	}
+
+	LOG.Info.Printf("Preview saved to: %s", targetPath)
+	return
+}
+
+// DownloadPlaylist downloads the tracks of a catalog playlist, in the order of the playlist.
+func (d *Downloader) DownloadPlaylist(playlistID string, fullPath FullPath) (err error) {
+	var playlist *applemusic.Playlists
+	if playlist, err = applemusic.GetPlaylistData(playlistID); err != nil {
+		return
+	}
+
+	{
+		if len(fullPath.TargetPath) == 0 {
+			fullPath.TargetPath = d.targetPath()
+		}
+		if len(fullPath.ArtistDir) == 0 {
+			fullPath.ArtistDir = "Playlists"
+			if playlist.Attributes.CuratorName != nil {
+				fullPath.ArtistDir = *playlist.Attributes.CuratorName
+			}
+		}
+		fullPath.AlbumDir = fmt.Sprintf("%s [%s]", *playlist.Attributes.Name, playlistID)
+		fullPath.DiscDir = "Tracks"
+	}
+
+	if playlist.Attributes.Artwork != nil {
+		if _, err = downloader.DownloadArtwork(*playlist.Attributes.Artwork, fullPath.AlbumPath("Cover{original_file_ext}")); err != nil {
+			LOG.Error.Printf("failed to download playlist artwork: %v", err)
+			err = nil
+		}
+	}
+
+	if playlist.Relationships == nil || playlist.Relationships.Tracks == nil {
+		LOG.Warn.Printf("Playlist '%s' has no tracks", *playlist.Attributes.Name)
+		return
+	}
+	tracks := playlist.Relationships.Tracks.Data
+
+	LOG.Info.Printf("Downloading playlist: %s", fullPath.AlbumDir)
+	LOG.Info.Printf("Start to download %d tracks\n", len(tracks))
+
+	for idx, track := range tracks {
+		LOG.Info.Println(strings.Repeat("=", 128))
+		fullPath.TrackName = fmt.Sprintf("%02d. %s - %s", idx+1, *track.Attributes.ArtistName, *track.Attributes.Name)
+		if *track.Type == "songs" {
+			fullPath.TrackName += config.Get().Storage.SongNameSuffix
+		}
+
+		// the tracks of a playlist come without relationships, they are fetched again
+		switch *track.Type {
+		case "songs":
+			err = d.DownloadSong(*track.ID, APIContext{}, fullPath)
+		case "music-videos":
+			err = d.DownloadMusicVideo(*track.ID, APIContext{}, fullPath)
+		default:
+			LOG.Warn.Printf("Type '%s' is not available to download", *track.Type)
+		}
+		if err != nil {
+			LOG.Error.Printf("failed to download track %d of the playlist: %v", idx+1, err)
+			err = nil
+		}
+	}
+
+	return
+}
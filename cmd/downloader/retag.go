@@ -0,0 +1,111 @@
+package main
+
+import (
+	"downloader/internal/api"
+	"downloader/internal/api/applemusic"
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/metadata"
+	"downloader/pkg/LOG"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// runRetag replaces the tags of already-downloaded files by freshly fetched ones, the tracks
+// being found by the catalog ID (cnID) or the ISRC the files are tagged with.
+func runRetag(args []string) (err error) {
+	flags := flag.NewFlagSet("retag", flag.ExitOnError)
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("retag takes at least one file")
+	}
+
+	if err = api.RefreshToken(); err != nil {
+		return
+	}
+	for _, name := range flags.Args() {
+		if err = retag(name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return
+}
+
+func retag(name string) (err error) {
+	var root *boxtree.BoxNode
+	if root, err = readBoxTree(name); err != nil {
+		return
+	}
+	id := metadata.Identify(root)
+
+	kind := "songs"
+	if id.MusicVideo {
+		kind = "music-videos"
+	}
+	var trackID string
+	switch {
+	case id.ItemID != nil:
+		trackID = strconv.FormatUint(uint64(*id.ItemID), 10)
+	case len(id.ISRC) != 0:
+		var ids []string
+		if ids, err = applemusic.GetIDsByISRC(kind, id.ISRC); err != nil {
+			return
+		}
+		switch len(ids) {
+		case 0:
+			return fmt.Errorf("no %s with ISRC %s", kind, id.ISRC)
+		case 1:
+			trackID = ids[0]
+		default:
+			return fmt.Errorf("ambiguous ISRC %s, matching the %s %s", id.ISRC, kind, strings.Join(ids, ", "))
+		}
+	default:
+		return errors.New("neither cnID nor ISRC to identify the track")
+	}
+
+	d := Downloader{Previews: id.Preview}
+	var ctx APIContext
+	var meta *metadata.Metadata
+	if id.MusicVideo {
+		if ctx.AppleMusic.MusicVideos, err = applemusic.GetMusicVideoData(trackID); err != nil {
+			return
+		}
+		mvSrc := metadata.MusicVideoFromSongs
+		if ctx.AppleMusic.MusicVideos.Attributes.TrackNumber != nil {
+			if len(ctx.AppleMusic.MusicVideos.Relationships.Albums.Data) == 0 {
+				return errors.New("no albums related")
+			}
+			ctx.AppleMusic.Albums = &ctx.AppleMusic.MusicVideos.Relationships.Albums.Data[0]
+			mvSrc = metadata.MusicVideoTypeFromAlbum
+		}
+		meta, err = d.loadMusicVideoMetadata(trackID, &ctx, mvSrc)
+	} else {
+		if ctx.AppleMusic.Songs, err = applemusic.GetSongData(trackID); err != nil {
+			return
+		}
+		if len(ctx.AppleMusic.Songs.Relationships.Albums.Data) == 0 {
+			return errors.New("no albums related")
+		}
+		ctx.AppleMusic.Albums = &ctx.AppleMusic.Songs.Relationships.Albums.Data[0]
+		meta, err = d.loadSongMetadata(trackID, &ctx, nil)
+	}
+	if err != nil {
+		return
+	}
+
+	var inPlace bool
+	if inPlace, err = meta.Retag(name); err != nil {
+		return
+	}
+	if inPlace {
+		LOG.Info.Printf("Retagged, the media data kept its offsets: %s", name)
+	} else {
+		LOG.Info.Printf("Retagged, the media data moved to make room for padding: %s", name)
+	}
+	return
+}
@@ -248,3 +248,122 @@ func GetAllGenres() ([]Genres, error) {
 
 	return data.Data, nil
 }
+
+func GetPlaylistData(id string) (*Playlists, error) {
+	req, err := http.NewRequest(
+		http.MethodGet,
+		"https://amp-api.music.apple.com/v1/catalog/"+config.Get().AppleMusic.Storefront+"/playlists/"+id,
+		nil)
+	if err != nil {
+		return nil, err
+	}
+
+	query := req.URL.Query()
+	query.Set("extend", "artistUrl,editorialArtwork,extendedAssetUrls,offers")
+	query.Set("include", "tracks,curator")
+	query.Set("l", "zh-Hans-CN")
+	query.Set("platform", "web")
+	req.URL.RawQuery = query.Encode()
+
+	do, err := api.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data := new(struct {
+		Errors []Errors    `json:"errors,omitempty"`
+		Data   []Playlists `json:"data,omitempty"`
+	})
+
+	defer utils.CloseQuietly(do.Body)
+
+	if err = json.NewDecoder(do.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	if len(data.Errors) > 0 {
+		return nil, errors.New(data.Errors[0].Detail)
+	}
+	if len(data.Data) != 1 {
+		return nil, ErrEntityNotFound("playlists")
+	}
+
+	playlist := &data.Data[0]
+	if playlist.Relationships == nil || playlist.Relationships.Tracks == nil {
+		return playlist, nil
+	}
+
+	// the tracks relationship is paginated, follow it to the end
+	for tracks := playlist.Relationships.Tracks; tracks.Next != nil; {
+		if req, err = http.NewRequest(http.MethodGet, "https://amp-api.music.apple.com"+*tracks.Next, nil); err != nil {
+			return nil, err
+		}
+		if do, err = api.Client().Do(req); err != nil {
+			return nil, err
+		}
+
+		page := new(struct {
+			Errors []Errors `json:"errors,omitempty"`
+			Relationship[Tracks]
+		})
+		err = json.NewDecoder(do.Body).Decode(&page)
+		utils.CloseQuietly(do.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Errors) > 0 {
+			return nil, errors.New(page.Errors[0].Detail)
+		}
+
+		tracks.Data = append(tracks.Data, page.Data...)
+		tracks.Next = page.Next
+	}
+
+	return playlist, nil
+}
+
+// GetIDsByISRC returns the catalog IDs of the songs or music-videos (kind) of an ISRC.
+func GetIDsByISRC(kind string, isrc string) ([]string, error) {
+	req, err := http.NewRequest(
+		http.MethodGet,
+		"https://amp-api.music.apple.com/v1/catalog/"+config.Get().AppleMusic.Storefront+"/"+kind,
+		nil)
+	if err != nil {
+		return nil, err
+	}
+
+	query := req.URL.Query()
+	query.Set("filter[isrc]", isrc)
+	query.Set("l", config.Get().AppleMusic.Language)
+	query.Set("platform", "web")
+	req.URL.RawQuery = query.Encode()
+
+	do, err := api.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data := new(struct {
+		Errors []Errors `json:"errors,omitempty"`
+		Data   []struct {
+			ID string `json:"id"`
+		} `json:"data,omitempty"`
+	})
+
+	defer utils.CloseQuietly(do.Body)
+
+	if err = json.NewDecoder(do.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	if len(data.Errors) > 0 {
+		return nil, errors.New(data.Errors[0].Detail)
+	}
+	if len(data.Data) == 0 {
+		return nil, ErrEntityNotFound(kind)
+	}
+
+	ids := make([]string, len(data.Data))
+	for i := range data.Data {
+		ids[i] = data.Data[i].ID
+	}
+	return ids, nil
+}
@@ -188,9 +188,11 @@ type SongsAttributes struct {
 	AudioLocale       *string           `json:"audioLocale,omitempty"`
 	AudioTraits       []string          `json:"audioTraits,omitempty"`
 	ComposerName      *string           `json:"composerName,omitempty"`
+	ContentRating     *string           `json:"contentRating,omitempty"`
 	DiscNumber        *int              `json:"discNumber,omitempty"`
 	DurationInMillis  *int              `json:"durationInMillis"`
 	EditorialArtwork  *EditorialArtwork `json:"editorialArtwork,omitempty"`
+	EditorialNotes    *EditorialNotes   `json:"editorialNotes,omitempty"`
 	ExtendedAssetUrls *struct {
 		EnhancedHls      *string `json:"enhancedHls,omitempty"`
 		Lightweight      *string `json:"lightweight,omitempty"`
@@ -218,6 +220,24 @@ type SongsAttributes struct {
 	WorkName                  *string         `json:"workName,omitempty"`
 }
 
+/*************************** Playlists ****************************/
+
+type Playlists struct {
+	Resource
+	Attributes *struct {
+		Artwork          *Artwork        `json:"artwork,omitempty"`
+		CuratorName      *string         `json:"curatorName,omitempty"`
+		Description      *EditorialNotes `json:"description,omitempty"`
+		IsChart          *bool           `json:"isChart,omitempty"`
+		LastModifiedDate *string         `json:"lastModifiedDate,omitempty"`
+		Name             *string         `json:"name"`
+		PlayParams       *PlayParameters `json:"playParams,omitempty"`
+		PlaylistType     *string         `json:"playlistType,omitempty"`
+		URL              *string         `json:"url"`
+	} `json:"attributes,omitempty"`
+	Relationships *Relationships `json:"relationships,omitempty"`
+}
+
 /*************************** Credits ****************************/
 
 type Credits struct {
@@ -271,9 +291,11 @@ type MusicVideosAttributes struct {
 	ArtistName       *string           `json:"artistName"`
 	ArtistUrl        *string           `json:"artistUrl,omitempty"`
 	Artwork          *Artwork          `json:"artwork"`
+	ContentRating    *string           `json:"contentRating,omitempty"`
 	DiscNumber       *int              `json:"discNumber,omitempty"`
 	DurationInMillis *int              `json:"durationInMillis"`
 	EditorialArtwork *EditorialArtwork `json:"editorialArtwork,omitempty"`
+	EditorialNotes   *EditorialNotes   `json:"editorialNotes,omitempty"`
 	GenreNames       []string          `json:"genreNames"`
 	Has4K            *bool             `json:"has4K"`
 	HasHDR           *bool             `json:"hasHDR"`
@@ -351,9 +373,11 @@ type Tracks struct {
 		AudioLocale       *string           `json:"audioLocale,omitempty"`
 		AudioTraits       []string          `json:"audioTraits,omitempty"`
 		ComposerName      *string           `json:"composerName,omitempty"`
+		ContentRating     *string           `json:"contentRating,omitempty"`
 		DiscNumber        *int              `json:"discNumber,omitempty"`
 		DurationInMillis  *int              `json:"durationInMillis"`
 		EditorialArtwork  *EditorialArtwork `json:"editorialArtwork,omitempty"`
+		EditorialNotes    *EditorialNotes   `json:"editorialNotes,omitempty"`
 		ExtendedAssetUrls *struct {
 			EnhancedHls      *string `json:"enhancedHls,omitempty"`
 			Lightweight      *string `json:"lightweight,omitempty"`
@@ -404,9 +428,11 @@ func (t *Tracks) AsSongs() *Songs {
 			AudioLocale:               t.Attributes.AudioLocale,
 			AudioTraits:               t.Attributes.AudioTraits,
 			ComposerName:              t.Attributes.ComposerName,
+			ContentRating:             t.Attributes.ContentRating,
 			DiscNumber:                t.Attributes.DiscNumber,
 			DurationInMillis:          t.Attributes.DurationInMillis,
 			EditorialArtwork:          t.Attributes.EditorialArtwork,
+			EditorialNotes:            t.Attributes.EditorialNotes,
 			ExtendedAssetUrls:         t.Attributes.ExtendedAssetUrls,
 			GenreNames:                t.Attributes.GenreNames,
 			HasLyrics:                 t.Attributes.HasLyrics,
@@ -443,9 +469,11 @@ func (t *Tracks) AsMusicVideos() *MusicVideos {
 			ArtistName:       t.Attributes.ArtistName,
 			ArtistUrl:        t.Attributes.ArtistUrl,
 			Artwork:          t.Attributes.Artwork,
+			ContentRating:    t.Attributes.ContentRating,
 			DiscNumber:       t.Attributes.DiscNumber,
 			DurationInMillis: t.Attributes.DurationInMillis,
 			EditorialArtwork: t.Attributes.EditorialArtwork,
+			EditorialNotes:   t.Attributes.EditorialNotes,
 			GenreNames:       t.Attributes.GenreNames,
 			Has4K:            t.Attributes.Has4K,
 			HasHDR:           t.Attributes.HasHDR,
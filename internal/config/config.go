@@ -10,12 +10,19 @@ type CliConfig struct {
 	Storage    StorageSettings  `mapstructure:"storage"     json:"storage"`
 	Network    NetworkSettings  `mapstructure:"network"     json:"network"`
 	AppleMusic AppleMusicConfig `mapstructure:"apple_music" json:"apple_music"`
+	Subtitles  SubtitleSettings `mapstructure:"subtitles"   json:"subtitles"`
+	Hooks      []HookSettings   `mapstructure:"hooks"       json:"hooks"`
+	Video      VideoSettings    `mapstructure:"video"       json:"video"`
+	Mux        MuxSettings      `mapstructure:"mux"         json:"mux"`
 }
 
 type StorageSettings struct {
 	TargetPath     string `mapstructure:"target_path"      json:"target_path"`
 	TempPath       string `mapstructure:"temp_path"        json:"temp_path"`
 	UseOriginalExt bool   `mapstructure:"use_original_ext" json:"use_original_ext"`
+	// SongNameSuffix is appended to the default file names of the songs, it may use the
+	// placeholders of the audio stream such as {audio_quality_tag}
+	SongNameSuffix string `mapstructure:"song_name_suffix" json:"song_name_suffix"`
 }
 
 type NetworkSettings struct {
@@ -40,6 +47,37 @@ type AppleMusicConfig struct {
 	Language       string `mapstructure:"language"         json:"language"`
 }
 
+type SubtitleSettings struct {
+	Embed    bool     `mapstructure:"embed"    json:"embed"`
+	Sidecars []string `mapstructure:"sidecars" json:"sidecars"`
+}
+
+type VideoSettings struct {
+	// RangePreference is one of highest, sdr, hlg, hdr10 or dolby_vision
+	RangePreference string `mapstructure:"range_preference" json:"range_preference"`
+	// CodecPreference is one of any, avc, hevc or av1, Dolby Vision counting by its base layer
+	CodecPreference string `mapstructure:"codec_preference" json:"codec_preference"`
+	// InterleaveDuration is the duration in milliseconds of the interleaved audio and video
+	// chunks of music videos, 0 to write each track as a whole
+	InterleaveDuration int `mapstructure:"interleave_duration" json:"interleave_duration"`
+}
+
+// MuxSettings selects a fragmented (CMAF) output, made of an initialization segment and
+// the moof/mdat fragments of the segments, instead of a single movie box describing the
+// whole file.
+type MuxSettings struct {
+	Fragmented bool `mapstructure:"fragmented" json:"fragmented"`
+	Sidx       bool `mapstructure:"sidx"       json:"sidx"`
+	Mfra       bool `mapstructure:"mfra"       json:"mfra"`
+}
+
+// HookSettings is an external command run after each song or music video is saved, its
+// arguments may use placeholders such as {path}, {dir}, {name}, {title}, {artist} or {album}.
+type HookSettings struct {
+	Command string   `mapstructure:"command" json:"command"`
+	Args    []string `mapstructure:"args"    json:"args"`
+}
+
 var config CliConfig
 
 func LoadConfig() (err error) {
@@ -50,6 +88,7 @@ func LoadConfig() (err error) {
 	viper.SetDefault("storage.target_path", DefaultTargetPath)
 	viper.SetDefault("storage.temp_path", DefaultTempPath)
 	viper.SetDefault("storage.use_original_ext", true)
+	viper.SetDefault("storage.song_name_suffix", "")
 	viper.SetDefault("network.fairplay.server_addr", DefaultFairPlayServerAddr)
 	viper.SetDefault("network.http.user_agent", DefaultUserAgent)
 	viper.SetDefault("network.http.origin", DefaultOrigin)
@@ -57,6 +96,15 @@ func LoadConfig() (err error) {
 	viper.SetDefault("network.num_threads", DefaultNumThreads)
 	viper.SetDefault("apple_music.storefront", DefaultStorefront)
 	viper.SetDefault("apple_music.language", DefaultAMLanguage)
+	viper.SetDefault("subtitles.embed", true)
+	viper.SetDefault("subtitles.sidecars", []string{})
+	viper.SetDefault("hooks", []HookSettings{})
+	viper.SetDefault("video.range_preference", "highest")
+	viper.SetDefault("video.codec_preference", "any")
+	viper.SetDefault("video.interleave_duration", DefaultInterleaveDuration)
+	viper.SetDefault("mux.fragmented", false)
+	viper.SetDefault("mux.sidx", true)
+	viper.SetDefault("mux.mfra", false)
 
 	if err = viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
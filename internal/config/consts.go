@@ -17,4 +17,17 @@ const (
 	DefaultNumThreads         = 5
 	DefaultStorefront         = "cn"
 	DefaultAMLanguage         = "zh-Hans-CN"
+	DefaultInterleaveDuration = 500
 )
+
+const ToolName = "downloader"
+
+// Version is that of the tool, set when building it with
+// -ldflags "-X downloader/internal/config.Version=<version>".
+var Version = "dev"
+
+// EncodingTool returns the name and version of the tool, tagged as the encoder (©too) of the
+// files it writes.
+func EncodingTool() string {
+	return ToolName + " " + Version
+}
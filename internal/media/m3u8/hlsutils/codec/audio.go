@@ -3,6 +3,9 @@ package codec
 var (
 	ALACIndicator = CodecIndicator{0x61, 0x6c, 0x61, 0x63} // alac
 	EC3Indicator  = CodecIndicator{0x65, 0x63, 0x2d, 0x33} // ec-3
+	AC3Indicator  = CodecIndicator{0x61, 0x63, 0x2d, 0x33} // ac-3
+	FLACIndicator = CodecIndicator{0x66, 0x4c, 0x61, 0x43} // fLaC
+	OpusIndicator = CodecIndicator{0x4f, 0x70, 0x75, 0x73} // Opus
 )
 
 type ALACCodec struct {
@@ -12,3 +15,20 @@ type ALACCodec struct {
 type EC3Codec struct {
 	Codec
 }
+
+// AC3Codec - Dolby Digital (ETSI TS 102 366)
+type AC3Codec struct {
+	Codec
+}
+
+// FLACCodec - Free Lossless Audio Codec in ISOBMFF
+// https://github.com/xiph/flac/blob/master/doc/isoflac.txt
+type FLACCodec struct {
+	Codec
+}
+
+// OpusCodec - Opus in ISOBMFF
+// https://opus-codec.org/docs/opus_in_isobmff.html
+type OpusCodec struct {
+	Codec
+}
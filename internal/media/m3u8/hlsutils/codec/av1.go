@@ -1,6 +1,9 @@
 package codec
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 var (
 	AV1Indicator = CodecIndicator{0x61, 0x76, 0x30, 0x31} // av01
@@ -116,6 +119,12 @@ func (c *AV1Codec) Initialize(str string) error {
 	}
 
 	if len(parts) == 3 {
+		// default values of the optional fields
+		c.ChromaSubsampling.SubsamplingX = true
+		c.ChromaSubsampling.SubsamplingY = true
+		c.ColorPrimaries = 1
+		c.TransferCharacteristics = 1
+		c.MatrixCoefficients = 1
 		return nil
 	}
 
@@ -221,3 +230,31 @@ func (c *AV1Codec) Compare(i ICodec) int {
 
 	return 0
 }
+
+func (c *AV1Codec) String() string {
+	level := (c.LevelTier.Level.X-2)<<2 | c.LevelTier.Level.Y
+	tier := "M"
+	if c.LevelTier.SeqTier == 1 {
+		tier = "H"
+	}
+	str := fmt.Sprintf("%s.%d.%02d%s.%02d", string(c.SampleEntry[:]), c.Profile, level, tier, c.BitDepth)
+
+	// the optional fields are omitted if all of them have their default values
+	defaults := !c.Monochrome &&
+		c.ChromaSubsampling.SubsamplingX && c.ChromaSubsampling.SubsamplingY && !c.ChromaSubsampling.ChromaSamplePosition &&
+		c.ColorPrimaries == 1 && c.TransferCharacteristics == 1 && c.MatrixCoefficients == 1 &&
+		!c.VideoFullRangeFlag
+	if defaults {
+		return str
+	}
+
+	bit := func(flag bool) int {
+		if flag {
+			return 1
+		}
+		return 0
+	}
+	return str + fmt.Sprintf(".%d.%d%d%d.%02d.%02d.%02d.%d", bit(c.Monochrome),
+		bit(c.ChromaSubsampling.SubsamplingX), bit(c.ChromaSubsampling.SubsamplingY), bit(c.ChromaSubsampling.ChromaSamplePosition),
+		c.ColorPrimaries, c.TransferCharacteristics, c.MatrixCoefficients, bit(c.VideoFullRangeFlag))
+}
@@ -1,6 +1,9 @@
 package codec
 
-import "math/bits"
+import (
+	"fmt"
+	"math/bits"
+)
 
 /*************************** AVC ****************************/
 
@@ -81,3 +84,7 @@ func (c *AVCCodec) Compare(i ICodec) int {
 	oConstraints := bits.OnesCount8(o.ConstraintSetFlags)
 	return oConstraints - cConstraints
 }
+
+func (c *AVCCodec) String() string {
+	return fmt.Sprintf("%s.%02x%02x%02x", c.Codec.String(), c.ProfileIndicator, c.ConstraintSetFlags, c.LevelIndicator)
+}
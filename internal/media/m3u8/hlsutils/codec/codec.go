@@ -24,6 +24,7 @@ type ICodec interface {
 	Initialize(string) error
 	GetCodecIndicator() CodecIndicator
 	Compare(ICodec) int
+	String() string
 	isComparable() bool
 }
 
@@ -51,6 +52,10 @@ func (c *Codec) Compare(ICodec) int {
 	return 0
 }
 
+func (c *Codec) String() string {
+	return string(c.CodecIndicator[:])
+}
+
 func (c *Codec) isComparable() bool {
 	return true
 }
@@ -77,9 +82,47 @@ const (
 	FamilyAV1
 	FamilyAtmos
 	FamilyALAC
+	FamilyDolbyVision
+	FamilyAC3
+	FamilyFLAC
+	FamilyOpus
 )
 
+// familyRanks orders the families from the least to the most preferred, video and audio
+// families being ranked apart since they are never compared to each other.
+var familyRanks = map[Family]int{
+	FamilyMPEG4:       0,
+	FamilyAVC:         1,
+	FamilyHEVC:        2,
+	FamilyAV1:         3,
+	FamilyDolbyVision: 4,
+	FamilyAC3:         1,
+	FamilyOpus:        3,
+	FamilyAtmos:       4,
+	FamilyFLAC:        5,
+	FamilyALAC:        6,
+}
+
+// rankXHEAAC ranks xHE-AAC above ac-3, unlike the other AAC flavours of the MPEG-4 family
+const rankXHEAAC = 2
+
+func rank(c ICodec) int {
+	if mp4, ok := c.(*MP4Codec); ok && mp4.isAAC() && mp4.ObjectTypeID == AudioObjectTypeUSAC {
+		return rankXHEAAC
+	}
+	return familyRanks[c.GetCodecIndicator().GetCodecFamily()]
+}
+
 func (c CodecIndicator) GetCodecFamily() Family {
+	family, err := c.LookupCodecFamily()
+	if err != nil {
+		panic(err)
+	}
+	return family
+}
+
+// LookupCodecFamily is GetCodecFamily returning ErrCodecUnsupported rather than panicking.
+func (c CodecIndicator) LookupCodecFamily() (Family, error) {
 	switch c {
 	case
 		AVCIndicatorAVC1,
@@ -92,23 +135,36 @@ func (c CodecIndicator) GetCodecFamily() Family {
 		AVCIndicatorMVC2,
 		AVCIndicatorMVC3,
 		AVCIndicatorMVC4:
-		return FamilyAVC
+		return FamilyAVC, nil
 	case
 		HEVCIndicatorHEV1,
 		HEVCIndicatorHVC1:
-		return FamilyHEVC
+		return FamilyHEVC, nil
 	case AV1Indicator:
-		return FamilyAV1
+		return FamilyAV1, nil
 	case
 		MPEG4IndicatorMP4A,
 		MPEG4IndicatorMP4V:
-		return FamilyMPEG4
+		return FamilyMPEG4, nil
 	case EC3Indicator:
-		return FamilyAtmos
+		return FamilyAtmos, nil
 	case ALACIndicator:
-		return FamilyALAC
+		return FamilyALAC, nil
+	case AC3Indicator:
+		return FamilyAC3, nil
+	case FLACIndicator:
+		return FamilyFLAC, nil
+	case OpusIndicator:
+		return FamilyOpus, nil
+	case
+		DVIndicatorDVH1,
+		DVIndicatorDVHE,
+		DVIndicatorDVA1,
+		DVIndicatorDVAV,
+		DVIndicatorDAV1:
+		return FamilyDolbyVision, nil
 	default:
-		panic(ErrCodecUnsupported)
+		return 0, ErrCodecUnsupported
 	}
 }
 
@@ -133,6 +189,14 @@ func Initialize(str string) (c ICodec, err error) {
 		c = &EC3Codec{}
 	case FamilyALAC:
 		c = &ALACCodec{}
+	case FamilyDolbyVision:
+		c = &DolbyVisionCodec{}
+	case FamilyAC3:
+		c = &AC3Codec{}
+	case FamilyFLAC:
+		c = &FLACCodec{}
+	case FamilyOpus:
+		c = &OpusCodec{}
 	default:
 		panic(ErrCodecUnsupported)
 	}
@@ -145,7 +209,7 @@ func Initialize(str string) (c ICodec, err error) {
 
 func Less(a ICodec, b ICodec) bool {
 	if a.GetCodecIndicator() != b.GetCodecIndicator() {
-		return a.GetCodecIndicator().GetCodecFamily()-b.GetCodecIndicator().GetCodecFamily() < 0
+		return rank(a)-rank(b) < 0
 	}
 	return a.Compare(b) < 0
 }
@@ -0,0 +1,67 @@
+package codec
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestString(t *testing.T) {
+	for _, tc := range []struct {
+		str     string
+		profile string
+	}{
+		{"mp4a.40.2", "AAC-LC"},
+		{"mp4a.40.5", "HE-AAC"},
+		{"mp4a.40.29", "HE-AACv2"},
+		{"mp4a.40.42", "xHE-AAC"},
+		{"ac-3", ""},
+		{"ec-3", ""},
+		{"fLaC", ""},
+		{"Opus", ""},
+		{"alac", ""},
+	} {
+		t.Run(tc.str, func(t *testing.T) {
+			c, err := Initialize(tc.str)
+			require.NoError(t, err)
+			assert.Equal(t, tc.str, c.String())
+			if mp4, ok := c.(*MP4Codec); ok {
+				assert.Equal(t, tc.profile, mp4.GetAACProfileName())
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := Initialize("mp4a40")
+		assert.ErrorIs(t, err, ErrCodecInvalid)
+	})
+}
+
+func TestLess(t *testing.T) {
+	expected := []string{"mp4a.40.29", "mp4a.40.5", "mp4a.40.2", "ac-3", "mp4a.40.42", "Opus", "ec-3", "fLaC", "alac"}
+
+	var codecs []ICodec
+	for _, str := range slices.Backward(expected) {
+		c, err := Initialize(str)
+		require.NoError(t, err)
+		codecs = append(codecs, c)
+	}
+	slices.SortFunc(codecs, func(a, b ICodec) int {
+		switch {
+		case Less(a, b):
+			return -1
+		case Less(b, a):
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	var actual []string
+	for _, c := range codecs {
+		actual = append(actual, c.String())
+	}
+	assert.Equal(t, expected, actual)
+}
@@ -0,0 +1,107 @@
+package codec
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*************************** Dolby Vision ****************************/
+
+var (
+	DVIndicatorDVH1 = CodecIndicator{0x64, 0x76, 0x68, 0x31} // dvh1
+	DVIndicatorDVHE = CodecIndicator{0x64, 0x76, 0x68, 0x65} // dvhe
+	DVIndicatorDVA1 = CodecIndicator{0x64, 0x76, 0x61, 0x31} // dva1
+	DVIndicatorDVAV = CodecIndicator{0x64, 0x76, 0x61, 0x76} // dvav
+	DVIndicatorDAV1 = CodecIndicator{0x64, 0x61, 0x76, 0x31} // dav1
+)
+
+var DolbyVisionProfileMap = map[uint8]string{
+	4:  "HEVC, dual layer, SDR compatible",
+	5:  "HEVC, single layer, IPTPQc2",
+	7:  "HEVC, dual layer, HDR10 compatible",
+	8:  "HEVC, single layer, HDR10/SDR/HLG compatible",
+	9:  "AVC, single layer, SDR compatible",
+	10: "AV1, single layer",
+}
+
+func IsDolbyVision(indicator CodecIndicator) bool {
+	switch indicator {
+	case DVIndicatorDVH1, DVIndicatorDVHE, DVIndicatorDVA1, DVIndicatorDVAV, DVIndicatorDAV1:
+		return true
+	default:
+		return false
+	}
+}
+
+// DolbyVisionCodec - Dolby Vision Streams Within the ISO Base Media File Format
+// https://professional.dolby.com/siteassets/technologies/dolby-vision/dolby-vision-bitstreams-within-the-iso-base-media-file-format-v2.3.pdf
+type DolbyVisionCodec struct {
+	Codec
+
+	// Profile
+	// dv_profile, encoded as a two digit decimal number
+	Profile uint8
+
+	// Level
+	// dv_level, encoded as a two digit decimal number
+	Level uint8
+}
+
+func (c *DolbyVisionCodec) Initialize(str string) error {
+	if err := c.Codec.Initialize(str); err != nil {
+		return err
+	}
+	str = str[4:]
+
+	if len(str) == 0 {
+		return nil
+	}
+
+	if ch := str[0]; ch != '.' {
+		return ErrCodecInvalid
+	}
+	str = str[1:]
+
+	parts := strings.Split(str, ".")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return ErrCodecInvalid
+	}
+
+	assign(10, 8, &c.Profile, parts[0])
+	assign(10, 8, &c.Level, parts[1])
+
+	return nil
+}
+
+// BaseLayer returns the codec family of the base layer
+func (c *DolbyVisionCodec) BaseLayer() Family {
+	switch c.GetCodecIndicator() {
+	case DVIndicatorDVA1, DVIndicatorDVAV:
+		return FamilyAVC
+	case DVIndicatorDAV1:
+		return FamilyAV1
+	default:
+		return FamilyHEVC
+	}
+}
+
+func (c *DolbyVisionCodec) isComparable() bool {
+	return IsDolbyVision(c.GetCodecIndicator())
+}
+
+func (c *DolbyVisionCodec) Compare(i ICodec) int {
+	if !c.isComparable() || !i.isComparable() {
+		panic(ErrCodecUncomparable)
+	}
+	o := i.(*DolbyVisionCodec)
+
+	if c.Profile != o.Profile {
+		return int(c.Profile) - int(o.Profile)
+	}
+
+	return int(c.Level) - int(o.Level)
+}
+
+func (c *DolbyVisionCodec) String() string {
+	return fmt.Sprintf("%s.%02d.%02d", c.Codec.String(), c.Profile, c.Level)
+}
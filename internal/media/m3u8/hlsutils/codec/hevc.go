@@ -1,6 +1,9 @@
 package codec
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 /*************************** HEVC ****************************/
 
@@ -158,3 +161,31 @@ func (c *HEVCCodec) Compare(i ICodec) int {
 
 	return 0
 }
+
+func (c *HEVCCodec) String() string {
+	var builder strings.Builder
+	builder.WriteString(c.Codec.String())
+	builder.WriteByte('.')
+	for space, value := range HEVCGeneralProfileSpaceMap {
+		if value == c.GeneralProfile.GeneralProfileSpace {
+			builder.WriteByte(space)
+		}
+	}
+	_, _ = fmt.Fprintf(&builder, "%d.%X.", c.GeneralProfile.GeneralProfileIndicator, c.GeneralProfileCompatibilityFlags)
+	for tier, value := range HEVCGeneralTierFlagMap {
+		if value == c.GeneralTierLevel.GeneralTierFlag {
+			builder.WriteByte(tier)
+		}
+	}
+	_, _ = fmt.Fprintf(&builder, "%d", c.GeneralTierLevel.GeneralLevelIndicator)
+
+	// trailing bytes that are zero are omitted
+	last := len(c.ConstraintFlags)
+	for last > 0 && c.ConstraintFlags[last-1] == 0 {
+		last--
+	}
+	for _, flags := range c.ConstraintFlags[:last] {
+		_, _ = fmt.Fprintf(&builder, ".%X", flags)
+	}
+	return builder.String()
+}
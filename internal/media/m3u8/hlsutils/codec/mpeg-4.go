@@ -1,6 +1,9 @@
 package codec
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 var (
 	MPEG4IndicatorMP4A = CodecIndicator{0x6d, 0x70, 0x34, 0x61} // mp4a
@@ -180,6 +183,22 @@ const (
 	AudioObjectTypeAudioSync
 )
 
+// aacRanks orders the AAC audio object types from the least to the most preferred, the
+// extensions of HE-AAC trading quality for bandwidth; other object types rank below them
+var aacRanks = map[uint8]int{
+	AudioObjectTypePS:     0, // HE-AACv2
+	AudioObjectTypeSBR:    1, // HE-AAC
+	AudioObjectTypeAAC_LC: 2, // AAC-LC
+	AudioObjectTypeUSAC:   3, // xHE-AAC
+}
+
+var AACProfileMap = map[uint8]string{
+	AudioObjectTypeAAC_LC: "AAC-LC",
+	AudioObjectTypeSBR:    "HE-AAC",
+	AudioObjectTypePS:     "HE-AACv2",
+	AudioObjectTypeUSAC:   "xHE-AAC",
+}
+
 type MP4Codec struct {
 	Codec
 	ObjectTypeIndication uint8
@@ -192,13 +211,17 @@ func (c *MP4Codec) Initialize(str string) error {
 	}
 	str = str[4:]
 
+	if len(str) == 0 {
+		return nil
+	}
+
 	if ch := str[0]; ch != '.' {
 		return ErrCodecInvalid
 	}
 	str = str[1:]
 
 	parts := strings.Split(str, ".")
-	if len(parts) < 1 || len(parts) > 3 {
+	if len(parts) < 1 || len(parts) > 2 || len(parts[0]) == 0 {
 		return ErrCodecInvalid
 	}
 
@@ -213,12 +236,28 @@ func (c *MP4Codec) GetObjectTypeIndicationDescription() string {
 	return GetObjectTypeIndicationDescription(c.ObjectTypeIndication)
 }
 
+func (c *MP4Codec) isAAC() bool {
+	return c.GetCodecIndicator() == MPEG4IndicatorMP4A && c.ObjectTypeIndication == 0x40
+}
+
+// GetAACProfileName returns the common name of the AAC flavour, e.g. "HE-AAC", or nothing
+// for other audio object types.
+func (c *MP4Codec) GetAACProfileName() string {
+	if !c.isAAC() {
+		return ""
+	}
+	return AACProfileMap[c.ObjectTypeID]
+}
+
+func (c *MP4Codec) aacRank() int {
+	if rank, found := aacRanks[c.ObjectTypeID]; found {
+		return rank
+	}
+	return -1
+}
+
 func (c *MP4Codec) isComparable() bool {
-	return c.ObjectTypeIndication == 0x40 &&
-		c.GetCodecIndicator() == MPEG4IndicatorMP4A &&
-		(c.ObjectTypeID == AudioObjectTypeAAC_LC ||
-			c.ObjectTypeID == AudioObjectTypeSBR ||
-			c.ObjectTypeID == AudioObjectTypePS)
+	return c.GetCodecIndicator() == MPEG4IndicatorMP4A || c.GetCodecIndicator() == MPEG4IndicatorMP4V
 }
 
 func (c *MP4Codec) Compare(i ICodec) int {
@@ -231,11 +270,21 @@ func (c *MP4Codec) Compare(i ICodec) int {
 		return int(c.ObjectTypeIndication) - int(o.ObjectTypeIndication)
 	}
 
-	if c.ObjectTypeID != o.ObjectTypeID {
-		return int(c.ObjectTypeID) - int(o.ObjectTypeID)
+	if c.isAAC() {
+		if rankDiff := c.aacRank() - o.aacRank(); rankDiff != 0 {
+			return rankDiff
+		}
 	}
 
-	return 0
+	return int(c.ObjectTypeID) - int(o.ObjectTypeID)
+}
+
+func (c *MP4Codec) String() string {
+	str := fmt.Sprintf("%s.%02x", c.Codec.String(), c.ObjectTypeIndication)
+	if c.ObjectTypeID != 0 {
+		str += fmt.Sprintf(".%d", c.ObjectTypeID)
+	}
+	return str
 }
 
 type MP4ACodec struct {
@@ -4,12 +4,18 @@ import (
 	"downloader/internal/drm/fairplay"
 	"downloader/internal/drm/widevine"
 	"downloader/internal/media/mp4/cmaf"
+	"downloader/internal/media/mp4/mp4utils"
 	"downloader/pkg/LOG"
 	"downloader/pkg/ansi"
 	"downloader/pkg/utils"
 	"errors"
+	"io"
+	"maps"
 	"os"
+	"slices"
 	"strings"
+
+	"github.com/Spidey120703/go-mp4"
 )
 
 type DecryptHandler struct {
@@ -59,11 +65,12 @@ func (ctx *DecryptHandler) getKeys(entry *MediaPlaylistEntry) (keys [][]byte, dr
 }
 
 func (ctx *DecryptHandler) decryptEntry(entry *MediaPlaylistEntry) (err error) {
-	var inputs []*os.File
-	if inputs, err = utils.OpenFiles(entry.FilePaths); err != nil {
+	var inputs []io.ReadSeeker
+	var files []*os.File
+	if inputs, files, err = entry.OpenSegments(); err != nil {
 		return
 	}
-	defer utils.CloseQuietlyAll(inputs)
+	defer utils.CloseQuietlyAll(files)
 
 	if len(inputs) == 0 {
 		return errors.New("empty media playlist")
@@ -94,20 +101,68 @@ func (ctx *DecryptHandler) decryptEntry(entry *MediaPlaylistEntry) (err error) {
 		return
 	}
 
+	// the decrypted samples are held in a spool rather than in memory until they are muxed
+	var spool *mp4utils.SampleSpool
+	if spool, err = mp4utils.NewSampleSpool(entry.FilePaths[0] + ".samples"); err != nil {
+		return
+	}
+	defer utils.CloseQuietly(spool)
+	entry.SpoolPath = spool.FilePath
+	ctx.AddCleanup(func() {
+		if err := os.Remove(spool.FilePath); err != nil {
+			LOG.Warn.Printf("failed to remove %s: %v", spool.FilePath, err)
+		}
+	})
+
+	counts := make(map[uint32]int)
+	if err = spoolSamples(spool, entry.Decryptor.GetSamples(), counts); err != nil {
+		return
+	}
+	for _, mdat := range entry.Decryptor.GetRoot().Cache[mp4.BoxTypeMdat()] {
+		mdat.Box.(*mp4.Mdat).Data = nil
+	}
+
 	var seg *cmaf.Segment
-	for _, input := range inputs[1:] {
+	for idx, input := range inputs[1:] {
+		if slices.Contains(entry.Initializations, idx+1) {
+			if _, err = entry.Decryptor.AddInitialization(input); err != nil {
+				return
+			}
+			continue
+		}
 		if seg, err = entry.Decryptor.AddSegment(input); err != nil {
 			return
 		}
 		if err = entry.Decryptor.DecryptSegment(seg, keys); err != nil {
 			return
 		}
+		if err = spoolSamples(spool, entry.Decryptor.GetSamples(), counts); err != nil {
+			return
+		}
+		for _, mdat := range seg.Mdat {
+			mdat.Data = nil
+		}
 	}
 
+	if err = spool.Close(); err != nil {
+		return
+	}
 	LOG.Info.Println("Decryption completed.")
 	return
 }
 
+// spoolSamples moves the payloads of the samples decrypted since the last call to the spool,
+// counts holding the number of samples of each track already spooled.
+func spoolSamples(spool *mp4utils.SampleSpool, samples map[uint32][]mp4utils.Sample, counts map[uint32]int) (err error) {
+	for _, trackID := range slices.Sorted(maps.Keys(samples)) {
+		if err = spool.Spool(samples[trackID][counts[trackID]:]); err != nil {
+			return
+		}
+		counts[trackID] = len(samples[trackID])
+	}
+	return
+}
+
 func (ctx *DecryptHandler) decryptSegments() (err error) {
 	for idx, entry := range ctx.MediaPlaylistEntries {
 		LOG.Info.Printf("Starting decryption for track %d", idx+1)
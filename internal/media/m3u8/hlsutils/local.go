@@ -0,0 +1,82 @@
+package hlsutils
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Spidey120703/hls-m3u8/m3u8"
+)
+
+// localPath returns the file system path of a `file://` URI or a plain path, and
+// whether the resource is local at all.
+func localPath(uri string) (string, bool) {
+	if strings.HasPrefix(uri, "file://") {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return "", false
+		}
+		return filepath.FromSlash(u.Path), true
+	}
+	if strings.Contains(uri, "://") {
+		return "", false
+	}
+	return uri, true
+}
+
+func isLocal(uri string) bool {
+	_, local := localPath(uri)
+	return local
+}
+
+// ResolveLocalPlaylist locates the playlist of a local HLS capture, given either a
+// playlist file (or `file://` URI) or the directory holding it, in which case a master
+// playlist is preferred over the media playlists.
+func ResolveLocalPlaylist(location string) (uri string, listType m3u8.ListType, err error) {
+	filePath, local := localPath(location)
+	if !local {
+		return "", 0, errors.New("not a local playlist: " + location)
+	}
+
+	var info os.FileInfo
+	if info, err = os.Stat(filePath); err != nil {
+		return
+	}
+	if !info.IsDir() {
+		_, listType, err = OpenM3U8(filePath)
+		return filePath, listType, err
+	}
+
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(filePath); err != nil {
+		return
+	}
+	var candidates []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(path.Ext(entry.Name()), ".m3u8") {
+			candidates = append(candidates, filepath.Join(filePath, entry.Name()))
+		}
+	}
+	slices.Sort(candidates)
+
+	for _, candidate := range candidates {
+		var candidateType m3u8.ListType
+		if _, candidateType, err = OpenM3U8(candidate); err != nil {
+			continue
+		}
+		if candidateType == m3u8.MASTER {
+			return candidate, m3u8.MASTER, nil
+		}
+		if len(uri) == 0 {
+			uri, listType = candidate, candidateType
+		}
+	}
+	if len(uri) == 0 {
+		return "", 0, errors.New("no playlist found in " + filePath)
+	}
+	return uri, listType, nil
+}
@@ -4,7 +4,9 @@ import (
 	"downloader/internal/api/applemusic"
 	"downloader/internal/media/mp4/metadata"
 	"downloader/internal/media/mp4/mp4utils"
+	"downloader/internal/media/webvtt"
 	"io"
+	"slices"
 
 	"github.com/Spidey120703/hls-m3u8/m3u8"
 )
@@ -35,6 +37,7 @@ type HLSParameters struct {
 	WebPlayback       *applemusic.WebPlaybackSong
 	MetaData          *metadata.Metadata
 	IsEncrypted       bool
+	Pipeline          *Pipeline // defaults to Registry()
 }
 
 type MediaPlaylistEntry struct {
@@ -43,11 +46,24 @@ type MediaPlaylistEntry struct {
 	KeyURIs          map[string][]string
 	URIs             []string
 	FilePaths        []string
+	ByteRanges       []ByteRange
+	ExplicitOffsets  []bool // whether the EXT-X-BYTERANGE tag of every segment gives its offset
+	Initializations  []int  // indexes of FilePaths holding initialization segments
 	Readers          io.ReadSeeker
 	Decryptor        mp4utils.IDecryptor
+	SpoolPath        string // file holding the decrypted samples, if any
 	Muxer            *mp4utils.MuxContext
 }
 
+type SubtitleEntry struct {
+	Alternative      *m3u8.Alternative
+	MediaPlaylistURI string
+	MediaPlaylist    *m3u8.MediaPlaylist
+	URIs             []string
+	FilePaths        []string
+	Cues             []webvtt.Cue
+}
+
 type IHandler interface {
 	Execute() (err error)
 }
@@ -62,10 +78,15 @@ type Context struct {
 	MasterPlaylistURI    string
 	MasterPlaylist       *m3u8.MasterPlaylist
 	Variant              *m3u8.Variant
+	VideoRange           VideoRange
+	AudioInfo            *metadata.AudioInfo
 	WebPlayback          *applemusic.WebPlaybackSong
 	Muxer                *mp4utils.MuxContext
 	MediaPlaylistEntries []*MediaPlaylistEntry
+	SubtitleEntries      []*SubtitleEntry
 	IsEncrypted          bool
+	Pipeline             *Pipeline
+	cleanups             []func()
 }
 
 func NewHTTPLiveStream(p HLSParameters) (ctx *Context) {
@@ -91,23 +112,29 @@ func NewHTTPLiveStream(p HLSParameters) (ctx *Context) {
 	ctx.WebPlayback = p.WebPlayback
 	ctx.MetaData = p.MetaData
 	ctx.IsEncrypted = p.IsEncrypted
+	ctx.Pipeline = p.Pipeline
 	if len(p.MasterPlaylistURI) == 0 && p.WebPlayback != nil {
 		ctx.MasterPlaylistURI = p.WebPlayback.HlsPlaylistURL
 	}
 	return
 }
 
-func (ctx *Context) Execute() (err error) {
-	handlers := []IHandler{
-		&PlaylistHandler{ctx},
-		&DecryptHandler{ctx},
-		&MuxHandler{ctx},
+// AddCleanup registers a function to be called once the pipeline is done with the context,
+// whether it succeeded or not, e.g. to remove the temporary files of a stage.
+func (ctx *Context) AddCleanup(cleanup func()) {
+	ctx.cleanups = append(ctx.cleanups, cleanup)
+}
+
+func (ctx *Context) cleanup() {
+	for _, cleanup := range slices.Backward(ctx.cleanups) {
+		cleanup()
 	}
-	for _, handler := range handlers {
-		err = handler.Execute()
-		if err != nil {
-			break
-		}
+	ctx.cleanups = nil
+}
+
+func (ctx *Context) Execute() (err error) {
+	if ctx.Pipeline == nil {
+		return Registry().Execute(ctx)
 	}
-	return
+	return ctx.Pipeline.Execute(ctx)
 }
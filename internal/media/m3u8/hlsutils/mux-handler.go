@@ -1,12 +1,24 @@
 package hlsutils
 
 import (
+	"downloader/internal/config"
+	"downloader/internal/media/mp4/metadata"
 	"downloader/internal/media/mp4/mp4utils"
+	"downloader/internal/media/webvtt"
+	"downloader/pkg/LOG"
 	"downloader/pkg/utils"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/Spidey120703/hls-m3u8/m3u8"
 )
 
 type MuxHandler struct {
@@ -19,11 +31,12 @@ func (ctx *MuxHandler) initializeMux() (err error) {
 			entry.Muxer = mp4utils.OfDecryptor(entry.Decryptor)
 		} else {
 			if err = func() (err error) {
-				var inputs []*os.File
-				if inputs, err = utils.OpenFiles(entry.FilePaths); err != nil {
+				var inputs []io.ReadSeeker
+				var files []*os.File
+				if inputs, files, err = entry.OpenSegments(); err != nil {
 					return
 				}
-				defer utils.CloseQuietlyAll(inputs)
+				defer utils.CloseQuietlyAll(files)
 
 				if len(inputs) == 0 {
 					return errors.New("empty media playlist")
@@ -34,8 +47,19 @@ func (ctx *MuxHandler) initializeMux() (err error) {
 					return
 				}
 
-				for _, input := range inputs[1:] {
-					if _, err = entry.Muxer.AddSegment(input); err != nil {
+				for idx, input := range inputs[1:] {
+					if slices.Contains(entry.Initializations, idx+1) {
+						if _, err = entry.Muxer.AddInitialization(input); err != nil {
+							return
+						}
+						continue
+					}
+					// the samples are streamed from the segment files when muxing
+					var offset int64
+					if len(entry.ByteRanges) != 0 {
+						offset = entry.ByteRanges[idx+1].Offset
+					}
+					if _, err = entry.Muxer.AddSegmentAt(input, entry.FilePaths[idx+1], offset); err != nil {
 						return
 					}
 				}
@@ -63,6 +87,94 @@ func (ctx *MuxHandler) initializeMux() (err error) {
 	return
 }
 
+// probeAudio reads the audio sample entry of the streams, which tells more than the CODECS
+// attribute of the variant.
+func (ctx *MuxHandler) probeAudio() {
+	for _, entry := range ctx.MediaPlaylistEntries {
+		sampleEntries, err := entry.Muxer.Root.P("moov.trak[mdia.hdlr.handler_type=soun].mdia.minf.stbl.stsd.*[0]")
+		if err != nil {
+			continue
+		}
+		for _, sampleEntry := range sampleEntries {
+			var info *metadata.AudioInfo
+			if info, err = mp4utils.AudioInfoOf(sampleEntry); err != nil {
+				LOG.Warn.Printf("failed to read the audio sample entry: %v", err)
+				continue
+			}
+			LOG.Info.Printf("Audio: %s %s (%s)", info.Codec, info.Quality(), info.ChannelLayout)
+			ctx.AudioInfo = info
+			if ctx.MetaData != nil {
+				ctx.MetaData.Audio = info
+			}
+			return
+		}
+	}
+}
+
+// probeVideo reads the parameter sets of the video stream, to check them against the
+// selected variant and to tell the HD flag of the file from the coded resolution.
+func (ctx *MuxHandler) probeVideo() {
+	for _, entry := range ctx.MediaPlaylistEntries {
+		sampleEntries, err := entry.Muxer.Root.P("moov.trak[mdia.hdlr.handler_type=vide].mdia.minf.stbl.stsd.*[0]")
+		if err != nil {
+			continue
+		}
+		for _, sampleEntry := range sampleEntries {
+			var info *mp4utils.VideoInfo
+			if info, err = mp4utils.VideoInfoOf(sampleEntry); err != nil {
+				LOG.Warn.Printf("failed to read the video parameter sets: %v", err)
+				if info == nil {
+					continue
+				}
+			}
+			LOG.Info.Printf("Video: %s %s %.3f fps, %d bits", info.Codec, info.Resolution(), info.FrameRate, info.BitDepth)
+
+			if ctx.Variant != nil {
+				for _, mismatch := range info.CheckVariant(ctx.Variant.Resolution, ctx.Variant.FrameRate) {
+					LOG.Warn.Printf("Video stream does not match the variant: %s", mismatch)
+				}
+			}
+			if !checkVideoRange(ctx.VideoRange, info) {
+				LOG.Warn.Printf("Video stream does not match the variant: transfer characteristics %d, expected %s", info.TransferCharacteristics, ctx.VideoRange)
+			}
+			if ctx.MetaData != nil && ctx.Type == MediaTypeMusicVideo {
+				hdVideo := HDVideoOf(info.Width, info.Height)
+				ctx.MetaData.HDVideo = &hdVideo
+			}
+			return
+		}
+	}
+}
+
+// probeGapless derives the priming and remainder samples of the audio of songs, skipped by
+// an edit list and described by the iTunSMPB item, so that albums play without gaps.
+func (ctx *MuxHandler) probeGapless() {
+	if ctx.Type != MediaTypeSong {
+		return
+	}
+	for _, entry := range ctx.MediaPlaylistEntries {
+		gapless, err := entry.Muxer.Gapless()
+		if err != nil {
+			LOG.Warn.Printf("failed to read the gapless playback info: %v", err)
+			continue
+		}
+		if gapless == nil {
+			continue
+		}
+		// an edit list which skips no sample (e.g. for ALAC) is not written
+		if gapless.Priming != 0 || gapless.Remainder != 0 {
+			if err = entry.Muxer.SetEditList(gapless); err != nil {
+				LOG.Warn.Printf("failed to write the edit list: %v", err)
+				return
+			}
+		}
+		if ctx.MetaData != nil {
+			ctx.MetaData.Gapless = gapless
+		}
+		return
+	}
+}
+
 func (ctx *MuxHandler) applyMetadata() (err error) {
 	if ctx.MetaData != nil {
 		if err = ctx.MetaData.Attach(ctx.MediaPlaylistEntries[0].Muxer.Root); err != nil {
@@ -72,8 +184,81 @@ func (ctx *MuxHandler) applyMetadata() (err error) {
 	return
 }
 
+// timelineOrigin returns the decode time of the first media fragment, which the
+// subtitle timestamps (mapped through X-TIMESTAMP-MAP) are relative to.
+func (ctx *MuxHandler) timelineOrigin() time.Duration {
+	root := ctx.MediaPlaylistEntries[0].Muxer.Root
+	traf, err := root.P("moof[0].traf[0]")
+	if err != nil {
+		return 0
+	}
+	tfhd, err := traf[0].P("tfhd")
+	if err != nil {
+		return 0
+	}
+	tfdt, err := traf[0].P("tfdt")
+	if err != nil {
+		return 0
+	}
+	mdhd, err := root.P(fmt.Sprintf("moov.trak[tkhd.track_id=%d].mdia.mdhd", tfhd[0].Box.(*mp4.Tfhd).TrackID))
+	if err != nil || mdhd[0].Box.(*mp4.Mdhd).Timescale == 0 {
+		return 0
+	}
+	decodeTime := tfdt[0].Box.(*mp4.Tfdt).GetBaseMediaDecodeTime()
+	return time.Duration(decodeTime) * time.Second / time.Duration(mdhd[0].Box.(*mp4.Mdhd).Timescale)
+}
+
+func (ctx *MuxHandler) loadSubtitles() (err error) {
+	if len(ctx.SubtitleEntries) == 0 {
+		return
+	}
+	origin := ctx.timelineOrigin()
+	for _, entry := range ctx.SubtitleEntries {
+		var segments [][]webvtt.Cue
+		for _, filePath := range entry.FilePaths {
+			if err = func() (err error) {
+				var input *os.File
+				if input, err = os.Open(filePath); err != nil {
+					return
+				}
+				defer utils.CloseQuietly(input)
+
+				var cues []webvtt.Cue
+				if cues, err = webvtt.Parse(input); err != nil {
+					return fmt.Errorf("%s: %w", path.Base(filePath), err)
+				}
+				segments = append(segments, cues)
+				return
+			}(); err != nil {
+				return
+			}
+		}
+		entry.Cues = webvtt.Merge(segments...)
+		for idx := range entry.Cues {
+			entry.Cues[idx].Start -= origin
+			entry.Cues[idx].End -= origin
+		}
+	}
+	return
+}
+
 func (ctx *MuxHandler) mergeSegments() (err error) {
+	settings := config.Get().Mux
 	for _, entry := range ctx.MediaPlaylistEntries {
+		// a fragmented output keeps the fragments of the segments
+		if settings.Fragmented {
+			if err = entry.Muxer.Fragmentize(mp4utils.FragmentOptions{
+				Sidx: settings.Sidx,
+				Mfra: settings.Mfra,
+			}); err != nil {
+				return
+			}
+			continue
+		}
+		// audio and video chunks are interleaved so that players do not seek across the file
+		if ctx.Type == MediaTypeMusicVideo {
+			entry.Muxer.InterleaveDuration = time.Duration(config.Get().Video.InterleaveDuration) * time.Millisecond
+		}
 		if err = entry.Muxer.Desegmentize(); err != nil {
 			return
 		}
@@ -93,10 +278,123 @@ func (ctx *MuxHandler) muxTracks() (err error) {
 	return
 }
 
+func (ctx *MuxHandler) muxSubtitles() (err error) {
+	if !config.Get().Subtitles.Embed {
+		return
+	}
+	for _, entry := range ctx.SubtitleEntries {
+		if len(entry.Cues) == 0 {
+			LOG.Warn.Printf("Subtitles '%s' (%s) are empty, skipped", entry.Alternative.Name, entry.Alternative.Language)
+			continue
+		}
+
+		var spans []mp4utils.TextSample
+		for _, cue := range entry.Cues {
+			spans = append(spans, mp4utils.TextSample{
+				Start: max(cue.Start, 0),
+				End:   max(cue.End, 0),
+				Text:  webvtt.StripTags(cue.Text),
+			})
+		}
+
+		var muxer *mp4utils.MuxContext
+		if muxer, err = mp4utils.NewTextMuxContext(
+			spans,
+			ctx.Muxer.Header.Moov.Mvhd.Timescale,
+			entry.Alternative.Language,
+			entry.Alternative.Name,
+			entry.Alternative.Default,
+		); err != nil {
+			return
+		}
+		if err = ctx.Muxer.MuxTrack(muxer); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// sidecarName names the sidecar of a subtitle rendition `<base>.<language>[.sdh][.forced]`,
+// with a counter when another rendition of the same language was already given that name.
+func sidecarName(base string, alternative *m3u8.Alternative, taken map[string]int) string {
+	name := base + "." + alternative.Language
+	if strings.Contains(alternative.Characteristics, "public.accessibility.describes-music-and-sound") {
+		name += ".sdh"
+	}
+	if strings.EqualFold(alternative.Forced, "YES") {
+		name += ".forced"
+	}
+	taken[name]++
+	if count := taken[name]; count > 1 {
+		name += "." + strconv.Itoa(count)
+	}
+	return name
+}
+
+func (ctx *MuxHandler) saveSubtitleSidecars() (err error) {
+	base := strings.TrimSuffix(ctx.TargetPath, path.Ext(ctx.TargetPath))
+	taken := make(map[string]int)
+	for _, entry := range ctx.SubtitleEntries {
+		name := sidecarName(base, entry.Alternative, taken)
+		for _, format := range config.Get().Subtitles.Sidecars {
+			var write func(io.Writer, []webvtt.Cue) error
+			switch strings.ToLower(format) {
+			case "vtt":
+				write = webvtt.WriteVTT
+			case "srt":
+				write = webvtt.WriteSRT
+			default:
+				LOG.Warn.Printf("Unknown subtitle sidecar format '%s', skipped", format)
+				continue
+			}
+
+			var cues []webvtt.Cue
+			for _, cue := range entry.Cues {
+				if cue.End > 0 {
+					cue.Start = max(cue.Start, 0)
+					cues = append(cues, cue)
+				}
+			}
+
+			if err = func() (err error) {
+				var output *os.File
+				if output, err = os.Create(name + "." + strings.ToLower(format)); err != nil {
+					return
+				}
+				defer utils.CloseQuietly(output)
+				return write(output, cues)
+			}(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func pathVariables(audio *metadata.AudioInfo, videoRange VideoRange) map[string]string {
+	variables := audio.Variables()
+	variables["video_range"] = string(videoRange)
+	variables["video_range_tag"] = videoRange.Tag()
+	return variables
+}
+
+// PathVariables returns the placeholders resolved in the target path once the streams are
+// known, such as `{video_range_tag}` or `{audio_quality_tag}`.
+func (ctx *Context) PathVariables() map[string]string {
+	return pathVariables(ctx.AudioInfo, ctx.VideoRange)
+}
+
+// UnprobedPathVariables returns the placeholders of PathVariables resolved to nothing, for
+// the files whose streams are not probed.
+func UnprobedPathVariables() map[string]string {
+	return pathVariables(nil, "")
+}
+
 func (ctx *MuxHandler) finalizeMux() (err error) {
 	if len(ctx.TargetPath) == 0 {
 		return errors.New("target path is empty")
 	}
+	ctx.TargetPath = utils.Format(ctx.TargetPath, ctx.PathVariables())
 	if err = os.MkdirAll(path.Dir(ctx.TargetPath), os.ModePerm); err != nil {
 		return
 	}
@@ -113,14 +411,26 @@ func (ctx *MuxHandler) Execute() (err error) {
 	if err = ctx.initializeMux(); err != nil {
 		return
 	}
+	ctx.probeAudio()
+	ctx.probeVideo()
+	ctx.probeGapless()
 	if err = ctx.applyMetadata(); err != nil {
 		return
 	}
+	if err = ctx.loadSubtitles(); err != nil {
+		return
+	}
 	if err = ctx.mergeSegments(); err != nil {
 		return
 	}
 	if err = ctx.muxTracks(); err != nil {
 		return
 	}
-	return ctx.finalizeMux()
+	if err = ctx.muxSubtitles(); err != nil {
+		return
+	}
+	if err = ctx.finalizeMux(); err != nil {
+		return
+	}
+	return ctx.saveSubtitleSidecars()
 }
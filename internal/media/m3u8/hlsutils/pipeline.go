@@ -0,0 +1,180 @@
+package hlsutils
+
+import (
+	"downloader/internal/media/mp4/metadata"
+	"downloader/pkg/LOG"
+	"downloader/pkg/utils"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	StagePlaylist = "playlist"
+	StageDecrypt  = "decrypt"
+	StageMux      = "mux"
+)
+
+var ErrStageNotFound = errors.New("stage not found")
+
+// Stage is a named step of the pipeline, New builds its handler for the context being
+// executed.
+type Stage struct {
+	Name string
+	New  func(ctx *Context) IHandler
+}
+
+// Hook is called once the pipeline completed, with the path of the saved file and its
+// metadata (which may be nil).
+type Hook func(targetPath string, meta *metadata.Metadata) error
+
+type Pipeline struct {
+	Stages []Stage
+	Hooks  []Hook
+}
+
+func NewPipeline() *Pipeline {
+	return &Pipeline{
+		Stages: []Stage{
+			{StagePlaylist, func(ctx *Context) IHandler { return &PlaylistHandler{ctx} }},
+			{StageDecrypt, func(ctx *Context) IHandler { return &DecryptHandler{ctx} }},
+			{StageMux, func(ctx *Context) IHandler { return &MuxHandler{ctx} }},
+		},
+	}
+}
+
+var registry = NewPipeline()
+
+// Registry returns the pipeline used by contexts without a pipeline of their own.
+func Registry() *Pipeline {
+	return registry
+}
+
+func (p *Pipeline) indexOf(name string) (int, error) {
+	idx := slices.IndexFunc(p.Stages, func(stage Stage) bool { return stage.Name == name })
+	if idx < 0 {
+		return idx, fmt.Errorf("%w: %s", ErrStageNotFound, name)
+	}
+	return idx, nil
+}
+
+func (p *Pipeline) InsertBefore(name string, stage Stage) (err error) {
+	var idx int
+	if idx, err = p.indexOf(name); err != nil {
+		return
+	}
+	p.Stages = slices.Insert(p.Stages, idx, stage)
+	return
+}
+
+func (p *Pipeline) InsertAfter(name string, stage Stage) (err error) {
+	var idx int
+	if idx, err = p.indexOf(name); err != nil {
+		return
+	}
+	p.Stages = slices.Insert(p.Stages, idx+1, stage)
+	return
+}
+
+func (p *Pipeline) Remove(name string) (err error) {
+	var idx int
+	if idx, err = p.indexOf(name); err != nil {
+		return
+	}
+	p.Stages = slices.Delete(p.Stages, idx, idx+1)
+	return
+}
+
+func (p *Pipeline) AddHook(hook Hook) {
+	p.Hooks = append(p.Hooks, hook)
+}
+
+// WithHooks returns a copy of the pipeline which also runs the given hooks.
+func (p *Pipeline) WithHooks(hooks ...Hook) *Pipeline {
+	return &Pipeline{
+		Stages: slices.Clone(p.Stages),
+		Hooks:  append(slices.Clone(p.Hooks), hooks...),
+	}
+}
+
+// RunHooks runs every hook, even if some of them fail. The failures are only logged, as the
+// file is already saved.
+func (p *Pipeline) RunHooks(targetPath string, meta *metadata.Metadata) {
+	for _, hook := range p.Hooks {
+		if err := hook(targetPath, meta); err != nil {
+			LOG.Error.Printf("post-download hook failed: %v", err)
+		}
+	}
+}
+
+func (p *Pipeline) Execute(ctx *Context) (err error) {
+	defer ctx.cleanup()
+	for _, stage := range p.Stages {
+		if err = stage.New(ctx).Execute(); err != nil {
+			return
+		}
+	}
+	p.RunHooks(ctx.TargetPath, ctx.MetaData)
+	return
+}
+
+// HookVariables returns the placeholders available to the arguments of ExecHook.
+func HookVariables(targetPath string, meta *metadata.Metadata) map[string]string {
+	ext := filepath.Ext(targetPath)
+	variables := map[string]string{
+		"path": targetPath,
+		"dir":  filepath.Dir(targetPath),
+		"file": filepath.Base(targetPath),
+		"name": strings.TrimSuffix(filepath.Base(targetPath), ext),
+		"ext":  ext,
+	}
+	if meta == nil {
+		return variables
+	}
+	if meta.Audio != nil {
+		for key, value := range meta.Audio.Variables() {
+			variables[key] = value
+		}
+	}
+	for key, value := range map[string]*string{
+		"title":  meta.Title,
+		"artist": meta.ArtistName,
+		"album":  meta.AlbumName,
+	} {
+		if value != nil {
+			variables[key] = *value
+		}
+	}
+	if meta.VideoRange != nil {
+		variables["video_range"] = *meta.VideoRange
+	}
+	if meta.ItemID != nil {
+		variables["id"] = fmt.Sprint(*meta.ItemID)
+	}
+	return variables
+}
+
+// ExecHook returns a hook running an external command, whose arguments may refer to the
+// placeholders of HookVariables, such as `{path}` or `{title}`.
+func ExecHook(command string, args ...string) Hook {
+	return func(targetPath string, meta *metadata.Metadata) error {
+		variables := HookVariables(targetPath, meta)
+		var formatted []string
+		for _, arg := range args {
+			formatted = append(formatted, utils.Format(arg, variables))
+		}
+
+		LOG.Info.Printf("Running hook: %s %s", command, strings.Join(formatted, " "))
+		cmd := exec.Command(command, formatted...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %w", command, err)
+		}
+		return nil
+	}
+}
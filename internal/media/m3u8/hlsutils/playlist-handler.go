@@ -1,21 +1,31 @@
 package hlsutils
 
 import (
+	"bytes"
 	"downloader/internal/config"
 	"downloader/internal/media/m3u8/hlsutils/codec"
 	"downloader/pkg/LOG"
 	"downloader/pkg/utils"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/Spidey120703/hls-m3u8/m3u8"
 )
 
-func OpenM3U8(url string) (playlist m3u8.Playlist, listType m3u8.ListType, err error) {
+func readM3U8(url string) (data []byte, err error) {
+	if filePath, local := localPath(url); local {
+		return os.ReadFile(filePath)
+	}
+
 	resp, err := http.Get(url)
 	if err != nil {
 		return
@@ -27,7 +37,15 @@ func OpenM3U8(url string) (playlist m3u8.Playlist, listType m3u8.ListType, err e
 		return
 	}
 
-	return m3u8.DecodeFrom(resp.Body, true)
+	return io.ReadAll(resp.Body)
+}
+
+func OpenM3U8(url string) (playlist m3u8.Playlist, listType m3u8.ListType, err error) {
+	var data []byte
+	if data, err = readM3U8(url); err != nil {
+		return
+	}
+	return m3u8.DecodeFrom(bytes.NewReader(data), true)
 }
 
 func getNumPixels(resolution string) int {
@@ -43,9 +61,9 @@ func getNumPixels(resolution string) int {
 //
 //	(1) Resolution: a < b (video only)
 //	(2) FrameRate: a < b (video only)
-//	(3) Codecs: a < b (mp4v, avc1, hev1, av01 | aac, ec-3, alac)
+//	(3) Codecs: a < b (mp4v, avc1, hev1, av01, dvh1 | HE-AACv2, HE-AAC, AAC-LC, ac-3, xHE-AAC, Opus, ec-3, fLaC, alac)
 //	(4) Bandwidth: a < b
-//	(5) VideoRange: a < b (SDR, HDR)
+//	(5) VideoRange: a < b (SDR, HLG, HDR10, Dolby Vision)
 func variantLess(a, b *m3u8.Variant, isVideo bool) bool {
 
 	if isVideo {
@@ -69,18 +87,45 @@ func variantLess(a, b *m3u8.Variant, isVideo bool) bool {
 		return a.Bandwidth < b.Bandwidth
 	}
 
-	if a.VideoRange != b.VideoRange {
-		return a.VideoRange == "SDR" && b.VideoRange == "HDR"
+	if rangeDiff := variantVideoRange(a).rank() - variantVideoRange(b).rank(); rangeDiff != 0 {
+		return rangeDiff < 0
 	}
 
 	return false
 }
 
 func completeURI(base, uri string) string {
-	if !strings.HasPrefix(uri, "http") {
-		return base[:strings.LastIndex(base, "/")+1] + strings.TrimLeft(uri, "/")
+	if strings.HasPrefix(uri, "http") || strings.HasPrefix(uri, "file://") {
+		return uri
+	}
+	if isLocal(base) {
+		if filepath.IsAbs(uri) {
+			return uri
+		}
+		if !strings.HasPrefix(base, "file://") {
+			return filepath.Join(filepath.Dir(base), filepath.FromSlash(uri))
+		}
+	}
+	return base[:strings.LastIndex(base, "/")+1] + strings.TrimLeft(uri, "/")
+}
+
+// fetchSegments downloads the remote resources into dir, and returns the file path of every
+// resource, local ones being used in place.
+func (ctx *PlaylistHandler) fetchSegments(uris []string, dir string) (filePaths map[string]string, err error) {
+	var remotes []string
+	filePaths = make(map[string]string)
+	for _, uri := range uris {
+		if filePath, local := localPath(uri); local {
+			filePaths[uri] = filePath
+			continue
+		}
+		remotes = append(remotes, uri)
+		filePaths[uri] = utils.GetSavePath(uri, dir)
+	}
+	if len(remotes) != 0 {
+		err = utils.MultiDownload(remotes, dir, config.NumThreads)
 	}
-	return uri
+	return
 }
 
 type PlaylistHandler struct {
@@ -118,10 +163,17 @@ func (ctx *PlaylistHandler) extractSessionData() error {
 func (ctx *PlaylistHandler) selectVariant() (err error) {
 	var variant *m3u8.Variant
 
+	var variants []*m3u8.Variant
 	for _, v := range ctx.MasterPlaylist.Variants {
-		if v.Iframe {
-			continue
+		if !v.Iframe {
+			variants = append(variants, v)
 		}
+	}
+	if ctx.Type == MediaTypeMusicVideo || ctx.Type == MediaTypeVideoOnly {
+		variants = preferredVariants(variants)
+	}
+
+	for _, v := range variants {
 		if variant == nil || variantLess(variant, v, ctx.Type == MediaTypeMusicVideo) {
 			variant = v
 			continue
@@ -133,6 +185,18 @@ func (ctx *PlaylistHandler) selectVariant() (err error) {
 	}
 
 	ctx.Variant = variant
+	if ctx.Type == MediaTypeMusicVideo || ctx.Type == MediaTypeVideoOnly {
+		ctx.VideoRange = variantVideoRange(variant)
+		LOG.Info.Printf("Selected variant: %s %s (%s)", variant.Resolution, variant.Codecs, ctx.VideoRange)
+		if ctx.MetaData != nil && ctx.Type == MediaTypeMusicVideo && ctx.MetaData.HDVideo == nil {
+			hdVideo := HDVideo(variant.Resolution)
+			ctx.MetaData.HDVideo = &hdVideo
+		}
+		if ctx.MetaData != nil {
+			videoRange := string(ctx.VideoRange)
+			ctx.MetaData.VideoRange = &videoRange
+		}
+	}
 	ctx.MediaPlaylistEntries = append(ctx.MediaPlaylistEntries, &MediaPlaylistEntry{
 		MediaPlaylistURI: completeURI(ctx.MasterPlaylistURI, variant.URI),
 	})
@@ -142,18 +206,60 @@ func (ctx *PlaylistHandler) selectVariant() (err error) {
 	}
 
 	for _, alternative := range variant.Alternatives {
-		if len(alternative.URI) == 0 {
-			continue
+		switch alternative.Type {
+		case "SUBTITLES":
+			if len(alternative.URI) == 0 {
+				continue
+			}
+			if !config.Get().Subtitles.Embed && len(config.Get().Subtitles.Sidecars) == 0 {
+				continue
+			}
+			ctx.SubtitleEntries = append(ctx.SubtitleEntries, &SubtitleEntry{
+				Alternative:      alternative,
+				MediaPlaylistURI: completeURI(ctx.MasterPlaylistURI, alternative.URI),
+			})
+		case "CLOSED-CAPTIONS":
+			LOG.Info.Printf("Closed captions '%s' (%s) are carried in the video stream", alternative.Name, alternative.Language)
+		default:
+			if len(alternative.URI) == 0 {
+				continue
+			}
+			ctx.MediaPlaylistEntries = append(ctx.MediaPlaylistEntries, &MediaPlaylistEntry{
+				MediaPlaylistURI: completeURI(ctx.MasterPlaylistURI, alternative.URI),
+			})
 		}
-		ctx.MediaPlaylistEntries = append(ctx.MediaPlaylistEntries, &MediaPlaylistEntry{
-			MediaPlaylistURI: completeURI(ctx.MasterPlaylistURI, alternative.URI),
-		})
 	}
 	return
 }
 
 func (ctx *PlaylistHandler) loadMediaPlaylist() (err error) {
 	for _, entry := range ctx.MediaPlaylistEntries {
+		var data []byte
+		if data, err = readM3U8(entry.MediaPlaylistURI); err != nil {
+			return
+		}
+		var playlist m3u8.Playlist
+		var listType m3u8.ListType
+		playlist, listType, err = m3u8.DecodeFrom(bytes.NewReader(data), true)
+		if err != nil {
+			return
+		}
+
+		switch listType {
+		case m3u8.MASTER:
+			return errors.New("inappropriate m3u8 type")
+		case m3u8.MEDIA:
+			entry.MediaPlaylist = playlist.(*m3u8.MediaPlaylist)
+		}
+		if entry.ExplicitOffsets, err = explicitOffsets(bytes.NewReader(data)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (ctx *PlaylistHandler) loadSubtitlePlaylists() (err error) {
+	for _, entry := range ctx.SubtitleEntries {
 		var playlist m3u8.Playlist
 		var listType m3u8.ListType
 		playlist, listType, err = OpenM3U8(entry.MediaPlaylistURI)
@@ -200,9 +306,64 @@ func (ctx *PlaylistHandler) downloadSegments() (err error) {
 	LOG.Info.Println("Downloading media segments...")
 
 	for _, entry := range ctx.MediaPlaylistEntries {
-		entry.URIs = []string{
+		resolver := newSegmentResolver()
+		// the offset of the BYTERANGE attribute of EXT-X-MAP defaults to 0, rather than to the
+		// end of the previous sub-range
+		initRef := resolver.resolve(
 			completeURI(entry.MediaPlaylistURI, entry.MediaPlaylist.Map.URI),
+			entry.MediaPlaylist.Map.Offset,
+			entry.MediaPlaylist.Map.Limit,
+			true)
+		refs := []segmentRef{initRef}
+		entry.Initializations = []int{0}
+		for idx, segment := range entry.MediaPlaylist.GetAllSegments() {
+			// segments following another EXT-X-MAP (e.g. after a discontinuity switching codecs)
+			// are described by their own initialization segment
+			if segment.Map != nil {
+				ref := resolver.resolve(completeURI(entry.MediaPlaylistURI, segment.Map.URI), segment.Map.Offset, segment.Map.Limit, true)
+				if ref != initRef {
+					if segment.Discontinuity {
+						LOG.Info.Printf("Discontinuity with initialization segment: %s", path.Base(ref.URI))
+					}
+					initRef = ref
+					entry.Initializations = append(entry.Initializations, len(refs))
+					refs = append(refs, ref)
+				}
+			}
+			explicit := idx < len(entry.ExplicitOffsets) && entry.ExplicitOffsets[idx]
+			ref := resolver.resolve(completeURI(entry.MediaPlaylistURI, segment.URI), segment.Offset, segment.Limit, explicit)
+			if slices.Contains(refs, ref) {
+				continue
+			}
+			refs = append(refs, ref)
 		}
+
+		// byte-range addressed segments share a resource, which is fetched once and sliced
+		for _, ref := range refs {
+			if !slices.Contains(entry.URIs, ref.URI) {
+				entry.URIs = append(entry.URIs, ref.URI)
+			}
+		}
+
+		var filePaths map[string]string
+		if filePaths, err = ctx.fetchSegments(entry.URIs, ctx.TempDir); err != nil {
+			return
+		}
+
+		for _, ref := range refs {
+			entry.FilePaths = append(entry.FilePaths, filePaths[ref.URI])
+			entry.ByteRanges = append(entry.ByteRanges, ref.Range)
+		}
+	}
+
+	LOG.Info.Println("Download completed.")
+	return
+}
+
+func (ctx *PlaylistHandler) downloadSubtitles() (err error) {
+	for idx, entry := range ctx.SubtitleEntries {
+		LOG.Info.Printf("Downloading subtitles: %s (%s)", entry.Alternative.Name, entry.Alternative.Language)
+
 		for _, segment := range entry.MediaPlaylist.GetAllSegments() {
 			uri := completeURI(entry.MediaPlaylistURI, segment.URI)
 			if slices.Contains(entry.URIs, uri) {
@@ -211,16 +372,16 @@ func (ctx *PlaylistHandler) downloadSegments() (err error) {
 			entry.URIs = append(entry.URIs, uri)
 		}
 
-		if err = utils.MultiDownload(entry.URIs, ctx.TempDir, config.NumThreads); err != nil {
+		// the segments of every rendition share their names, e.g. fileSequence0.webvtt
+		var filePaths map[string]string
+		if filePaths, err = ctx.fetchSegments(entry.URIs, path.Join(ctx.TempDir, "subtitles", strconv.Itoa(idx))); err != nil {
 			return
 		}
 
 		for _, URI := range entry.URIs {
-			entry.FilePaths = append(entry.FilePaths, utils.GetSavePath(URI, ctx.TempDir))
+			entry.FilePaths = append(entry.FilePaths, filePaths[URI])
 		}
 	}
-
-	LOG.Info.Println("Download completed.")
 	return
 }
 
@@ -239,8 +400,14 @@ func (ctx *PlaylistHandler) Execute() (err error) {
 	if err = ctx.loadMediaPlaylist(); err != nil {
 		return
 	}
+	if err = ctx.loadSubtitlePlaylists(); err != nil {
+		return
+	}
 	if err = ctx.extractKeyURIs(); err != nil {
 		return
 	}
-	return ctx.downloadSegments()
+	if err = ctx.downloadSegments(); err != nil {
+		return
+	}
+	return ctx.downloadSubtitles()
 }
@@ -0,0 +1,121 @@
+package hlsutils
+
+import (
+	"bufio"
+	"downloader/pkg/utils"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// ByteRange is the EXT-X-BYTERANGE sub-range of a resource, a non-positive Limit
+// selects the whole resource.
+//
+// RFC 8216 Section 4.3.2.2
+type ByteRange struct {
+	Offset int64
+	Limit  int64
+}
+
+func (r ByteRange) IsWhole() bool {
+	return r.Limit <= 0
+}
+
+// segmentRef is a media segment addressed by its absolute URI and byte range.
+type segmentRef struct {
+	URI   string
+	Range ByteRange
+}
+
+// segmentResolver resolves the implicit offsets of EXT-X-BYTERANGE tags, which start
+// at the next byte after the previous sub-range of the same resource when omitted.
+type segmentResolver struct {
+	ends map[string]int64
+}
+
+func newSegmentResolver() *segmentResolver {
+	return &segmentResolver{ends: make(map[string]int64)}
+}
+
+// resolve resolves the sub-range of a segment, explicit telling whether the offset was given,
+// as an explicit offset of 0 reads the same as an omitted one.
+func (r *segmentResolver) resolve(uri string, offset, limit int64, explicit bool) segmentRef {
+	ref := segmentRef{URI: uri, Range: ByteRange{Offset: offset, Limit: limit}}
+	if ref.Range.IsWhole() {
+		ref.Range = ByteRange{}
+		return ref
+	}
+	if end, found := r.ends[uri]; found && !explicit && ref.Range.Offset == 0 {
+		ref.Range.Offset = end
+	}
+	r.ends[uri] = ref.Range.Offset + ref.Range.Limit
+	return ref
+}
+
+// explicitOffsets returns, for every media segment of a media playlist in order, whether its
+// EXT-X-BYTERANGE tag gives the offset of the sub-range, which the m3u8 package reads as 0 when
+// omitted.
+func explicitOffsets(reader io.Reader) (explicit []bool, err error) {
+	scanner := bufio.NewScanner(reader)
+	var pending bool
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			pending = strings.Contains(line, "@")
+		case len(line) == 0 || strings.HasPrefix(line, "#"):
+		default:
+			explicit = append(explicit, pending)
+			pending = false
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// openSegments opens every downloaded resource once and returns a reader per media
+// segment, restricted to its byte range. The returned files must be closed by the caller.
+func openSegments(filePaths []string, ranges []ByteRange) (inputs []io.ReadSeeker, files []*os.File, err error) {
+	if len(ranges) != 0 && len(ranges) != len(filePaths) {
+		return nil, nil, errors.New("number of byte ranges and segments do not match")
+	}
+
+	opened := make(map[string]*os.File)
+	defer func() {
+		if err != nil {
+			utils.CloseQuietlyAll(files)
+			inputs, files = nil, nil
+		}
+	}()
+
+	for idx, filePath := range filePaths {
+		file, found := opened[filePath]
+		if !found {
+			if file, err = os.Open(filePath); err != nil {
+				return
+			}
+			opened[filePath] = file
+			files = append(files, file)
+		}
+
+		if len(ranges) == 0 || ranges[idx].IsWhole() {
+			if found {
+				var info os.FileInfo
+				if info, err = file.Stat(); err != nil {
+					return
+				}
+				inputs = append(inputs, io.NewSectionReader(file, 0, info.Size()))
+			} else {
+				inputs = append(inputs, file)
+			}
+			continue
+		}
+		inputs = append(inputs, io.NewSectionReader(file, ranges[idx].Offset, ranges[idx].Limit))
+	}
+	return
+}
+
+func (entry *MediaPlaylistEntry) OpenSegments() (inputs []io.ReadSeeker, files []*os.File, err error) {
+	return openSegments(entry.FilePaths, entry.ByteRanges)
+}
@@ -0,0 +1,191 @@
+package hlsutils
+
+import (
+	"downloader/internal/config"
+	"downloader/internal/media/m3u8/hlsutils/codec"
+	"downloader/internal/media/mp4/mp4utils"
+	"downloader/pkg/LOG"
+	"fmt"
+	"strings"
+
+	"github.com/Spidey120703/hls-m3u8/m3u8"
+)
+
+type VideoRange string
+
+const (
+	VideoRangeSDR         VideoRange = "SDR"
+	VideoRangeHLG         VideoRange = "HLG"
+	VideoRangeHDR10       VideoRange = "HDR10"
+	VideoRangeDolbyVision VideoRange = "Dolby Vision"
+)
+
+// preferences of config video.range_preference
+const (
+	RangePreferenceHighest     = "highest"
+	RangePreferenceSDR         = "sdr"
+	RangePreferenceHLG         = "hlg"
+	RangePreferenceHDR10       = "hdr10"
+	RangePreferenceDolbyVision = "dolby_vision"
+)
+
+var rangePreferences = map[string]VideoRange{
+	RangePreferenceSDR:         VideoRangeSDR,
+	RangePreferenceHLG:         VideoRangeHLG,
+	RangePreferenceHDR10:       VideoRangeHDR10,
+	RangePreferenceDolbyVision: VideoRangeDolbyVision,
+}
+
+// preferences of config video.codec_preference
+const (
+	CodecPreferenceAny  = "any"
+	CodecPreferenceAVC  = "avc"
+	CodecPreferenceHEVC = "hevc"
+	CodecPreferenceAV1  = "av1"
+)
+
+var codecPreferences = map[string]codec.Family{
+	CodecPreferenceAVC:  codec.FamilyAVC,
+	CodecPreferenceHEVC: codec.FamilyHEVC,
+	CodecPreferenceAV1:  codec.FamilyAV1,
+}
+
+func (r VideoRange) rank() int {
+	switch r {
+	case VideoRangeHLG:
+		return 1
+	case VideoRangeHDR10:
+		return 2
+	case VideoRangeDolbyVision:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Tag returns the range as a file name suffix, e.g. " [Dolby Vision]", or nothing for SDR.
+func (r VideoRange) Tag() string {
+	if len(r) == 0 || r == VideoRangeSDR {
+		return ""
+	}
+	return " [" + string(r) + "]"
+}
+
+// variantVideoRange tells the dynamic range of a variant from its video codec (Dolby
+// Vision) and its VIDEO-RANGE attribute (SDR, PQ or HLG).
+func variantVideoRange(variant *m3u8.Variant) VideoRange {
+	videoCodec := strings.TrimSpace(strings.Split(variant.Codecs, ",")[0])
+	if len(videoCodec) >= 4 {
+		if indicator, err := codec.StrToCodecIndicator(videoCodec[:4]); err == nil && codec.IsDolbyVision(indicator) {
+			return VideoRangeDolbyVision
+		}
+	}
+	switch strings.ToUpper(variant.VideoRange) {
+	case "PQ", "HDR":
+		return VideoRangeHDR10
+	case "HLG":
+		return VideoRangeHLG
+	default:
+		return VideoRangeSDR
+	}
+}
+
+// variantVideoFamily tells the codec family of the video of a variant, that of the base layer
+// for Dolby Vision.
+func variantVideoFamily(variant *m3u8.Variant) (family codec.Family, ok bool) {
+	videoCodec := strings.TrimSpace(strings.Split(variant.Codecs, ",")[0])
+	if len(videoCodec) < 4 {
+		return
+	}
+	indicator, err := codec.StrToCodecIndicator(videoCodec[:4])
+	if err != nil {
+		return
+	}
+	if family, err = indicator.LookupCodecFamily(); err != nil {
+		return
+	}
+	if family == codec.FamilyDolbyVision {
+		dolbyVision := codec.DolbyVisionCodec{Codec: codec.Codec{CodecIndicator: indicator}}
+		family = dolbyVision.BaseLayer()
+	}
+	return family, true
+}
+
+// preferredVariants keeps the variants of the dynamic range, then of the codec, preferred in
+// config, e.g. SDR and HEVC for compatibility. A preference matching no variant is ignored.
+func preferredVariants(variants []*m3u8.Variant) []*m3u8.Variant {
+	rangePreference := strings.ToLower(config.Get().Video.RangePreference)
+	if len(rangePreference) != 0 && rangePreference != RangePreferenceHighest {
+		if preferred, found := rangePreferences[rangePreference]; !found {
+			LOG.Warn.Printf("Unknown video range preference '%s', ignored", rangePreference)
+		} else if matches := filterVariants(variants, func(variant *m3u8.Variant) bool {
+			return variantVideoRange(variant) == preferred
+		}); len(matches) == 0 {
+			LOG.Warn.Printf("No %s variant available, falling back to the highest range", preferred)
+		} else {
+			variants = matches
+		}
+	}
+
+	codecPreference := strings.ToLower(config.Get().Video.CodecPreference)
+	if len(codecPreference) != 0 && codecPreference != CodecPreferenceAny {
+		if preferred, found := codecPreferences[codecPreference]; !found {
+			LOG.Warn.Printf("Unknown video codec preference '%s', ignored", codecPreference)
+		} else if matches := filterVariants(variants, func(variant *m3u8.Variant) bool {
+			family, ok := variantVideoFamily(variant)
+			return ok && family == preferred
+		}); len(matches) == 0 {
+			LOG.Warn.Printf("No %s variant available, falling back to the highest codec", codecPreference)
+		} else {
+			variants = matches
+		}
+	}
+	return variants
+}
+
+func filterVariants(variants []*m3u8.Variant, keep func(*m3u8.Variant) bool) (matches []*m3u8.Variant) {
+	for _, variant := range variants {
+		if keep(variant) {
+			matches = append(matches, variant)
+		}
+	}
+	return
+}
+
+// HDVideo returns the value of the hdvd atom for a resolution: 0 (SD), 1 (720p), 2 (1080p)
+// or 3 (2160p), letterboxed resolutions counting by their width.
+func HDVideo(resolution string) uint8 {
+	var width, height uint32
+	if _, err := fmt.Sscanf(resolution, "%dx%d", &width, &height); err != nil {
+		return 0
+	}
+	return HDVideoOf(width, height)
+}
+
+func HDVideoOf(width, height uint32) uint8 {
+	switch {
+	case width >= 3840 || height >= 2160:
+		return 3
+	case width >= 1920 || height >= 1080:
+		return 2
+	case width >= 1280 || height >= 720:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// checkVideoRange tells whether the transfer characteristics of the coded stream agree with
+// the range of the variant, Dolby Vision profiles not always signalling theirs.
+func checkVideoRange(videoRange VideoRange, info *mp4utils.VideoInfo) bool {
+	switch videoRange {
+	case VideoRangeSDR:
+		return !info.IsHDR()
+	case VideoRangeHDR10:
+		return info.TransferCharacteristics == mp4utils.TransferCharacteristicsPQ
+	case VideoRangeHLG:
+		return info.TransferCharacteristics == mp4utils.TransferCharacteristicsHLG
+	default:
+		return true
+	}
+}
@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrInvalidAlignment  = errors.New("invalid alignment")
 	ErrDiscouragedReader = errors.New("discouraged reader implementation")
+	ErrInvalidExpGolomb  = errors.New("invalid exp-golomb code")
 )
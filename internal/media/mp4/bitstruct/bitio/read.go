@@ -95,3 +95,53 @@ func (r *readSeeker) Seek(offset int64, whence int) (int64, error) {
 	r.reader.width = 0
 	return n, nil
 }
+
+// ReadUint reads an unsigned integer of up to 64 bits.
+func ReadUint(r Reader, width uint) (value uint64, err error) {
+	var data []byte
+	if data, err = r.ReadBits(width); err != nil {
+		return
+	}
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return
+}
+
+// ReadUE reads an unsigned Exp-Golomb-coded integer ue(v) (ITU-T H.264 Section 9.1).
+func ReadUE(r Reader) (uint64, error) {
+	var leadingZeroBits uint
+	for {
+		bit, err := r.ReadBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit {
+			break
+		}
+		leadingZeroBits++
+		if leadingZeroBits > 63 {
+			return 0, ErrInvalidExpGolomb
+		}
+	}
+	if leadingZeroBits == 0 {
+		return 0, nil
+	}
+	suffix, err := ReadUint(r, leadingZeroBits)
+	if err != nil {
+		return 0, err
+	}
+	return 1<<leadingZeroBits - 1 + suffix, nil
+}
+
+// ReadSE reads a signed Exp-Golomb-coded integer se(v) (ITU-T H.264 Section 9.1.1).
+func ReadSE(r Reader) (int64, error) {
+	codeNum, err := ReadUE(r)
+	if err != nil {
+		return 0, err
+	}
+	if codeNum&1 == 1 {
+		return int64(codeNum/2) + 1, nil
+	}
+	return -int64(codeNum / 2), nil
+}
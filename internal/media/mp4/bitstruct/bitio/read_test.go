@@ -178,3 +178,54 @@ func TestSeekInvalidAlignment(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []byte{0x03}, data)
 }
+
+func TestReadExpGolomb(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    []byte
+		unsigned []uint64
+		signed   []int64
+	}{
+		{
+			// 1 010 011 00100 0001000
+			name:     "ue",
+			input:    []byte{0xa6, 0x41, 0x00},
+			unsigned: []uint64{0, 1, 2, 3, 7},
+		},
+		{
+			// 010 011 00100 00101 1
+			name:   "se",
+			input:  []byte{0x4c, 0x85, 0x80},
+			signed: []int64{1, -1, 2, -2, 0},
+		},
+		{
+			name:     "max",
+			input:    append(make([]byte, 7), 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe),
+			unsigned: []uint64{1<<64 - 2},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewReader(bytes.NewReader(tc.input))
+			for _, expected := range tc.unsigned {
+				value, err := ReadUE(r)
+				require.NoError(t, err)
+				assert.Equal(t, expected, value)
+			}
+			for _, expected := range tc.signed {
+				value, err := ReadSE(r)
+				require.NoError(t, err)
+				assert.Equal(t, expected, value)
+			}
+		})
+	}
+
+	t.Run("too many leading zeros", func(t *testing.T) {
+		_, err := ReadUE(NewReader(bytes.NewReader(make([]byte, 9))))
+		assert.Equal(t, ErrInvalidExpGolomb, err)
+	})
+	t.Run("overrun", func(t *testing.T) {
+		_, err := ReadUE(NewReader(bytes.NewReader([]byte{0x01})))
+		assert.Error(t, err)
+	})
+}
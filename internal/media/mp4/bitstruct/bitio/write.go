@@ -1,7 +1,10 @@
 package bitio
 
 import (
+	"encoding/binary"
 	"io"
+	"math"
+	"math/bits"
 )
 
 type Writer interface {
@@ -59,3 +62,32 @@ func (w *writer) WriteBit(bit bool) error {
 	}
 	return nil
 }
+
+// WriteUint writes the width least significant bits of an unsigned integer.
+func WriteUint(w Writer, value uint64, width uint) error {
+	return w.WriteBits(binary.BigEndian.AppendUint64(nil, value), width)
+}
+
+// WriteUE writes an unsigned Exp-Golomb-coded integer ue(v) (ITU-T H.264 Section 9.1).
+func WriteUE(w Writer, value uint64) error {
+	if value == math.MaxUint64 {
+		return ErrInvalidExpGolomb
+	}
+	width := uint(bits.Len64(value + 1))
+	if err := WriteUint(w, 0, width-1); err != nil {
+		return err
+	}
+	return WriteUint(w, value+1, width)
+}
+
+// WriteSE writes a signed Exp-Golomb-coded integer se(v) (ITU-T H.264 Section 9.1.1).
+func WriteSE(w Writer, value int64) error {
+	if value > 0 {
+		return WriteUE(w, uint64(value)*2-1)
+	}
+	// the code number of math.MinInt64 does not fit in 64 bits
+	if value == math.MinInt64 {
+		return ErrInvalidExpGolomb
+	}
+	return WriteUE(w, uint64(-value)*2)
+}
@@ -2,6 +2,7 @@ package bitio
 
 import (
 	"bytes"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -49,3 +50,22 @@ func TestWriteInvalidAlignment(t *testing.T) {
 	_, err = w.Write([]byte{0xa4, 0x6f})
 	require.Equal(t, ErrInvalidAlignment, err)
 }
+
+func TestWriteExpGolomb(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := NewWriter(buf)
+
+	// 1 010 011 00100 0001000
+	for _, value := range []uint64{0, 1, 2, 3, 7} {
+		require.NoError(t, WriteUE(w, value))
+	}
+	// 010 011 00100 00101 1
+	for _, value := range []int64{1, -1, 2, -2, 0} {
+		require.NoError(t, WriteSE(w, value))
+	}
+	require.NoError(t, WriteUint(w, 0, 6))
+	assert.Equal(t, []byte{0xa6, 0x41, 0x09, 0x90, 0xb0}, buf.Bytes())
+
+	assert.Equal(t, ErrInvalidExpGolomb, WriteUE(w, 1<<64-1))
+	assert.Equal(t, ErrInvalidExpGolomb, WriteSE(w, math.MinInt64))
+}
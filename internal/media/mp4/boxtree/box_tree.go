@@ -3,9 +3,7 @@ package boxtree
 import (
 	"errors"
 	"io"
-	"math"
 	"slices"
-	"strconv"
 	"strings"
 
 	"github.com/Spidey120703/go-mp4"
@@ -30,6 +28,7 @@ func (n *BoxNode) IsRoot() bool {
 
 func (n *BoxNode) Caching() (err error) {
 	if n.IsLeaf() {
+		n.Cache = nil
 		return
 	}
 	n.Cache = make(map[mp4.BoxType][]*BoxNode)
@@ -48,32 +47,28 @@ func (n *BoxNode) Caching() (err error) {
 	return
 }
 
+// P returns the boxes matching a path relative to the node, in the order of the file (see
+// query.go for the syntax), failing if there is none. Each step applies to every box matched
+// so far, not only to the first one: moov.trak.tkhd returns the tkhd of every track, the
+// first of which is still the one found by following the first box of each type. An index
+// applies per parent, so stsd.*[0] below moov.trak selects the first entry of each track.
 func (n *BoxNode) P(path string) (forest []*BoxNode, err error) {
-	node := n
-	parts := strings.Split(strings.Trim(path, ". "), ".")
+	var parts []string
+	if parts, err = splitOutside(strings.Trim(path, ". "), '.'); err != nil {
+		return
+	}
+	forest = []*BoxNode{n}
 	for _, p := range parts {
-		var idx = math.MinInt
-		if strings.Contains(p, "[") && strings.HasSuffix(p, "]") {
-			buf := strings.SplitN(p, "[", 2)
-			p = buf[0]
-			idx, err = strconv.Atoi(buf[1][:len(buf[1])-1])
-			if err != nil {
-				return nil, errors.New("invalid index for " + p)
-			}
-		}
-		nodes, found := node.Cache[mp4.StrToBoxType(p)]
-		if !found || len(nodes) == 0 {
-			return nil, errors.New("not found " + p)
+		var s step
+		if s, err = parseStep(p); err != nil {
+			return nil, err
 		}
-		if idx == math.MinInt {
-			forest = nodes
-			node = nodes[0]
-		} else {
-			if idx < 0 || idx >= len(nodes) {
-				return nil, errors.New("index out of range for " + p)
+		var outOfRange bool
+		if forest, outOfRange = s.apply(forest); len(forest) == 0 {
+			if outOfRange {
+				return nil, errors.New("index out of range for " + s.name)
 			}
-			forest = []*BoxNode{nodes[idx]}
-			node = nodes[idx]
+			return nil, errors.New("not found " + s.name)
 		}
 	}
 	return
@@ -149,6 +144,16 @@ func Unmarshal(reader io.ReadSeeker) (*BoxNode, error) {
 }
 
 func UnmarshalWithContext(reader io.ReadSeeker, ctx mp4.Context) (*BoxNode, error) {
+	return unmarshal(reader, ctx, false)
+}
+
+// UnmarshalStructure reads the boxes like Unmarshal, but leaves the payloads of the mdat boxes
+// in the file: their nodes have no Box, only their Info.
+func UnmarshalStructure(reader io.ReadSeeker) (*BoxNode, error) {
+	return unmarshal(reader, mp4.Context{}, true)
+}
+
+func unmarshal(reader io.ReadSeeker, ctx mp4.Context, skipMediaData bool) (*BoxNode, error) {
 	var convert = func(any []interface{}) []*BoxNode {
 		if len(any) == 0 {
 			return nil
@@ -161,6 +166,9 @@ func UnmarshalWithContext(reader io.ReadSeeker, ctx mp4.Context) (*BoxNode, erro
 	}
 	var handler = func(handle *mp4.ReadHandle) (interface{}, error) {
 		node := &BoxNode{Info: &handle.BoxInfo, Path: handle.Path}
+		if skipMediaData && handle.BoxInfo.Type == mp4.BoxTypeMdat() {
+			return node, nil
+		}
 		if payload, _, err := handle.ReadPayload(); err != nil {
 			return nil, err
 		} else {
@@ -188,6 +196,12 @@ func UnmarshalWithContext(reader io.ReadSeeker, ctx mp4.Context) (*BoxNode, erro
 }
 
 func Marshal(writer io.WriteSeeker, root *BoxNode) (n uint64, err error) {
+	return marshal(writer, root, nil)
+}
+
+// marshal writes a tree, the payloads of the boxes without Box (the mdat boxes read by
+// UnmarshalStructure) being written by payload.
+func marshal(writer io.WriteSeeker, root *BoxNode, payload func(node *BoxNode) (uint64, error)) (n uint64, err error) {
 	w := mp4.NewWriter(writer)
 
 	var handler func(*BoxNode) (uint64, error)
@@ -199,7 +213,12 @@ func Marshal(writer io.WriteSeeker, root *BoxNode) (n uint64, err error) {
 				return
 			}
 
-			if b, err = mp4.Marshal(w, node.Box, node.Info.Context); err != nil {
+			if node.Box == nil && payload != nil {
+				b, err = payload(node)
+			} else {
+				b, err = mp4.Marshal(w, node.Box, node.Info.Context)
+			}
+			if err != nil {
 				return
 			}
 			n += boxInfo.HeaderSize + b
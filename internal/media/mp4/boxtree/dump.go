@@ -0,0 +1,293 @@
+package boxtree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+type DumpOptions struct {
+	// Depth limits the levels of boxes dumped, 0 for no limit
+	Depth int
+	// Types restricts the dump to the boxes of these types, along with their ancestors and
+	// their children
+	Types []mp4.BoxType
+	// Summary replaces the entries of the sample tables with their totals
+	Summary bool
+}
+
+// DumpNode is a box as dumped, which is either described by the fields of the box or by a
+// summary of its entries.
+type DumpNode struct {
+	Type     string           `json:"type"`
+	Path     string           `json:"path"`
+	Offset   uint64           `json:"offset"`
+	Size     uint64           `json:"size"`
+	Box      json.RawMessage  `json:"box,omitempty"`
+	Summary  map[string]int64 `json:"summary,omitempty"`
+	Children []*DumpNode      `json:"children,omitempty"`
+	// Fields are the fields of the box, or its summary, as text
+	Fields string `json:"-"`
+}
+
+// ParseBoxType parses a box type as printed by mp4.BoxType.String, padded with spaces.
+func ParseBoxType(str string) (boxType mp4.BoxType, err error) {
+	str = strings.ReplaceAll(str, "(c)", "\xa9")
+	if len(str) == 0 || len(str) > 4 {
+		return boxType, errors.New("invalid box type: " + str)
+	}
+	return mp4.StrToBoxType(str + strings.Repeat(" ", 4-len(str))), nil
+}
+
+// summarize totals the entries of the sample tables.
+func summarize(box mp4.IBox) (summary map[string]int64) {
+	switch box := box.(type) {
+	case *mp4.Stts:
+		summary = map[string]int64{"entries": int64(len(box.Entries))}
+		for _, entry := range box.Entries {
+			summary["samples"] += int64(entry.SampleCount)
+			summary["duration"] += int64(entry.SampleCount) * int64(entry.SampleDelta)
+		}
+	case *mp4.Ctts:
+		summary = map[string]int64{"entries": int64(len(box.Entries))}
+		for idx, entry := range box.Entries {
+			offset := box.GetSampleOffset(idx)
+			if idx == 0 {
+				summary["min_offset"], summary["max_offset"] = offset, offset
+			}
+			summary["samples"] += int64(entry.SampleCount)
+			summary["min_offset"] = min(summary["min_offset"], offset)
+			summary["max_offset"] = max(summary["max_offset"], offset)
+		}
+	case *mp4.Stsc:
+		summary = map[string]int64{"entries": int64(len(box.Entries))}
+		for _, entry := range box.Entries {
+			summary["max_samples_per_chunk"] = max(summary["max_samples_per_chunk"], int64(entry.SamplesPerChunk))
+		}
+	case *mp4.Stsz:
+		summary = map[string]int64{"samples": int64(box.SampleCount), "total_size": int64(box.SampleSize) * int64(box.SampleCount)}
+		for _, size := range box.EntrySize {
+			summary["total_size"] += int64(size)
+			summary["max_size"] = max(summary["max_size"], int64(size))
+		}
+		if box.SampleSize != 0 {
+			summary["max_size"] = int64(box.SampleSize)
+		}
+	case *mp4.Stz2:
+		summary = map[string]int64{"samples": int64(box.SampleCount)}
+		for _, size := range box.EntrySize {
+			summary["total_size"] += int64(size)
+			summary["max_size"] = max(summary["max_size"], int64(size))
+		}
+	case *mp4.Stco:
+		summary = map[string]int64{"chunks": int64(len(box.ChunkOffset))}
+		if len(box.ChunkOffset) > 0 {
+			summary["first_offset"] = int64(box.ChunkOffset[0])
+			summary["last_offset"] = int64(box.ChunkOffset[len(box.ChunkOffset)-1])
+		}
+	case *mp4.Co64:
+		summary = map[string]int64{"chunks": int64(len(box.ChunkOffset))}
+		if len(box.ChunkOffset) > 0 {
+			summary["first_offset"] = int64(box.ChunkOffset[0])
+			summary["last_offset"] = int64(box.ChunkOffset[len(box.ChunkOffset)-1])
+		}
+	case *mp4.Stss:
+		summary = map[string]int64{"sync_samples": int64(len(box.SampleNumber))}
+	}
+	return
+}
+
+func formatSummary(summary map[string]int64) string {
+	var fields []string
+	for _, key := range slices.Sorted(maps.Keys(summary)) {
+		fields = append(fields, fmt.Sprintf("%s=%d", key, summary[key]))
+	}
+	return strings.Join(fields, " ")
+}
+
+// dumpNode describes a single box, without its children.
+func dumpNode(node *BoxNode, path string, summary bool) *DumpNode {
+	dump := &DumpNode{
+		Type:   node.Info.Type.String(),
+		Path:   path,
+		Offset: node.Info.Offset,
+		Size:   node.Info.Size,
+	}
+	if node.Info.Type == mp4.BoxTypeMdat() {
+		dump.Fields = "Data=[...]"
+		return dump
+	}
+	if node.Box == nil {
+		return dump
+	}
+	if summary {
+		if dump.Summary = summarize(node.Box); dump.Summary != nil {
+			dump.Fields = formatSummary(dump.Summary)
+			return dump
+		}
+	}
+	if fields, err := mp4.Stringify(node.Box, node.Info.Context); err == nil {
+		dump.Fields = fields
+	}
+	if raw, err := json.Marshal(node.Box); err == nil {
+		dump.Box = raw
+	}
+	return dump
+}
+
+// Dump describes the boxes of a tree, down to the given depth and restricted to the given
+// types.
+func Dump(root *BoxNode, options DumpOptions) []*DumpNode {
+	var matches func(node *BoxNode) bool
+	matches = func(node *BoxNode) bool {
+		return slices.Contains(options.Types, node.Info.Type) || slices.ContainsFunc(node.Children, matches)
+	}
+	var dump func(node *BoxNode, depth int, matched bool) []*DumpNode
+	dump = func(node *BoxNode, depth int, matched bool) (nodes []*DumpNode) {
+		if options.Depth > 0 && depth > options.Depth {
+			return
+		}
+		for _, child := range node.Children {
+			if !matched && !matches(child) {
+				continue
+			}
+			d := dumpNode(child, PathOf(child), options.Summary)
+			d.Children = dump(child, depth+1, matched || slices.Contains(options.Types, child.Info.Type))
+			nodes = append(nodes, d)
+		}
+		return
+	}
+	return dump(root, 1, len(options.Types) == 0)
+}
+
+// FormatDump renders the dumped boxes as an indented tree, as BoxNode.Stringify does.
+func FormatDump(nodes []*DumpNode) string {
+	var b strings.Builder
+	var format func(nodes []*DumpNode, depth int)
+	format = func(nodes []*DumpNode, depth int) {
+		for _, node := range nodes {
+			_, _ = fmt.Fprintf(&b, "%s[%s] Size=%d %s\n", strings.Repeat("  ", depth), node.Type, node.Size, node.Fields)
+			format(node.Children, depth+1)
+		}
+	}
+	format(nodes, 0)
+	return b.String()
+}
+
+type Change string
+
+const (
+	ChangeAdded     Change = "added"
+	ChangeRemoved   Change = "removed"
+	ChangeModified  Change = "modified"
+	ChangeReordered Change = "reordered"
+)
+
+// Difference is a box of either tree which is missing from the other one or differs from
+// its counterpart, or a box whose children come in another order.
+type Difference struct {
+	Path   string    `json:"path"`
+	Change Change    `json:"change"`
+	A      *DumpNode `json:"a,omitempty"`
+	B      *DumpNode `json:"b,omitempty"`
+}
+
+// samePayload compares the payloads of two boxes, except for the media data, which is only
+// compared by size and offset.
+func samePayload(a, b *BoxNode) bool {
+	if a.Info.Type == mp4.BoxTypeMdat() && b.Info.Type == mp4.BoxTypeMdat() {
+		return a.Info.Size == b.Info.Size && a.Info.Offset == b.Info.Offset
+	}
+	if a.Box == nil || b.Box == nil {
+		return a.Box == nil && b.Box == nil
+	}
+	var bufA, bufB bytes.Buffer
+	if _, err := mp4.Marshal(&bufA, a.Box, a.Info.Context); err != nil {
+		return false
+	}
+	if _, err := mp4.Marshal(&bufB, b.Box, b.Info.Context); err != nil {
+		return false
+	}
+	return bytes.Equal(bufA.Bytes(), bufB.Bytes())
+}
+
+// Diff compares two trees box by box, pairing the children of the same type in the order
+// they come, regardless of the other types.
+func Diff(a, b *BoxNode) (differences []Difference) {
+	var diff func(a, b *BoxNode, path string)
+	diff = func(a, b *BoxNode, path string) {
+		if a.Info != nil && !samePayload(a, b) {
+			differences = append(differences, Difference{
+				Path:   path,
+				Change: ChangeModified,
+				A:      dumpNode(a, path, false),
+				B:      dumpNode(b, path, false),
+			})
+		}
+
+		var typesA, typesB []mp4.BoxType
+		for _, child := range a.Children {
+			typesA = append(typesA, child.Info.Type)
+		}
+		for _, child := range b.Children {
+			typesB = append(typesB, child.Info.Type)
+		}
+		var types []mp4.BoxType
+		for _, boxType := range append(slices.Clone(typesA), typesB...) {
+			if !slices.Contains(types, boxType) {
+				types = append(types, boxType)
+			}
+		}
+
+		reordered := !slices.Equal(typesA, typesB)
+		for _, boxType := range types {
+			childrenA, childrenB := a.Cache[boxType], b.Cache[boxType]
+			if len(childrenA) != len(childrenB) {
+				reordered = false
+			}
+			for idx := range max(len(childrenA), len(childrenB)) {
+				childPath := strings.TrimPrefix(path+"."+boxType.String(), ".")
+				if max(len(childrenA), len(childrenB)) > 1 {
+					childPath += fmt.Sprintf("[%d]", idx)
+				}
+				switch {
+				case idx >= len(childrenB):
+					differences = append(differences, Difference{Path: childPath, Change: ChangeRemoved, A: dumpNode(childrenA[idx], childPath, true)})
+				case idx >= len(childrenA):
+					differences = append(differences, Difference{Path: childPath, Change: ChangeAdded, B: dumpNode(childrenB[idx], childPath, true)})
+				default:
+					diff(childrenA[idx], childrenB[idx], childPath)
+				}
+			}
+		}
+		if reordered {
+			differences = append(differences, Difference{Path: path, Change: ChangeReordered})
+		}
+	}
+	diff(a, b, "")
+	return
+}
+
+// FormatDiff renders the differences in the manner of a unified diff.
+func FormatDiff(differences []Difference) string {
+	var b strings.Builder
+	for _, d := range differences {
+		switch d.Change {
+		case ChangeAdded:
+			_, _ = fmt.Fprintf(&b, "+ %s\n    [%s] Size=%d %s\n", d.Path, d.B.Type, d.B.Size, d.B.Fields)
+		case ChangeRemoved:
+			_, _ = fmt.Fprintf(&b, "- %s\n    [%s] Size=%d %s\n", d.Path, d.A.Type, d.A.Size, d.A.Fields)
+		case ChangeModified:
+			_, _ = fmt.Fprintf(&b, "~ %s\n  - Size=%d %s\n  + Size=%d %s\n", d.Path, d.A.Size, d.A.Fields, d.B.Size, d.B.Fields)
+		case ChangeReordered:
+			_, _ = fmt.Fprintf(&b, "~ %s: children reordered\n", d.Path)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package boxtree
+
+import (
+	"testing"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDump(t *testing.T) {
+	root, _ := writeTestFile(t, func(tables *testTables) {})
+
+	nodes := Dump(root, DumpOptions{Depth: 2})
+	require.Len(t, nodes, 3)
+	assert.Equal(t, "moov", nodes[1].Path)
+	require.Len(t, nodes[1].Children, 2)
+	assert.Equal(t, "moov.trak", nodes[1].Children[1].Path)
+	assert.Empty(t, nodes[1].Children[1].Children)
+
+	nodes = Dump(root, DumpOptions{Types: []mp4.BoxType{mp4.BoxTypeStts()}, Summary: true})
+	require.Len(t, nodes, 1)
+	for _, boxType := range []string{"trak", "mdia", "minf", "stbl"} {
+		require.Len(t, nodes[0].Children, 1)
+		nodes = nodes[0].Children
+		assert.Equal(t, boxType, nodes[0].Type)
+	}
+	require.Len(t, nodes[0].Children, 1)
+	stts := nodes[0].Children[0]
+	assert.Equal(t, map[string]int64{"entries": 1, "samples": 4, "duration": 4096}, stts.Summary)
+	assert.Equal(t, "duration=4096 entries=1 samples=4", stts.Fields)
+	assert.Nil(t, stts.Box)
+}
+
+func TestDiff(t *testing.T) {
+	a, _ := writeTestFile(t, func(tables *testTables) {})
+	b, _ := writeTestFile(t, func(tables *testTables) {
+		tables.Stts.Entries[0].SampleDelta = 1000
+		tables.Stss = &mp4.Stss{EntryCount: 1, SampleNumber: []uint32{1}}
+	})
+
+	differences := Diff(a, b)
+	require.Len(t, differences, 4)
+	assert.Equal(t, "moov.trak.mdia.minf.stbl.stts", differences[0].Path)
+	assert.Equal(t, ChangeModified, differences[0].Change)
+	assert.Equal(t, "moov.trak.mdia.minf.stbl.stco", differences[1].Path)
+	assert.Equal(t, ChangeModified, differences[1].Change)
+	assert.Equal(t, "moov.trak.mdia.minf.stbl.stss", differences[2].Path)
+	assert.Equal(t, ChangeAdded, differences[2].Change)
+	// the media data is compared by size and offset, and moves along with the end of moov
+	assert.Equal(t, "mdat", differences[3].Path)
+	assert.Equal(t, ChangeModified, differences[3].Change)
+	assert.Equal(t, "Data=[...]", differences[3].B.Fields)
+
+	assert.Empty(t, Diff(a, a))
+}
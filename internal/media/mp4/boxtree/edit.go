@@ -0,0 +1,287 @@
+package boxtree
+
+import (
+	"downloader/pkg/utils"
+	"errors"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+// ChunkOffsets returns the offsets of the chunks of a sample table, from stco or co64.
+func ChunkOffsets(stbl *BoxNode) (offsets []uint64) {
+	if stco := childBox[*mp4.Stco](stbl, mp4.BoxTypeStco()); stco != nil {
+		for _, offset := range stco.ChunkOffset {
+			offsets = append(offsets, uint64(offset))
+		}
+	} else if co64 := childBox[*mp4.Co64](stbl, mp4.BoxTypeCo64()); co64 != nil {
+		offsets = slices.Clone(co64.ChunkOffset)
+	}
+	return
+}
+
+// SetChunkOffsets stores the offsets of the chunks of a sample table, replacing its stco box
+// by a co64 box in place when any of them does not fit in 32 bits. Boxes are never demoted,
+// so that a caller laying out the file again after a promotion eventually settles.
+func SetChunkOffsets(stbl *BoxNode, offsets []uint64) (promoted bool, err error) {
+	co64 := childBox[*mp4.Co64](stbl, mp4.BoxTypeCo64())
+	if co64 == nil && len(offsets) > 0 && slices.Max(offsets) > math.MaxUint32 {
+		idx := slices.IndexFunc(stbl.Children, func(node *BoxNode) bool {
+			return node.Info.Type == mp4.BoxTypeStco()
+		})
+		if idx < 0 {
+			return false, errors.New("stbl without stco box")
+		}
+		co64 = &mp4.Co64{}
+		stbl.Children[idx] = &BoxNode{
+			Info: &mp4.BoxInfo{Type: mp4.BoxTypeCo64()},
+			Box:  co64,
+			Path: JoinPath(stbl.Path, mp4.BoxTypeCo64()),
+		}
+		if err = stbl.Caching(); err != nil {
+			return
+		}
+		promoted = true
+	}
+
+	if co64 != nil {
+		co64.ChunkOffset = slices.Clone(offsets)
+		co64.EntryCount = uint32(len(offsets))
+		return
+	}
+	stco := childBox[*mp4.Stco](stbl, mp4.BoxTypeStco())
+	if stco == nil {
+		if len(offsets) > 0 {
+			err = errors.New("stbl without stco box")
+		}
+		return
+	}
+	stco.ChunkOffset = make([]uint32, len(offsets))
+	for idx, offset := range offsets {
+		stco.ChunkOffset[idx] = uint32(offset)
+	}
+	stco.EntryCount = uint32(len(offsets))
+	return
+}
+
+// mediaData is the payload of a mdat box as read.
+type mediaData struct {
+	Node       *BoxNode
+	Start, End uint64
+}
+
+// Editor edits a tree read from a file, keeping track of the media data, so that the chunk
+// offsets of the tracks follow the mdat boxes wherever the edits move them. The offsets of
+// the fragments (trun, tfhd, sidx, tfra) are not updated.
+type Editor struct {
+	Root      *BoxNode
+	mediaData []mediaData
+	// the chunk offsets of the sample tables as read
+	offsets map[*BoxNode][]uint64
+	// the file the tree was read from, for the payloads of the mdat boxes left in it
+	source io.ReadSeeker
+}
+
+func NewEditor(root *BoxNode) *Editor {
+	return NewEditorWithSource(root, nil)
+}
+
+// NewEditorWithSource edits a tree read by UnmarshalStructure, the payloads of the mdat boxes
+// of which are copied from source when it is written.
+func NewEditorWithSource(root *BoxNode, source io.ReadSeeker) *Editor {
+	e := &Editor{Root: root, offsets: make(map[*BoxNode][]uint64), source: source}
+	for _, mdat := range root.Cache[mp4.BoxTypeMdat()] {
+		e.mediaData = append(e.mediaData, mediaData{
+			Node:  mdat,
+			Start: mdat.Info.Offset + mdat.Info.HeaderSize,
+			End:   mdat.Info.Offset + mdat.Info.Size,
+		})
+	}
+	if stbls, err := root.P("moov.trak.mdia.minf.stbl"); err == nil {
+		for _, stbl := range stbls {
+			e.offsets[stbl] = ChunkOffsets(stbl)
+		}
+	}
+	return e
+}
+
+// payload returns the media data of a mdat box left in the source file.
+func (e *Editor) payload(node *BoxNode) (data mediaData, err error) {
+	idx := slices.IndexFunc(e.mediaData, func(data mediaData) bool {
+		return data.Node == node
+	})
+	if idx < 0 || e.source == nil {
+		return data, errors.New("no payload for " + PathOf(node))
+	}
+	return e.mediaData[idx], nil
+}
+
+// target returns the single box at a path, the root for an empty path.
+func (e *Editor) target(path string) (node *BoxNode, err error) {
+	if len(path) == 0 {
+		return e.Root, nil
+	}
+	var forest []*BoxNode
+	if forest, err = e.Root.P(path); err != nil {
+		return
+	}
+	if len(forest) != 1 {
+		return nil, errors.New("ambiguous path " + path)
+	}
+	return forest[0], nil
+}
+
+// adopt makes a subtree a child of a node, fixing its paths and caches.
+func adopt(parent *BoxNode, node *BoxNode) (err error) {
+	node.Parent = parent
+	node.Path = JoinPath(parent.Path, node.Info.Type)
+	for _, child := range node.Children {
+		if err = adopt(node, child); err != nil {
+			return
+		}
+	}
+	return node.Caching()
+}
+
+// Insert inserts a box (with its children) as the idx-th child of the box at path, or as
+// its last child when idx is negative.
+func (e *Editor) Insert(path string, idx int, node *BoxNode) (err error) {
+	var parent *BoxNode
+	if parent, err = e.target(path); err != nil {
+		return
+	}
+	if idx < 0 || idx > len(parent.Children) {
+		idx = len(parent.Children)
+	}
+	if err = adopt(parent, node); err != nil {
+		return
+	}
+	parent.Children = slices.Insert(parent.Children, idx, node)
+	return parent.Caching()
+}
+
+// Replace replaces the box at path (with its children) by another one.
+func (e *Editor) Replace(path string, node *BoxNode) (err error) {
+	var target *BoxNode
+	if target, err = e.target(path); err != nil {
+		return
+	}
+	if target.Parent == nil {
+		return errors.New("cannot replace the root")
+	}
+	parent := target.Parent
+	if err = adopt(parent, node); err != nil {
+		return
+	}
+	parent.Children[slices.Index(parent.Children, target)] = node
+	return parent.Caching()
+}
+
+// Remove removes all the boxes at path, failing if there is none.
+func (e *Editor) Remove(path string) (removed int, err error) {
+	var forest []*BoxNode
+	if forest, err = e.Root.P(path); err != nil {
+		return
+	}
+	for _, node := range forest {
+		if parent := node.Parent; parent != nil {
+			parent.Children = slices.DeleteFunc(parent.Children, func(child *BoxNode) bool {
+				return child == node
+			})
+			if err = parent.Caching(); err != nil {
+				return
+			}
+			removed++
+		}
+	}
+	return
+}
+
+// MoveMoov moves moov before the first mdat box, so that the file can be played while it is
+// downloaded, or after the last one.
+func (e *Editor) MoveMoov(beforeMediaData bool) (err error) {
+	moov := e.Root.Cache[mp4.BoxTypeMoov()]
+	mdats := e.Root.Cache[mp4.BoxTypeMdat()]
+	if len(moov) != 1 || len(mdats) == 0 {
+		return errors.New("moov and mdat boxes are required")
+	}
+	children := slices.DeleteFunc(e.Root.Children, func(node *BoxNode) bool {
+		return node == moov[0]
+	})
+	var idx int
+	if beforeMediaData {
+		idx = slices.Index(children, mdats[0])
+	} else {
+		idx = slices.Index(children, mdats[len(mdats)-1]) + 1
+	}
+	e.Root.Children = slices.Insert(children, idx, moov[0])
+	return e.Root.Caching()
+}
+
+// Layout computes the sizes and offsets of the boxes, and moves the chunk offsets along
+// with the media data.
+func (e *Editor) Layout() (err error) {
+	var shift = func(offset uint64) uint64 {
+		for _, data := range e.mediaData {
+			if offset >= data.Start && offset < data.End {
+				return offset - data.Start + data.Node.Info.Offset + data.Node.Info.HeaderSize
+			}
+		}
+		return offset
+	}
+
+	// the offsets of the mdat boxes are updated while marshalling, and once more whenever
+	// a stco box is promoted to co64
+	for promoted := true; promoted; {
+		counter := utils.NewNullWriter()
+		_, err = marshal(counter, e.Root, func(node *BoxNode) (size uint64, err error) {
+			var data mediaData
+			if data, err = e.payload(node); err != nil {
+				return
+			}
+			size = data.End - data.Start
+			counter.Skip(size)
+			return
+		})
+		utils.CloseQuietly(counter)
+		if err != nil {
+			return
+		}
+
+		promoted = false
+		for stbl, offsets := range e.offsets {
+			shifted := make([]uint64, len(offsets))
+			for idx, offset := range offsets {
+				shifted[idx] = shift(offset)
+			}
+			var p bool
+			if p, err = SetChunkOffsets(stbl, shifted); err != nil {
+				return
+			}
+			promoted = promoted || p
+		}
+	}
+	return
+}
+
+// Write lays out the tree and writes it, copying the media data left in the source file.
+func (e *Editor) Write(output io.WriteSeeker) (err error) {
+	if err = e.Layout(); err != nil {
+		return
+	}
+	_, err = marshal(output, e.Root, func(node *BoxNode) (size uint64, err error) {
+		var data mediaData
+		if data, err = e.payload(node); err != nil {
+			return
+		}
+		if _, err = e.source.Seek(int64(data.Start), io.SeekStart); err != nil {
+			return
+		}
+		var n int64
+		n, err = io.CopyN(output, e.source, int64(data.End-data.Start))
+		return uint64(n), err
+	})
+	return
+}
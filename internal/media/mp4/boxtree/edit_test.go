@@ -0,0 +1,108 @@
+package boxtree
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeEdited writes the edited tree and reads it back, checking that the file is valid and
+// that its first chunk still starts the media data.
+func writeEdited(t *testing.T, editor *Editor) *BoxNode {
+	name := filepath.Join(t.TempDir(), "edited.mp4")
+	output, err := os.Create(name)
+	require.NoError(t, err)
+	require.NoError(t, editor.Write(output))
+	require.NoError(t, output.Close())
+
+	input, err := os.Open(name)
+	require.NoError(t, err)
+	defer input.Close()
+	info, err := input.Stat()
+	require.NoError(t, err)
+	root, err := Unmarshal(input)
+	require.NoError(t, err)
+	assert.Empty(t, Verify(root, uint64(info.Size())).Issues)
+
+	stbl, err := root.P("moov.trak.mdia.minf.stbl")
+	require.NoError(t, err)
+	mdat := root.Cache[mp4.BoxTypeMdat()][0].Info
+	assert.Equal(t, []uint64{mdat.Offset + mdat.HeaderSize, mdat.Offset + mdat.HeaderSize + 30}, ChunkOffsets(stbl[0]))
+	return root
+}
+
+func TestEditor(t *testing.T) {
+	t.Run("insert, replace and remove", func(t *testing.T) {
+		root, _ := writeTestFile(t, func(tables *testTables) {})
+		editor := NewEditor(root)
+		udta := &BoxNode{Info: &mp4.BoxInfo{Type: mp4.BoxTypeUdta()}, Box: &mp4.Udta{}}
+		udta.Children = []*BoxNode{{Info: &mp4.BoxInfo{Type: mp4.BoxTypeFree()}, Box: &mp4.Free{Data: make([]byte, 100)}}}
+		require.NoError(t, editor.Insert("moov", -1, udta))
+		assert.Equal(t, mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeUdta(), mp4.BoxTypeFree()}, udta.Children[0].Path)
+		edited := writeEdited(t, editor)
+		_, err := edited.P("moov.udta.free")
+		assert.NoError(t, err)
+
+		editor = NewEditor(edited)
+		require.NoError(t, editor.Replace("moov.udta.free", &BoxNode{Info: &mp4.BoxInfo{Type: mp4.BoxTypeSkip()}, Box: &mp4.Skip{}}))
+		edited = writeEdited(t, editor)
+		_, err = edited.P("moov.udta.skip")
+		assert.NoError(t, err)
+
+		editor = NewEditor(edited)
+		removed, err := editor.Remove("moov.udta.*")
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+		edited = writeEdited(t, editor)
+		_, err = edited.P("moov.udta.*")
+		assert.EqualError(t, err, "not found *")
+	})
+
+	t.Run("move moov", func(t *testing.T) {
+		root, _ := writeTestFile(t, func(tables *testTables) {})
+		editor := NewEditor(root)
+		require.NoError(t, editor.MoveMoov(false))
+		edited := writeEdited(t, editor)
+		assert.Equal(t, mp4.BoxTypeMoov(), edited.Children[2].Info.Type)
+
+		editor = NewEditor(edited)
+		require.NoError(t, editor.MoveMoov(true))
+		edited = writeEdited(t, editor)
+		assert.Equal(t, mp4.BoxTypeMoov(), edited.Children[1].Info.Type)
+	})
+
+	t.Run("source", func(t *testing.T) {
+		root, _ := writeTestFile(t, func(tables *testTables) {})
+		data := root.Cache[mp4.BoxTypeMdat()][0].Box.(*mp4.Mdat).Data
+		for idx := range data {
+			data[idx] = byte(idx)
+		}
+		name := filepath.Join(t.TempDir(), "source.mp4")
+		output, err := os.Create(name)
+		require.NoError(t, err)
+		_, err = Marshal(output, root)
+		require.NoError(t, err)
+		require.NoError(t, output.Close())
+
+		input, err := os.Open(name)
+		require.NoError(t, err)
+		defer input.Close()
+		root, err = UnmarshalStructure(input)
+		require.NoError(t, err)
+		assert.EqualError(t, NewEditor(root).Write(output), "no payload for mdat")
+
+		editor := NewEditorWithSource(root, input)
+		require.NoError(t, editor.MoveMoov(false))
+		edited := writeEdited(t, editor)
+		assert.Equal(t, data, edited.Cache[mp4.BoxTypeMdat()][0].Box.(*mp4.Mdat).Data)
+	})
+
+	t.Run("ambiguous path", func(t *testing.T) {
+		root, _ := writeTestFile(t, func(tables *testTables) {})
+		assert.EqualError(t, NewEditor(root).Insert("**", 0, &BoxNode{Info: &mp4.BoxInfo{Type: mp4.BoxTypeFree()}, Box: &mp4.Free{}}), "ambiguous path **")
+	})
+}
@@ -0,0 +1,258 @@
+package boxtree
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+// A path is made of steps separated by dots, each of which selects the children of the
+// boxes matched so far:
+//
+//	moov.trak                              the trak boxes of moov
+//	moov.trak[1]                           the second one
+//	moov.trak[mdia.hdlr.handler_type=soun] the audio tracks
+//	moov.trak[edts]                        the tracks with an edit list
+//	moov.trak[tkhd.track_id!=1][0]         the first track but track 1
+//	moov.trak.mdia.minf.stbl.stsd.*        the sample entries of all the tracks
+//	moov.**.ilst                           the ilst boxes at any depth below moov
+//
+// The filters apply in turn, to the children of each box: an index selects one of them, a
+// path selects those which have such descendants, and a comparison those which have a
+// descendant (or themselves, without path) with a field of the given value. The fields are
+// named in snake case or as in go-mp4, and compared as text: byte arrays as strings, numbers
+// in decimal. The recursive step ** takes no filter.
+
+const (
+	stepAny       = "*"
+	stepRecursive = "**"
+)
+
+type filter struct {
+	index     int
+	isIndex   bool
+	path      string
+	field     string
+	value     string
+	negate    bool
+	isCompare bool
+}
+
+type step struct {
+	name    string
+	any     bool
+	boxType mp4.BoxType
+	filters []filter
+}
+
+// splitOutside splits a string on a separator found outside of brackets.
+func splitOutside(str string, sep byte) (parts []string, err error) {
+	var depth, start int
+	for idx := 0; idx < len(str); idx++ {
+		switch str[idx] {
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth < 0 {
+				return nil, errors.New("unbalanced brackets in " + str)
+			}
+		case sep:
+			if depth == 0 {
+				parts = append(parts, str[start:idx])
+				start = idx + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced brackets in " + str)
+	}
+	return append(parts, str[start:]), nil
+}
+
+func parseFilter(str string) (f filter, err error) {
+	if index, err := strconv.Atoi(str); err == nil {
+		return filter{index: index, isIndex: true}, nil
+	}
+	var depth int
+	for idx := 0; idx < len(str); idx++ {
+		switch str[idx] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '=':
+			if depth != 0 {
+				continue
+			}
+			f.isCompare = true
+			f.path, f.value = str[:idx], strings.Trim(str[idx+1:], `"'`)
+			if f.negate = strings.HasSuffix(f.path, "!"); f.negate {
+				f.path = f.path[:len(f.path)-1]
+			}
+			if dot := strings.LastIndexByte(f.path, '.'); dot >= 0 {
+				f.path, f.field = f.path[:dot], f.path[dot+1:]
+			} else {
+				f.path, f.field = "", f.path
+			}
+			if len(f.field) == 0 {
+				return f, errors.New("invalid filter " + str)
+			}
+			return
+		}
+	}
+	if len(str) == 0 {
+		return f, errors.New("empty filter")
+	}
+	f.path = str
+	return
+}
+
+func parseStep(str string) (s step, err error) {
+	s.name = str
+	if idx := strings.IndexByte(str, '['); idx >= 0 {
+		if !strings.HasSuffix(str, "]") {
+			return s, errors.New("invalid filter for " + str)
+		}
+		s.name = str[:idx]
+		var depth, start int
+		for i := idx; i < len(str); i++ {
+			switch str[i] {
+			case '[':
+				if depth++; depth == 1 {
+					start = i + 1
+				}
+			case ']':
+				if depth--; depth == 0 {
+					var f filter
+					if f, err = parseFilter(str[start:i]); err != nil {
+						return
+					}
+					s.filters = append(s.filters, f)
+				}
+			}
+		}
+	}
+	switch s.name {
+	case stepAny, stepRecursive:
+		s.any = true
+	default:
+		s.boxType, err = ParseBoxType(s.name)
+	}
+	return
+}
+
+func fieldMatches(node *BoxNode, name string, value string) (matched bool, found bool) {
+	if node.Box == nil {
+		return
+	}
+	v := reflect.ValueOf(node.Box)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	name = strings.ReplaceAll(name, "_", "")
+	field := v.FieldByNameFunc(func(fieldName string) bool {
+		return strings.EqualFold(fieldName, name)
+	})
+	if !field.IsValid() {
+		return
+	}
+	return formatField(field) == value, true
+}
+
+func formatField(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return strings.TrimRight(string(b), "\x00")
+		}
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func (f filter) matches(node *BoxNode) bool {
+	targets := []*BoxNode{node}
+	if len(f.path) != 0 {
+		var err error
+		if targets, err = node.P(f.path); err != nil {
+			return false
+		}
+	}
+	if !f.isCompare {
+		return true
+	}
+	for _, target := range targets {
+		if matched, found := fieldMatches(target, f.field, f.value); found && matched != f.negate {
+			return true
+		}
+	}
+	return false
+}
+
+func (s step) apply(nodes []*BoxNode) (matched []*BoxNode, outOfRange bool) {
+	if s.name == stepRecursive {
+		// the boxes themselves and all of their descendants, the children of which the next
+		// step selects
+		seen := make(map[*BoxNode]bool)
+		var walk func(node *BoxNode)
+		walk = func(node *BoxNode) {
+			if seen[node] {
+				return
+			}
+			seen[node] = true
+			matched = append(matched, node)
+			for _, child := range node.Children {
+				walk(child)
+			}
+		}
+		for _, node := range nodes {
+			walk(node)
+		}
+		return
+	}
+	for _, node := range nodes {
+		children := node.Cache[s.boxType]
+		if s.any {
+			children = node.Children
+		}
+		for _, f := range s.filters {
+			if f.isIndex {
+				if f.index < 0 || f.index >= len(children) {
+					outOfRange = true
+					children = nil
+				} else {
+					children = children[f.index : f.index+1]
+				}
+				continue
+			}
+			var filtered []*BoxNode
+			for _, child := range children {
+				if f.matches(child) {
+					filtered = append(filtered, child)
+				}
+			}
+			children = filtered
+		}
+		matched = append(matched, children...)
+	}
+	return
+}
@@ -0,0 +1,93 @@
+package boxtree
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newQueryTree(t *testing.T) *BoxNode {
+	root := &BoxNode{}
+	require.NoError(t, root.Append(mp4.BoxTypeMoov(), &mp4.Moov{}))
+	moov := root.Children[0]
+	for idx, handlerType := range []string{"vide", "soun", "soun"} {
+		require.NoError(t, moov.Append(mp4.BoxTypeTrak(), &mp4.Trak{}))
+		trak := moov.Children[idx]
+		require.NoError(t, trak.Append(mp4.BoxTypeTkhd(), &mp4.Tkhd{TrackID: uint32(idx + 1)}))
+		require.NoError(t, trak.Append(mp4.BoxTypeMdia(), &mp4.Mdia{}))
+		require.NoError(t, trak.Children[1].Append(mp4.BoxTypeHdlr(), &mp4.Hdlr{HandlerType: [4]byte([]byte(handlerType))}))
+		if idx == 2 {
+			require.NoError(t, trak.Append(mp4.BoxTypeEdts(), &mp4.Edts{}))
+		}
+	}
+	require.NoError(t, moov.Append(mp4.BoxTypeUdta(), &mp4.Udta{}))
+	require.NoError(t, moov.Children[3].Append(mp4.BoxTypeMeta(), &mp4.Meta{}))
+	require.NoError(t, moov.Children[3].Children[0].Append(mp4.BoxTypeIlst(), &mp4.Ilst{}))
+	return root
+}
+
+func TestP(t *testing.T) {
+	root := newQueryTree(t)
+	for _, tt := range []struct {
+		path     string
+		expected []string
+		err      string
+	}{
+		{path: "moov.trak", expected: []string{"moov.trak[0]", "moov.trak[1]", "moov.trak[2]"}},
+		{path: "moov.trak[1]", expected: []string{"moov.trak[1]"}},
+		{path: "moov.trak.tkhd", expected: []string{"moov.trak[0].tkhd", "moov.trak[1].tkhd", "moov.trak[2].tkhd"}},
+		{path: "moov.trak[mdia.hdlr.handler_type=soun]", expected: []string{"moov.trak[1]", "moov.trak[2]"}},
+		{path: "moov.trak[mdia.hdlr.HandlerType='soun'][1].tkhd", expected: []string{"moov.trak[2].tkhd"}},
+		{path: "moov.trak[tkhd.track_id!=1][edts]", expected: []string{"moov.trak[2]"}},
+		{path: "moov.trak.mdia.hdlr[handler_type=vide]", expected: []string{"moov.trak[0].mdia.hdlr"}},
+		{path: "moov.*[0]", expected: []string{"moov.trak[0]"}},
+		{path: "moov.udta.*", expected: []string{"moov.udta.meta"}},
+		{path: "**.ilst", expected: []string{"moov.udta.meta.ilst"}},
+		{path: "moov.**.hdlr[handler_type=vide]", expected: []string{"moov.trak[0].mdia.hdlr"}},
+		{path: "moov.trak[3]", err: "index out of range for trak"},
+		{path: "moov.trak[mdia.hdlr.handler_type=text]", err: "not found trak"},
+		{path: "moov.mvhd", err: "not found mvhd"},
+		{path: "moov.trak[mdia", err: "unbalanced brackets in moov.trak[mdia"},
+		{path: "moov.trak[=soun]", err: "invalid filter =soun"},
+		{path: "moov.track", err: "invalid box type: track"},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			forest, err := root.P(tt.path)
+			if len(tt.err) != 0 {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			var paths []string
+			for _, node := range forest {
+				paths = append(paths, PathOf(node))
+			}
+			assert.Equal(t, tt.expected, paths)
+		})
+	}
+}
+
+func TestPFirstMatch(t *testing.T) {
+	root := newQueryTree(t)
+	// a plain path still matches first the box found by following the first box of each type
+	for _, path := range []string{"moov.trak", "moov.trak.tkhd", "moov.trak.mdia.hdlr", "moov.udta.meta.ilst"} {
+		t.Run(path, func(t *testing.T) {
+			node := root
+			for _, name := range strings.Split(path, ".") {
+				nodes := node.Cache[mp4.StrToBoxType(name)]
+				require.NotEmpty(t, nodes)
+				node = nodes[0]
+			}
+			forest, err := root.P(path)
+			require.NoError(t, err)
+			assert.Same(t, node, forest[0])
+		})
+	}
+
+	forest, err := root.P("moov.trak.mdia.hdlr[0]")
+	require.NoError(t, err)
+	assert.Len(t, forest, 3)
+}
@@ -0,0 +1,547 @@
+package boxtree
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue is a violation of ISO/IEC 14496-12 (or of the iTunes metadata conventions) found in
+// a box, which is addressed by a path in the syntax of BoxNode.P.
+type Issue struct {
+	Severity Severity `json:"severity"`
+	Path     string   `json:"path"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+}
+
+type Report struct {
+	File     string  `json:"file,omitempty"`
+	Size     uint64  `json:"size"`
+	Errors   int     `json:"errors"`
+	Warnings int     `json:"warnings"`
+	Issues   []Issue `json:"issues"`
+}
+
+func (r *Report) Valid() bool {
+	return r.Errors == 0
+}
+
+// alternativeBoxes may stand in for the mandatory boxes of MandatoryBoxes
+var alternativeBoxes = map[mp4.BoxType][]mp4.BoxType{
+	mp4.BoxTypeStco(): {mp4.BoxTypeCo64()},
+}
+
+// singletonBoxes occur at most once in their parent
+var singletonBoxes = []mp4.BoxType{
+	mp4.BoxTypeFtyp(), mp4.BoxTypeMoov(),
+	mp4.BoxTypeMvhd(), mp4.BoxTypeMvex(), mp4.BoxTypeMehd(),
+	mp4.BoxTypeTkhd(), mp4.BoxTypeEdts(), mp4.BoxTypeElst(), mp4.BoxTypeMdia(),
+	mp4.BoxTypeMdhd(), mp4.BoxTypeHdlr(), mp4.BoxTypeMinf(), mp4.BoxTypeDinf(), mp4.BoxTypeStbl(),
+	mp4.BoxTypeStsd(), mp4.BoxTypeStts(), mp4.BoxTypeCtts(), mp4.BoxTypeStsc(), mp4.BoxTypeStsz(),
+	mp4.BoxTypeStco(), mp4.BoxTypeCo64(), mp4.BoxTypeStss(),
+	mp4.BoxTypeMfhd(), mp4.BoxTypeTfhd(), mp4.BoxTypeTfdt(), mp4.BoxTypeMfro(),
+	mp4.BoxTypeIlst(),
+}
+
+// headerBoxes come first in their parent
+var headerBoxes = map[mp4.BoxType]mp4.BoxType{
+	mp4.BoxTypeMoov(): mp4.BoxTypeMvhd(),
+	mp4.BoxTypeTrak(): mp4.BoxTypeTkhd(),
+	mp4.BoxTypeMdia(): mp4.BoxTypeMdhd(),
+	mp4.BoxTypeMoof(): mp4.BoxTypeMfhd(),
+	mp4.BoxTypeTraf(): mp4.BoxTypeTfhd(),
+}
+
+var (
+	boxTypeStz2     = mp4.StrToBoxType("stz2")
+	boxTypeFreeform = mp4.StrToBoxType("----")
+	boxTypeMean     = mp4.StrToBoxType("mean")
+	boxTypeName     = mp4.StrToBoxType("name")
+	handlerTypeMdir = [4]byte{'m', 'd', 'i', 'r'}
+)
+
+type verifier struct {
+	report *Report
+	// payload ranges of the top-level mdat boxes
+	mdats [][2]uint64
+}
+
+func (v *verifier) add(severity Severity, node *BoxNode, code string, format string, args ...any) {
+	v.report.Issues = append(v.report.Issues, Issue{
+		Severity: severity,
+		Path:     PathOf(node),
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	switch severity {
+	case SeverityError:
+		v.report.Errors++
+	case SeverityWarning:
+		v.report.Warnings++
+	}
+}
+
+// PathOf returns the path of a node in the syntax of BoxNode.P, indexing the boxes which
+// have siblings of the same type.
+func PathOf(node *BoxNode) string {
+	var parts []string
+	for ; node != nil && node.Info != nil; node = node.Parent {
+		part := node.Info.Type.String()
+		if node.Parent != nil {
+			if siblings := node.Parent.Cache[node.Info.Type]; len(siblings) > 1 {
+				part += "[" + strconv.Itoa(slices.Index(siblings, node)) + "]"
+			}
+		}
+		parts = append(parts, part)
+	}
+	slices.Reverse(parts)
+	return strings.Join(parts, ".")
+}
+
+func child(node *BoxNode, boxType mp4.BoxType) *BoxNode {
+	if nodes := node.Cache[boxType]; len(nodes) > 0 {
+		return nodes[0]
+	}
+	return nil
+}
+
+func childBox[T mp4.IBox](node *BoxNode, boxType mp4.BoxType) (box T) {
+	if node != nil {
+		if c := child(node, boxType); c != nil {
+			box, _ = c.Box.(T)
+		}
+	}
+	return
+}
+
+// Verify checks the structure of a file of the given size, as read by Unmarshal: the
+// mandatory boxes and their order, the sizes of the boxes, the sample tables and the
+// durations of the tracks, and the iTunes metadata.
+func Verify(root *BoxNode, size uint64) *Report {
+	v := &verifier{report: &Report{Size: size, Issues: []Issue{}}}
+	for _, mdat := range root.Cache[mp4.BoxTypeMdat()] {
+		v.mdats = append(v.mdats, [2]uint64{mdat.Info.Offset + mdat.Info.HeaderSize, mdat.Info.Offset + mdat.Info.Size})
+	}
+
+	v.verifyOrder(root)
+	v.verifyNode(root, size)
+	if moov := child(root, mp4.BoxTypeMoov()); moov != nil {
+		v.verifyDurations(moov)
+		for _, trak := range moov.Cache[mp4.BoxTypeTrak()] {
+			v.verifySampleTable(trak)
+		}
+	}
+	v.verifyIlst(root)
+	return v.report
+}
+
+func (v *verifier) verifyOrder(root *BoxNode) {
+	if len(root.Children) == 0 {
+		v.add(SeverityError, root, "missing-box", "empty file")
+		return
+	}
+	if root.Children[0].Info.Type != mp4.BoxTypeFtyp() {
+		v.add(SeverityError, root.Children[0], "box-order", "ftyp must be the first box of the file")
+	}
+	moov := slices.IndexFunc(root.Children, func(node *BoxNode) bool {
+		return node.Info.Type == mp4.BoxTypeMoov()
+	})
+	for idx, node := range root.Children {
+		switch node.Info.Type {
+		case mp4.BoxTypeMoof():
+			if moov < 0 || idx < moov {
+				v.add(SeverityError, node, "box-order", "moof precedes moov")
+			}
+		case mp4.BoxTypeMfra():
+			if idx != len(root.Children)-1 {
+				v.add(SeverityWarning, node, "box-order", "mfra should be the last box of the file")
+			}
+		}
+	}
+}
+
+// verifyNode checks the children of a node, and that they fill it along with its payload.
+func (v *verifier) verifyNode(node *BoxNode, size uint64) {
+	var boxType = BoxTypeRoot
+	var begin, end uint64 = 0, size
+	if node.Info != nil {
+		boxType = node.Info.Type
+		begin, end = node.Info.Offset+node.Info.HeaderSize, node.Info.Offset+node.Info.Size
+		if node.Info.Size < node.Info.HeaderSize {
+			v.add(SeverityError, node, "box-size", "size %d is smaller than the header (%d bytes)", node.Info.Size, node.Info.HeaderSize)
+			return
+		}
+	}
+
+	for _, mandatory := range MandatoryBoxes[boxType] {
+		if len(node.Cache[mandatory]) == 0 && !slices.ContainsFunc(alternativeBoxes[mandatory], func(alternative mp4.BoxType) bool {
+			return len(node.Cache[alternative]) > 0
+		}) {
+			v.add(SeverityError, node, "missing-box", "missing %s", mandatory)
+		}
+	}
+	if len(node.Cache[mp4.BoxTypeStco()]) > 0 && len(node.Cache[mp4.BoxTypeCo64()]) > 0 {
+		v.add(SeverityError, node, "duplicate-box", "both stco and co64")
+	}
+	for _, singleton := range singletonBoxes {
+		if nodes := node.Cache[singleton]; len(nodes) > 1 {
+			v.add(SeverityError, nodes[1], "duplicate-box", "%s occurs %d times", singleton, len(nodes))
+		}
+	}
+	if header, found := headerBoxes[boxType]; found && len(node.Children) > 0 && node.Children[0].Info.Type != header && len(node.Cache[header]) > 0 {
+		v.add(SeverityWarning, node.Cache[header][0], "box-order", "%s should be the first box of %s", header, boxType)
+	}
+
+	// a box is made of its header, its payload and its children, back to back
+	if node.Info != nil && node.Info.Size > 0 {
+		var offset = begin
+		switch {
+		case boxType == mp4.BoxTypeMdat() || boxType == mp4.BoxTypeFree() || boxType == mp4.BoxTypeSkip() || node.Box == nil || !node.Info.IsSupportedType():
+			offset = end
+			if len(node.Children) > 0 {
+				offset = node.Children[0].Info.Offset
+			}
+		default:
+			n, err := mp4.Marshal(io.Discard, node.Box, node.Info.Context)
+			if err != nil {
+				v.add(SeverityError, node, "box-size", "invalid payload: %v", err)
+				return
+			}
+			offset += n
+		}
+		for _, c := range node.Children {
+			if c.Info.Offset != offset {
+				v.add(SeverityError, c, "box-size", "box at offset %d, expected at %d", c.Info.Offset, offset)
+			}
+			offset = c.Info.Offset + c.Info.Size
+		}
+		if offset != end {
+			v.add(SeverityError, node, "box-size", "size %d, contents end %d bytes from the start", node.Info.Size, offset-node.Info.Offset)
+		}
+	} else if node.Info == nil && size > 0 {
+		var offset uint64
+		for _, c := range node.Children {
+			if c.Info.Offset != offset {
+				v.add(SeverityError, c, "box-size", "box at offset %d, expected at %d", c.Info.Offset, offset)
+			}
+			offset = c.Info.Offset + c.Info.Size
+		}
+		if offset != end {
+			v.add(SeverityError, node, "box-size", "boxes end at %d, file size %d", offset, end)
+		}
+	}
+
+	for _, c := range node.Children {
+		v.verifyNode(c, size)
+	}
+}
+
+// sampleSizes returns the sizes of the samples of a sample table, from stsz or stz2.
+func sampleSizes(stbl *BoxNode) (sizes []uint32, found bool) {
+	if stsz := childBox[*mp4.Stsz](stbl, mp4.BoxTypeStsz()); stsz != nil {
+		if stsz.SampleSize != 0 {
+			sizes = make([]uint32, stsz.SampleCount)
+			for idx := range sizes {
+				sizes[idx] = stsz.SampleSize
+			}
+			return sizes, true
+		}
+		return stsz.EntrySize, true
+	}
+	if stz2 := childBox[*mp4.Stz2](stbl, boxTypeStz2); stz2 != nil {
+		for _, size := range stz2.EntrySize {
+			sizes = append(sizes, uint32(size))
+		}
+		return sizes, true
+	}
+	return nil, false
+}
+
+// selfContained tells whether the media data of a track is in the file itself.
+func selfContained(trak *BoxNode) bool {
+	dref, err := trak.P("mdia.minf.dinf.dref")
+	if err != nil {
+		return true
+	}
+	// the flag 1 of url marks the data as in the same file, urn always points elsewhere
+	return !slices.ContainsFunc(dref[0].Children, func(entry *BoxNode) bool {
+		return entry.Info.Type == mp4.BoxTypeUrn() || (entry.Info.Type == mp4.BoxTypeUrl() && entry.Box != nil && entry.Box.GetFlags()&1 == 0)
+	})
+}
+
+func (v *verifier) verifySampleTable(trak *BoxNode) {
+	nodes, err := trak.P("mdia.minf.stbl")
+	if err != nil {
+		return
+	}
+	stbl := nodes[0]
+
+	sizes, found := sampleSizes(stbl)
+	if !found {
+		v.add(SeverityError, stbl, "missing-box", "missing stsz or stz2")
+	}
+	if stsz := childBox[*mp4.Stsz](stbl, mp4.BoxTypeStsz()); stsz != nil && stsz.SampleSize == 0 && uint32(len(stsz.EntrySize)) != stsz.SampleCount {
+		v.add(SeverityError, child(stbl, mp4.BoxTypeStsz()), "sample-count", "%d sample sizes for %d samples", len(stsz.EntrySize), stsz.SampleCount)
+	}
+	sampleCount := uint64(len(sizes))
+
+	if stts := childBox[*mp4.Stts](stbl, mp4.BoxTypeStts()); stts != nil {
+		var total uint64
+		for _, entry := range stts.Entries {
+			total += uint64(entry.SampleCount)
+		}
+		if found && total != sampleCount {
+			v.add(SeverityError, child(stbl, mp4.BoxTypeStts()), "sample-count", "stts describes %d samples, the sample sizes %d", total, sampleCount)
+		}
+	}
+
+	if ctts := childBox[*mp4.Ctts](stbl, mp4.BoxTypeCtts()); ctts != nil {
+		node := child(stbl, mp4.BoxTypeCtts())
+		var total uint64
+		for _, entry := range ctts.Entries {
+			total += uint64(entry.SampleCount)
+		}
+		if found && total != sampleCount {
+			v.add(SeverityError, node, "sample-count", "ctts describes %d samples, the sample sizes %d", total, sampleCount)
+		}
+		switch ctts.GetVersion() {
+		case 0:
+			// an offset beyond 2^31 is a negative offset written without version 1
+			if idx := slices.IndexFunc(ctts.Entries, func(entry mp4.CttsEntry) bool {
+				return int32(entry.SampleOffsetV0) < 0
+			}); idx >= 0 {
+				v.add(SeverityError, node, "ctts-version", "negative composition offset %d in entry %d of a version 0 ctts", int32(ctts.Entries[idx].SampleOffsetV0), idx)
+			}
+		case 1:
+		default:
+			v.add(SeverityError, node, "ctts-version", "unsupported version %d", ctts.GetVersion())
+		}
+	}
+
+	if stss := childBox[*mp4.Stss](stbl, mp4.BoxTypeStss()); stss != nil {
+		node := child(stbl, mp4.BoxTypeStss())
+		if len(stss.SampleNumber) == 0 && sampleCount > 0 {
+			v.add(SeverityWarning, node, "stss", "no sync sample")
+		}
+		for idx, number := range stss.SampleNumber {
+			if number == 0 || (found && uint64(number) > sampleCount) {
+				v.add(SeverityError, node, "stss", "sync sample %d out of the %d samples", number, sampleCount)
+				break
+			}
+			if idx > 0 && number <= stss.SampleNumber[idx-1] {
+				v.add(SeverityError, node, "stss", "sync sample %d does not follow %d", number, stss.SampleNumber[idx-1])
+				break
+			}
+		}
+	}
+
+	var offsets []uint64
+	if stco := childBox[*mp4.Stco](stbl, mp4.BoxTypeStco()); stco != nil {
+		for _, offset := range stco.ChunkOffset {
+			offsets = append(offsets, uint64(offset))
+		}
+	} else if co64 := childBox[*mp4.Co64](stbl, mp4.BoxTypeCo64()); co64 != nil {
+		offsets = co64.ChunkOffset
+	}
+	stsc := childBox[*mp4.Stsc](stbl, mp4.BoxTypeStsc())
+	if stsc == nil || !found {
+		return
+	}
+	node := child(stbl, mp4.BoxTypeStsc())
+	var entryCount uint32
+	if stsd := childBox[*mp4.Stsd](stbl, mp4.BoxTypeStsd()); stsd != nil {
+		entryCount = stsd.EntryCount
+	}
+	chunkCount := uint32(len(offsets))
+	var covered uint64
+	for idx, entry := range stsc.Entries {
+		switch {
+		case idx == 0 && entry.FirstChunk != 1:
+			v.add(SeverityError, node, "chunk-coverage", "the first entry starts at chunk %d", entry.FirstChunk)
+			return
+		case idx > 0 && entry.FirstChunk <= stsc.Entries[idx-1].FirstChunk:
+			v.add(SeverityError, node, "chunk-coverage", "entry %d starts at chunk %d, not after %d", idx, entry.FirstChunk, stsc.Entries[idx-1].FirstChunk)
+			return
+		case entry.FirstChunk > chunkCount:
+			v.add(SeverityError, node, "chunk-coverage", "entry %d starts at chunk %d, beyond the %d chunks", idx, entry.FirstChunk, chunkCount)
+			return
+		case entry.SamplesPerChunk == 0:
+			v.add(SeverityError, node, "chunk-coverage", "entry %d has empty chunks", idx)
+		case entry.SampleDescriptionIndex == 0 || entry.SampleDescriptionIndex > entryCount:
+			v.add(SeverityError, node, "chunk-coverage", "entry %d refers to sample description %d of %d", idx, entry.SampleDescriptionIndex, entryCount)
+		}
+		last := chunkCount + 1
+		if idx+1 < len(stsc.Entries) {
+			last = stsc.Entries[idx+1].FirstChunk
+		}
+		covered += uint64(last-entry.FirstChunk) * uint64(entry.SamplesPerChunk)
+	}
+	if covered != sampleCount {
+		v.add(SeverityError, node, "chunk-coverage", "%d chunks hold %d samples, the sample sizes %d", chunkCount, covered, sampleCount)
+		return
+	}
+
+	if !selfContained(trak) {
+		return
+	}
+	var sample uint64
+	for idx, entry := range stsc.Entries {
+		last := chunkCount + 1
+		if idx+1 < len(stsc.Entries) {
+			last = stsc.Entries[idx+1].FirstChunk
+		}
+		for chunk := entry.FirstChunk; chunk < last; chunk++ {
+			var size uint64
+			for _, s := range sizes[sample : sample+uint64(entry.SamplesPerChunk)] {
+				size += uint64(s)
+			}
+			sample += uint64(entry.SamplesPerChunk)
+			begin := offsets[chunk-1]
+			if !slices.ContainsFunc(v.mdats, func(mdat [2]uint64) bool {
+				return mdat[0] <= begin && begin+size <= mdat[1]
+			}) {
+				v.add(SeverityError, stbl, "chunk-offset", "chunk %d (%d bytes at offset %d) is outside of mdat", chunk, size, begin)
+				return
+			}
+		}
+	}
+}
+
+// agree tells whether two durations are equal, but for rounding.
+func agree(a, b uint64) bool {
+	return max(a, b)-min(a, b) <= 1
+}
+
+func (v *verifier) verifyDurations(moov *BoxNode) {
+	mvhd := childBox[*mp4.Mvhd](moov, mp4.BoxTypeMvhd())
+	if mvhd == nil {
+		return
+	}
+	if mvhd.Timescale == 0 {
+		v.add(SeverityError, child(moov, mp4.BoxTypeMvhd()), "duration", "zero timescale")
+		return
+	}
+	// the durations of fragmented files are those of mehd and of the fragments
+	fragmented := child(moov, mp4.BoxTypeMvex()) != nil
+
+	var longest uint64
+	for _, trak := range moov.Cache[mp4.BoxTypeTrak()] {
+		tkhd := childBox[*mp4.Tkhd](trak, mp4.BoxTypeTkhd())
+		mdia := child(trak, mp4.BoxTypeMdia())
+		mdhd := childBox[*mp4.Mdhd](mdia, mp4.BoxTypeMdhd())
+		if tkhd == nil || mdhd == nil {
+			continue
+		}
+		longest = max(longest, tkhd.GetDuration())
+		if mdhd.Timescale == 0 {
+			v.add(SeverityError, child(mdia, mp4.BoxTypeMdhd()), "duration", "zero timescale")
+			continue
+		}
+		if fragmented {
+			continue
+		}
+
+		if nodes, err := mdia.P("minf.stbl.stts"); err == nil {
+			var total uint64
+			if stts, ok := nodes[0].Box.(*mp4.Stts); ok {
+				for _, entry := range stts.Entries {
+					total += uint64(entry.SampleCount) * uint64(entry.SampleDelta)
+				}
+			}
+			if total != mdhd.GetDuration() {
+				v.add(SeverityError, child(mdia, mp4.BoxTypeMdhd()), "duration", "duration %d, the samples last %d", mdhd.GetDuration(), total)
+			}
+		}
+
+		expected := mdhd.GetDuration() * uint64(mvhd.Timescale) / uint64(mdhd.Timescale)
+		if elst, err := trak.P("edts.elst"); err == nil {
+			expected = 0
+			if elst, ok := elst[0].Box.(*mp4.Elst); ok {
+				for idx := range elst.Entries {
+					expected += elst.GetSegmentDuration(idx)
+				}
+			}
+		}
+		if !agree(tkhd.GetDuration(), expected) {
+			v.add(SeverityWarning, child(trak, mp4.BoxTypeTkhd()), "duration", "duration %d, expected %d from the media or the edit list", tkhd.GetDuration(), expected)
+		}
+	}
+	if !fragmented && !agree(mvhd.GetDuration(), longest) {
+		v.add(SeverityWarning, child(moov, mp4.BoxTypeMvhd()), "duration", "duration %d, the longest track lasts %d", mvhd.GetDuration(), longest)
+	}
+}
+
+func (v *verifier) verifyIlst(node *BoxNode) {
+	for _, c := range node.Children {
+		if c.Info.Type == mp4.BoxTypeIlst() {
+			v.verifyItems(c)
+		} else if c.Info.Type != mp4.BoxTypeMdat() {
+			v.verifyIlst(c)
+		}
+	}
+}
+
+func (v *verifier) verifyItems(ilst *BoxNode) {
+	if meta := ilst.Parent; meta == nil || meta.Info == nil || meta.Info.Type != mp4.BoxTypeMeta() {
+		v.add(SeverityError, ilst, "ilst", "ilst outside of a meta box")
+	} else if hdlr := childBox[*mp4.Hdlr](meta, mp4.BoxTypeHdlr()); hdlr == nil || hdlr.HandlerType != handlerTypeMdir {
+		v.add(SeverityError, ilst, "ilst", "the meta box has no mdir handler")
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range ilst.Children {
+		key := item.Info.Type.String()
+		children := item.Children
+		if item.Info.Type == boxTypeFreeform {
+			if len(children) < 2 || children[0].Info.Type != boxTypeMean || children[1].Info.Type != boxTypeName {
+				v.add(SeverityError, item, "ilst", "freeform item without mean and name")
+				continue
+			}
+			for _, c := range children[:2] {
+				if data, ok := c.Box.(*mp4.StringData); ok && len(data.Data) >= 4 {
+					key += ":" + string(data.Data[4:])
+				}
+			}
+			children = children[2:]
+		}
+		if seen[key] {
+			v.add(SeverityWarning, item, "ilst", "duplicate item %s", key)
+		}
+		seen[key] = true
+
+		if len(children) == 0 {
+			v.add(SeverityError, item, "ilst", "item without data")
+		}
+		for _, c := range children {
+			if c.Info.Type != mp4.BoxTypeData() {
+				v.add(SeverityError, c, "ilst", "unexpected %s in an item", c.Info.Type)
+				continue
+			}
+			data, ok := c.Box.(*mp4.Data)
+			if !ok {
+				continue
+			}
+			switch {
+			case data.DataType>>24 != 0:
+				v.add(SeverityError, c, "ilst", "invalid type indicator 0x%08x", data.DataType)
+			case data.DataType == mp4.DataTypeUTF8 && !utf8.Valid(data.Data):
+				v.add(SeverityError, c, "ilst", "invalid UTF-8 string")
+			case data.DataType == mp4.DataTypeInt && !slices.Contains([]int{1, 2, 3, 4, 8}, len(data.Data)):
+				v.add(SeverityError, c, "ilst", "integer of %d bytes", len(data.Data))
+			}
+		}
+	}
+}
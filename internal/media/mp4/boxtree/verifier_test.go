@@ -0,0 +1,202 @@
+package boxtree
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testTables struct {
+	Stts *mp4.Stts
+	Stsc *mp4.Stsc
+	Stsz *mp4.Stsz
+	Stco *mp4.Stco
+	Stss *mp4.Stss
+	Ctts *mp4.Ctts
+}
+
+// writeTestFile writes a file with a single track of 4 samples in 2 chunks, the data of
+// which follows moov, and reads it back.
+func writeTestFile(t *testing.T, modify func(tables *testTables)) (*BoxNode, uint64) {
+	tables := &testTables{
+		Stts: &mp4.Stts{EntryCount: 1, Entries: []mp4.SttsEntry{{SampleCount: 4, SampleDelta: 1024}}},
+		Stsc: &mp4.Stsc{EntryCount: 1, Entries: []mp4.StscEntry{{FirstChunk: 1, SamplesPerChunk: 2, SampleDescriptionIndex: 1}}},
+		Stsz: &mp4.Stsz{SampleCount: 4, EntrySize: []uint32{10, 20, 30, 40}},
+		Stco: &mp4.Stco{EntryCount: 2, ChunkOffset: []uint32{0, 0}},
+	}
+	modify(tables)
+	layout := tables.Stco.ChunkOffset[0] == 0
+
+	var build func(parent *BoxNode, boxes ...any)
+	build = func(parent *BoxNode, boxes ...any) {
+		for _, box := range boxes {
+			switch box := box.(type) {
+			case mp4.IBox:
+				require.NoError(t, parent.Append(box.GetType(), box))
+			case []any:
+				build(parent.Children[len(parent.Children)-1], box...)
+			}
+		}
+	}
+	url := &mp4.Url{}
+	url.SetFlags(1)
+	stbl := []any{
+		&mp4.Stsd{EntryCount: 1},
+		[]any{&mp4.AudioSampleEntry{SampleEntry: mp4.SampleEntry{AnyTypeBox: mp4.AnyTypeBox{Type: mp4.BoxTypeMp4a()}, DataReferenceIndex: 1}, ChannelCount: 2, SampleSize: 16, SampleRate: 44100 << 16}},
+		tables.Stts, tables.Stsc, tables.Stsz, tables.Stco,
+	}
+	if tables.Stss != nil {
+		stbl = append(stbl, tables.Stss)
+	}
+	if tables.Ctts != nil {
+		stbl = append(stbl, tables.Ctts)
+	}
+	root := &BoxNode{}
+	build(root,
+		&mp4.Ftyp{MajorBrand: [4]byte{'i', 's', 'o', 'm'}},
+		&mp4.Moov{}, []any{
+			&mp4.Mvhd{Timescale: 1000, DurationV0: 92, NextTrackID: 2},
+			&mp4.Trak{}, []any{
+				&mp4.Tkhd{TrackID: 1, DurationV0: 92},
+				&mp4.Mdia{}, []any{
+					&mp4.Mdhd{Timescale: 44100, DurationV0: 4096},
+					&mp4.Hdlr{HandlerType: [4]byte{'s', 'o', 'u', 'n'}},
+					&mp4.Minf{}, []any{
+						&mp4.Smhd{},
+						&mp4.Dinf{}, []any{&mp4.Dref{EntryCount: 1}, []any{url}},
+						&mp4.Stbl{}, stbl,
+					},
+				},
+			},
+		},
+		&mp4.Mdat{Data: make([]byte, 100)},
+	)
+
+	name := filepath.Join(t.TempDir(), "test.mp4")
+	var output *os.File
+	var err error
+	// the chunks are laid out once the size of moov is known
+	for range 2 {
+		output, err = os.Create(name)
+		require.NoError(t, err)
+		_, err = Marshal(output, root)
+		require.NoError(t, err)
+		require.NoError(t, output.Close())
+		mdat := root.Children[2].Info
+		if layout {
+			tables.Stco.ChunkOffset = []uint32{uint32(mdat.Offset + mdat.HeaderSize), uint32(mdat.Offset + mdat.HeaderSize + 30)}
+		}
+	}
+
+	input, err := os.Open(name)
+	require.NoError(t, err)
+	defer input.Close()
+	info, err := input.Stat()
+	require.NoError(t, err)
+	root, err = Unmarshal(input)
+	require.NoError(t, err)
+	return root, uint64(info.Size())
+}
+
+func TestVerify(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		modify func(tables *testTables)
+		issues []Issue
+	}{
+		{
+			name:   "valid",
+			modify: func(tables *testTables) {},
+		},
+		{
+			name: "stts",
+			modify: func(tables *testTables) {
+				tables.Stts.Entries[0].SampleCount = 3
+			},
+			issues: []Issue{
+				{SeverityError, "moov.trak.mdia.mdhd", "duration", "duration 4096, the samples last 3072"},
+				{SeverityError, "moov.trak.mdia.minf.stbl.stts", "sample-count", "stts describes 3 samples, the sample sizes 4"},
+			},
+		},
+		{
+			name: "stsc",
+			modify: func(tables *testTables) {
+				tables.Stsc.Entries[0].SamplesPerChunk = 3
+			},
+			issues: []Issue{
+				{SeverityError, "moov.trak.mdia.minf.stbl.stsc", "chunk-coverage", "2 chunks hold 6 samples, the sample sizes 4"},
+			},
+		},
+		{
+			name: "chunk offset",
+			modify: func(tables *testTables) {
+				tables.Stco.ChunkOffset = []uint32{8, 48}
+			},
+			issues: []Issue{
+				{SeverityError, "moov.trak.mdia.minf.stbl", "chunk-offset", "chunk 1 (30 bytes at offset 8) is outside of mdat"},
+			},
+		},
+		{
+			name: "ctts",
+			modify: func(tables *testTables) {
+				tables.Ctts = &mp4.Ctts{EntryCount: 2, Entries: []mp4.CttsEntry{{SampleCount: 2, SampleOffsetV0: 1024}, {SampleCount: 2, SampleOffsetV0: 0xfffffc00}}}
+			},
+			issues: []Issue{
+				{SeverityError, "moov.trak.mdia.minf.stbl.ctts", "ctts-version", "negative composition offset -1024 in entry 1 of a version 0 ctts"},
+			},
+		},
+		{
+			name: "stss",
+			modify: func(tables *testTables) {
+				tables.Stss = &mp4.Stss{EntryCount: 2, SampleNumber: []uint32{3, 1}}
+			},
+			issues: []Issue{
+				{SeverityError, "moov.trak.mdia.minf.stbl.stss", "stss", "sync sample 1 does not follow 3"},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root, size := writeTestFile(t, tt.modify)
+			report := Verify(root, size)
+			if len(tt.issues) == 0 {
+				assert.Empty(t, report.Issues)
+				assert.True(t, report.Valid())
+				return
+			}
+			assert.Equal(t, tt.issues, report.Issues)
+			assert.False(t, report.Valid())
+		})
+	}
+
+	t.Run("box size", func(t *testing.T) {
+		root, size := writeTestFile(t, func(tables *testTables) {})
+		report := Verify(root, size+8)
+		assert.Equal(t, []Issue{{SeverityError, "", "box-size", "boxes end at " + strconv.FormatUint(size, 10) + ", file size " + strconv.FormatUint(size+8, 10)}}, report.Issues)
+	})
+
+	t.Run("structure", func(t *testing.T) {
+		root, size := writeTestFile(t, func(tables *testTables) {})
+		name := filepath.Join(t.TempDir(), "test.mp4")
+		output, err := os.Create(name)
+		require.NoError(t, err)
+		_, err = Marshal(output, root)
+		require.NoError(t, err)
+		require.NoError(t, output.Close())
+
+		input, err := os.Open(name)
+		require.NoError(t, err)
+		defer input.Close()
+		root, err = UnmarshalStructure(input)
+		require.NoError(t, err)
+		mdat := root.Cache[mp4.BoxTypeMdat()][0]
+		assert.Nil(t, mdat.Box)
+		assert.Equal(t, uint64(108), mdat.Info.Size)
+		report := Verify(root, size)
+		assert.Empty(t, report.Issues)
+	})
+}
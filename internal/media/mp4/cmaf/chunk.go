@@ -0,0 +1,23 @@
+package cmaf
+
+import (
+	"downloader/internal/media/mp4/boxtree"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+// ChunkOffsets returns the offsets of the chunks of the track, from its stco or co64 box.
+func (trak *TrackBox) ChunkOffsets() (offsets []uint64) {
+	return boxtree.ChunkOffsets(trak.Mdia.Minf.Stbl.Node)
+}
+
+// SetChunkOffsets stores the offsets of the chunks of the track, replacing its stco box by
+// a co64 box in place when any of them does not fit in 32 bits (see boxtree.SetChunkOffsets).
+func (trak *TrackBox) SetChunkOffsets(offsets []uint64) (promoted bool, err error) {
+	stbl := &trak.Mdia.Minf.Stbl
+	if promoted, err = boxtree.SetChunkOffsets(stbl.Node, offsets); promoted {
+		stbl.Stco = nil
+		stbl.Co64 = stbl.Node.Cache[mp4.BoxTypeCo64()][0].Box.(*mp4.Co64)
+	}
+	return
+}
@@ -11,6 +11,7 @@ type IContext interface {
 	Initialize(io.ReadSeeker) error
 	Finalize(io.WriteSeeker) error
 	AddSegment(io.ReadSeeker) (*Segment, error)
+	AddInitialization(io.ReadSeeker) (*Initialization, error)
 	GetRoot() *boxtree.BoxNode
 }
 
@@ -19,6 +20,8 @@ type Context struct {
 	Root       *boxtree.BoxNode
 	Header     *Header
 	Segments   []*Segment
+	// Initializations holds the initialization segments following the first one
+	Initializations []*Initialization
 }
 
 func (ctx *Context) Initialize(input io.ReadSeeker) (err error) {
@@ -0,0 +1,170 @@
+package cmaf
+
+import (
+	"bytes"
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/pkg/utils"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+var ErrTrackMismatch = errors.New("track of the initialization segment does not match any track of the movie")
+var ErrTimescaleMismatch = errors.New("timescale of the initialization segment does not match that of the movie")
+
+// Initialization is an initialization segment following a discontinuity (EXT-X-MAP),
+// whose tracks and sample descriptions are mapped onto those of the first one.
+type Initialization struct {
+	Header *Header
+	// TrackIDs maps the track IDs of the initialization segment to those of the movie
+	TrackIDs map[uint32]uint32
+	// SampleDescriptionIndexes maps, per track ID of the initialization segment, its
+	// (1-based) sample description indexes to those of the movie
+	SampleDescriptionIndexes map[uint32][]uint32
+}
+
+func (init *Initialization) MapTrackID(trackID uint32) uint32 {
+	if init == nil {
+		return trackID
+	}
+	if id, found := init.TrackIDs[trackID]; found {
+		return id
+	}
+	return trackID
+}
+
+func (init *Initialization) MapSampleDescriptionIndex(trackID uint32, index uint32) uint32 {
+	if init == nil {
+		return index
+	}
+	indexes := init.SampleDescriptionIndexes[trackID]
+	if index == 0 || int(index) > len(indexes) {
+		return index
+	}
+	return indexes[index-1]
+}
+
+func (ctx *Context) CurrentInitialization() *Initialization {
+	if len(ctx.Initializations) == 0 {
+		return nil
+	}
+	return ctx.Initializations[len(ctx.Initializations)-1]
+}
+
+// CurrentHeader returns the header describing the fragments being added.
+func (ctx *Context) CurrentHeader() *Header {
+	if init := ctx.CurrentInitialization(); init != nil {
+		return init.Header
+	}
+	return ctx.Header
+}
+
+func (ctx *Context) ReadInitialization(input io.ReadSeeker) (init *Initialization, err error) {
+	var root *boxtree.BoxNode
+	if root, err = boxtree.UnmarshalWithContext(input, ctx.mp4Context); err != nil {
+		return
+	}
+	init = &Initialization{
+		TrackIDs:                 make(map[uint32]uint32),
+		SampleDescriptionIndexes: make(map[uint32][]uint32),
+	}
+	if init.Header, err = InitializeHeader(root); err != nil {
+		return
+	}
+	return
+}
+
+func marshalBox(node *boxtree.BoxNode) (data []byte, err error) {
+	buffer := utils.NewBufferWriter()
+	if _, err = boxtree.Marshal(buffer, &boxtree.BoxNode{Children: []*boxtree.BoxNode{node}}); err != nil {
+		return
+	}
+	return buffer.Bytes(), nil
+}
+
+func (ctx *Context) findTrack(trak TrackBox) *TrackBox {
+	var found *TrackBox
+	for idx := range ctx.Header.Moov.Trak {
+		target := &ctx.Header.Moov.Trak[idx]
+		if target.Mdia.Hdlr.HandlerType != trak.Mdia.Hdlr.HandlerType {
+			continue
+		}
+		if target.Tkhd.TrackID == trak.Tkhd.TrackID {
+			return target
+		}
+		if found == nil {
+			found = target
+		}
+	}
+	return found
+}
+
+// MergeInitialization appends the sample entries of the initialization segment to the
+// sample description boxes of the matching tracks, reusing the identical ones. The samples
+// are not rescaled, so the matching tracks must share their timescale.
+func (ctx *Context) MergeInitialization(init *Initialization) (err error) {
+	targets := make([]*TrackBox, len(init.Header.Moov.Trak))
+	for idx, trak := range init.Header.Moov.Trak {
+		if targets[idx] = ctx.findTrack(trak); targets[idx] == nil {
+			return ErrTrackMismatch
+		}
+		if trak.Mdia.Mdhd.Timescale != targets[idx].Mdia.Mdhd.Timescale {
+			return fmt.Errorf("%w: %d, not %d", ErrTimescaleMismatch, trak.Mdia.Mdhd.Timescale, targets[idx].Mdia.Mdhd.Timescale)
+		}
+	}
+
+	for idx, trak := range init.Header.Moov.Trak {
+		target := targets[idx]
+		init.TrackIDs[trak.Tkhd.TrackID] = target.Tkhd.TrackID
+
+		stsd := target.Mdia.Minf.Stbl.Stsd
+		stsdNode := target.Mdia.Minf.Stbl.Node.Cache[mp4.BoxTypeStsd()][0]
+
+		var existing [][]byte
+		for _, entry := range stsd.Entries {
+			var data []byte
+			if data, err = marshalBox(entry.Node); err != nil {
+				return
+			}
+			existing = append(existing, data)
+		}
+
+		var indexes []uint32
+		for _, entry := range trak.Mdia.Minf.Stbl.Stsd.Entries {
+			var data []byte
+			if data, err = marshalBox(entry.Node); err != nil {
+				return
+			}
+			idx := slices.IndexFunc(existing, func(e []byte) bool { return bytes.Equal(e, data) })
+			if idx < 0 {
+				stsdNode.Children = append(stsdNode.Children, entry.Node)
+				stsd.Box.EntryCount++
+				existing = append(existing, data)
+				idx = len(existing) - 1
+			}
+			indexes = append(indexes, uint32(idx+1))
+		}
+		init.SampleDescriptionIndexes[trak.Tkhd.TrackID] = indexes
+
+		if err = stsdNode.Caching(); err != nil {
+			return
+		}
+	}
+
+	ctx.Initializations = append(ctx.Initializations, init)
+	ctx.Header, err = InitializeHeader(ctx.Root)
+	return
+}
+
+// AddInitialization reads an initialization segment following a discontinuity and
+// merges its sample descriptions into the movie.
+func (ctx *Context) AddInitialization(input io.ReadSeeker) (init *Initialization, err error) {
+	if init, err = ctx.ReadInitialization(input); err != nil {
+		return
+	}
+	err = ctx.MergeInitialization(init)
+	return
+}
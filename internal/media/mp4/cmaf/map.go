@@ -27,6 +27,9 @@ type ProtectedSampleEntry struct {
 
 type TrackBox struct {
 	Tkhd *mp4.Tkhd
+	Edts struct {
+		Elst *mp4.Elst
+	}
 	Mdia struct {
 		Mdhd *mp4.Mdhd
 		Hdlr *mp4.Hdlr
@@ -155,8 +158,10 @@ func assignMore[T mp4.IBox](root *boxtree.BoxNode, target *[]T, path string) {
 		// println("debug: not found", path)
 		return
 	}
-	for _, box := range forest {
-		*target = append(*target, box.Box.(T))
+	for _, node := range forest {
+		// the payload of a mdat box read by boxtree.UnmarshalStructure is left in the file
+		box, _ := node.Box.(T)
+		*target = append(*target, box)
 	}
 }
 
@@ -188,6 +193,7 @@ func InitializeHeader(root *boxtree.BoxNode) (hdr *Header, err error) {
 	for _, trackNode := range trakNodes {
 		trak := TrackBox{Node: trackNode}
 		assignOnce(trackNode, &trak.Tkhd, "tkhd")
+		assignOnce(trackNode, &trak.Edts.Elst, "edts.elst")
 		assignOnce(trackNode, &trak.Mdia.Mdhd, "mdia.mdhd")
 		assignOnce(trackNode, &trak.Mdia.Hdlr, "mdia.hdlr")
 		assignOnce(trackNode, &trak.Mdia.Minf.Smhd, "mdia.minf.smhd")
@@ -210,10 +216,10 @@ func InitializeHeader(root *boxtree.BoxNode) (hdr *Header, err error) {
 					entry.AudioSampleEntry = entryInfo.Box.(*mp4.AudioSampleEntry)
 				} else if trak.Mdia.Minf.Vmhd != nil {
 					entry.VisualSampleEntry = entryInfo.Box.(*mp4.VisualSampleEntry)
-				} else if trak.Mdia.Minf.Nmhd != nil {
-					entry.ClosedCaptionSubtitleSampleEntry = entryInfo.Box.(*mp4.ClosedCaptionSubtitleSampleEntry)
-				} else {
-					entry.SampleEntry = entryInfo.Box.(*mp4.SampleEntry)
+				} else if box, ok := entryInfo.Box.(*mp4.ClosedCaptionSubtitleSampleEntry); ok && trak.Mdia.Minf.Nmhd != nil {
+					entry.ClosedCaptionSubtitleSampleEntry = box
+				} else if box, ok := entryInfo.Box.(*mp4.SampleEntry); ok {
+					entry.SampleEntry = box
 				}
 				sinfNodes, found := entryInfo.Cache[mp4.BoxTypeSinf()]
 				if found && len(sinfNodes) > 0 {
@@ -0,0 +1,66 @@
+package metadata
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// AudioInfo describes the audio stream as declared by its sample entry, rather than by the
+// CODECS attribute of the playlist.
+type AudioInfo struct {
+	Codec         string // RFC 6381 codec string, e.g. "alac" or "mp4a.40.2"
+	SampleRate    uint32
+	BitDepth      uint8 // 0 for lossy codecs
+	Channels      uint8
+	ChannelLayout string // e.g. "2.0" or "5.1"
+	Atmos         bool
+}
+
+// FormatSampleRate returns the sample rate in kHz, e.g. "44.1kHz".
+func (a *AudioInfo) FormatSampleRate() string {
+	return strconv.FormatFloat(float64(a.SampleRate)/1000, 'f', -1, 64) + "kHz"
+}
+
+// Quality returns a short description of the stream, e.g. "24B-96kHz" for lossless ones,
+// "Atmos" or the sample rate for lossy ones.
+func (a *AudioInfo) Quality() string {
+	switch {
+	case a.Atmos:
+		return "Atmos"
+	case a.BitDepth != 0:
+		return fmt.Sprintf("%dB-%s", a.BitDepth, a.FormatSampleRate())
+	case a.SampleRate != 0:
+		return a.FormatSampleRate()
+	default:
+		return ""
+	}
+}
+
+// Tag returns the quality as a file name suffix, e.g. " [24B-96kHz]".
+func (a *AudioInfo) Tag() string {
+	if quality := a.Quality(); len(quality) != 0 {
+		return " [" + quality + "]"
+	}
+	return ""
+}
+
+// Variables returns the placeholders describing the stream, for file names and hooks.
+func (a *AudioInfo) Variables() map[string]string {
+	if a == nil {
+		return map[string]string{
+			"audio_quality":     "",
+			"audio_quality_tag": "",
+		}
+	}
+	variables := map[string]string{
+		"audio_quality":     a.Quality(),
+		"audio_quality_tag": a.Tag(),
+		"audio_codec":       a.Codec,
+		"sample_rate":       strconv.FormatUint(uint64(a.SampleRate), 10),
+		"channels":          a.ChannelLayout,
+	}
+	if a.BitDepth != 0 {
+		variables["bit_depth"] = strconv.Itoa(int(a.BitDepth))
+	}
+	return variables
+}
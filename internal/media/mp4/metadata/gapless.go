@@ -0,0 +1,45 @@
+package metadata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Gapless describes the samples of an audio track which are encoded but not part of the
+// audio: the priming of the decoder at the start, and the padding of the last frame.
+type Gapless struct {
+	Priming   uint32
+	Remainder uint32
+	// Samples is the number of samples of the audio, priming and remainder excluded
+	Samples uint64
+}
+
+// ITunSMPB formats the gapless information as the iTunSMPB comment written by iTunes.
+func (g *Gapless) ITunSMPB() string {
+	fields := []string{
+		"00000000",
+		fmt.Sprintf("%08X", g.Priming),
+		fmt.Sprintf("%08X", g.Remainder),
+		fmt.Sprintf("%016X", g.Samples),
+	}
+	for range 8 {
+		fields = append(fields, "00000000")
+	}
+	return " " + strings.Join(fields, " ")
+}
+
+// ParseITunSMPB parses the iTunSMPB comment written by iTunes.
+func ParseITunSMPB(str string) (g *Gapless, err error) {
+	fields := strings.Fields(str)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("invalid iTunSMPB: %q", str)
+	}
+	var values [3]uint64
+	for idx := range values {
+		if values[idx], err = strconv.ParseUint(fields[idx+1], 16, 64); err != nil {
+			return nil, fmt.Errorf("invalid iTunSMPB: %q", str)
+		}
+	}
+	return &Gapless{Priming: uint32(values[0]), Remainder: uint32(values[1]), Samples: values[2]}, nil
+}
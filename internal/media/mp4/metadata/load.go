@@ -7,6 +7,7 @@ import (
 	"downloader/internal/media/quicktime"
 	"encoding/binary"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,6 +25,13 @@ func ref[T any](val T) *T {
 	return &v
 }
 
+func flag(value bool) *uint8 {
+	if value {
+		return ref(uint8(1))
+	}
+	return ref(uint8(0))
+}
+
 func atoi(str string) *uint32 {
 	integer, err := strconv.ParseInt(str, 10, 32)
 	if err != nil {
@@ -38,6 +46,50 @@ func genre(id int) (raw []byte) {
 	return
 }
 
+func firstOf(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// setGenre tags the genre by its ID (gnre), or by its name (©gen) when it has none.
+func setGenre(meta *Metadata, id int, name string) {
+	if id != 0 {
+		meta.Genre = genre(id)
+	} else if len(name) != 0 {
+		meta.GenreName = ref(name)
+	}
+}
+
+// rating maps the first known content rating of the catalog (or explicitness of iTunes) to
+// the rtng item.
+func rating(contentRatings ...*string) *uint8 {
+	for _, contentRating := range contentRatings {
+		if contentRating == nil {
+			continue
+		}
+		switch *contentRating {
+		case "explicit":
+			return ref(uint8(quicktime.RatingExplicit))
+		case "clean", "cleaned":
+			return ref(uint8(quicktime.RatingClean))
+		case "notExplicit":
+			return ref(uint8(quicktime.RatingNone))
+		}
+	}
+	return ref(uint8(quicktime.RatingNone))
+}
+
+// describe tags the editorial notes of the item itself as its description (desc) and long
+// description (ldes).
+func describe(meta *Metadata, notes *applemusic.EditorialNotes) {
+	if notes != nil {
+		meta.Description = notes.Short
+		meta.LongDesc = notes.Standard
+	}
+}
+
 type MusicVideoType int
 
 const (
@@ -55,6 +107,20 @@ type Context struct {
 	ItunesMusicVideo      *itunes.MusicVideo
 	CoverData             []byte
 	LyricsData            string
+	// Preview marks the metadata as that of a preview clip of the track
+	Preview bool
+}
+
+const PreviewSuffix = " (Preview)"
+
+func markPreview(meta *Metadata) {
+	if meta.Title != nil {
+		meta.Title = ref(*meta.Title + PreviewSuffix)
+	}
+	if meta.SortName != nil {
+		meta.SortName = ref(*meta.SortName + PreviewSuffix)
+	}
+	meta.Lyrics = nil
 }
 
 func LoadSongMetadata(ctx Context) (meta *Metadata) {
@@ -69,7 +135,7 @@ func LoadSongMetadata(ctx Context) (meta *Metadata) {
 		meta.ComposerName = &assetMetadata.ComposerName
 		meta.AlbumName = &assetMetadata.PlaylistName
 		meta.Work = assetMetadata.Work
-		meta.Genre = genre(assetMetadata.GenreID)
+		setGenre(meta, assetMetadata.GenreID, assetMetadata.Genre)
 		meta.Track = &Track{
 			TrackNumber: uint32(assetMetadata.TrackNumber),
 			TrackCount:  uint16(assetMetadata.TrackCount),
@@ -78,21 +144,18 @@ func LoadSongMetadata(ctx Context) (meta *Metadata) {
 			DiskNumber: uint32(assetMetadata.DiscNumber),
 			DiskCount:  uint16(assetMetadata.DiscCount),
 		}
-		meta.Compilation = ref(func() uint8 {
-			if assetMetadata.Compilation {
-				return 1
-			} else {
-				return 0
-			}
-		}())
-		meta.PlayGap = ref(uint8(0))
+		meta.Compilation = flag(assetMetadata.Compilation)
+		meta.PlayGap = flag(assetMetadata.Gapless)
 		meta.ReleaseDate = ref(assetMetadata.ReleaseDate.Format(time.RFC3339))
 		meta.AppleID = nil
 		meta.Owner = nil
 		meta.Copyright = &assetMetadata.Copyright
 		meta.ItemID = atoi(assetMetadata.ItemID)
 		meta.ArtistID = atoi(assetMetadata.ArtistID)
-		meta.Rating = ref(uint8(0))
+		meta.Rating = rating(ctx.AppleMusicSongs.Attributes.ContentRating)
+		if assetMetadata.Explicit != 0 {
+			meta.Rating = ref(uint8(quicktime.RatingExplicit))
+		}
 		meta.ComposerID = atoi(assetMetadata.ComposerID)
 		meta.PlaylistID = atoi(assetMetadata.PlaylistID)
 		meta.GenreID = ref(uint32(assetMetadata.GenreID))
@@ -115,7 +178,7 @@ func LoadSongMetadata(ctx Context) (meta *Metadata) {
 		meta.ComposerName = assign(ctx.AppleMusicSongs.Attributes.ComposerName)
 		meta.AlbumName = assign(ctx.AppleMusicSongs.Attributes.AlbumName, ctx.AppleMusicAlbum.Attributes.Name, ctx.ItunesSong.CollectionName)
 		meta.Work = assign(ctx.AppleMusicSongs.Attributes.WorkName)
-		meta.Genre = genre(quicktime.GetGenreID(ctx.AppleMusicSongs.Attributes.GenreNames))
+		setGenre(meta, quicktime.GetGenreID(ctx.AppleMusicSongs.Attributes.GenreNames), firstOf(ctx.AppleMusicSongs.Attributes.GenreNames))
 		meta.Track = &Track{
 			TrackNumber: uint32(*assign(ctx.AppleMusicSongs.Attributes.TrackNumber, ctx.ItunesSong.TrackNumber)),
 			TrackCount:  uint16(*ctx.ItunesSong.TrackCount),
@@ -132,7 +195,7 @@ func LoadSongMetadata(ctx Context) (meta *Metadata) {
 		meta.Copyright = assign(ctx.AppleMusicAlbum.Attributes.Copyright)
 		meta.ItemID = assign(atoi(*ctx.AppleMusicSongs.ID), ref(uint32(*ctx.ItunesSong.TrackID)))
 		meta.ArtistID = assign(ref(uint32(*ctx.ItunesSong.ArtistID)), atoi(*ctx.AppleMusicSongs.Relationships.Artists.Data[0].ID))
-		meta.Rating = ref(uint8(0))
+		meta.Rating = rating(ctx.AppleMusicSongs.Attributes.ContentRating, ctx.ItunesSong.TrackExplicitness)
 		meta.ComposerID = assign(atoi(*ctx.AppleMusicSongs.Relationships.Composers.Data[0].ID))
 		meta.PlaylistID = assign(ref(uint32(*ctx.ItunesSong.CollectionID)), atoi(*ctx.AppleMusicAlbum.ID))
 		meta.GenreID = assign(ref(uint32(quicktime.GetGenreID(ctx.AppleMusicSongs.Attributes.GenreNames))))
@@ -149,6 +212,19 @@ func LoadSongMetadata(ctx Context) (meta *Metadata) {
 			meta.Lyrics = &ctx.LyricsData
 		}
 	}
+	describe(meta, ctx.AppleMusicSongs.Attributes.EditorialNotes)
+	meta.ISRC = ctx.AppleMusicSongs.Attributes.Isrc
+	if album := ctx.AppleMusicAlbum; album != nil {
+		meta.UPC = album.Attributes.Upc
+		meta.Label = album.Attributes.RecordLabel
+	}
+	if traits := ctx.AppleMusicSongs.Attributes.AudioTraits; len(traits) != 0 {
+		meta.AudioTraits = ref(strings.Join(traits, ", "))
+	}
+	meta.EncodingTool = ref(config.EncodingTool())
+	if ctx.Preview {
+		markPreview(meta)
+	}
 	return
 }
 
@@ -163,7 +239,7 @@ func LoadMusicVideoMetadata(ctx Context) (meta *Metadata) {
 		meta.AlbumName = assign(ctx.AppleMusicMusicVideos.Attributes.AlbumName, ctx.ItunesMusicVideo.CollectionName)
 	}
 	meta.Work = assign(ctx.AppleMusicMusicVideos.Attributes.WorkName)
-	meta.Genre = genre(quicktime.GetGenreID(ctx.AppleMusicMusicVideos.Attributes.GenreNames))
+	setGenre(meta, quicktime.GetGenreID(ctx.AppleMusicMusicVideos.Attributes.GenreNames), firstOf(ctx.AppleMusicMusicVideos.Attributes.GenreNames))
 	if ctx.Type == MusicVideoTypeFromAlbum {
 		meta.Track = &Track{
 			TrackNumber: uint32(*assign(ctx.AppleMusicMusicVideos.Attributes.TrackNumber, ctx.ItunesMusicVideo.TrackNumber)),
@@ -184,7 +260,7 @@ func LoadMusicVideoMetadata(ctx Context) (meta *Metadata) {
 	}
 	meta.ItemID = assign(atoi(*ctx.AppleMusicMusicVideos.ID), ref(uint32(*ctx.ItunesMusicVideo.TrackID)))
 	meta.ArtistID = assign(ref(uint32(*ctx.ItunesMusicVideo.ArtistID)), atoi(*ctx.AppleMusicMusicVideos.Relationships.Artists.Data[0].ID))
-	meta.Rating = ref(uint8(0))
+	meta.Rating = rating(ctx.AppleMusicMusicVideos.Attributes.ContentRating, ctx.ItunesMusicVideo.TrackExplicitness)
 	if ctx.Type == MusicVideoTypeFromAlbum {
 		meta.PlaylistID = assign(ref(uint32(*ctx.ItunesMusicVideo.CollectionID)), atoi(*ctx.AppleMusicAlbum.ID))
 	}
@@ -201,5 +277,10 @@ func LoadMusicVideoMetadata(ctx Context) (meta *Metadata) {
 	meta.XID = nil
 	meta.Flavor = nil
 	meta.Cover = ctx.CoverData
+	describe(meta, ctx.AppleMusicMusicVideos.Attributes.EditorialNotes)
+	meta.EncodingTool = ref(config.EncodingTool())
+	if ctx.Preview {
+		markPreview(meta)
+	}
 	return
 }
@@ -6,7 +6,9 @@ import (
 	"downloader/internal/media/mp4/cmaf"
 	"encoding/binary"
 	"errors"
+	"io"
 	"reflect"
+	"strings"
 
 	"github.com/Spidey120703/go-mp4"
 )
@@ -30,6 +32,7 @@ type Metadata struct {
 	AlbumName      *string `ilst:"\xA9alb"`
 	Work           *string `ilst:"\xA9grp"`
 	Genre          []byte  `ilst:"gnre"`
+	GenreName      *string `ilst:"\xA9gen"`
 	Track          *Track  `ilst:"trkn"`
 	DiskNumber     *Disk   `ilst:"disk"`
 	Compilation    *uint8  `ilst:"cpil"`
@@ -56,6 +59,23 @@ type Metadata struct {
 	Flavor         *string `ilst:"flvr"`
 	Cover          []byte  `ilst:"covr"`
 	Lyrics         *string `ilst:"\xA9lyr"`
+	Comment        *string `ilst:"\xA9cmt"`
+	Description    *string `ilst:"desc"`
+	LongDesc       *string `ilst:"ldes"`
+	Tempo          *uint16 `ilst:"tmpo"`
+	EncodingTool   *string `ilst:"\xA9too"`
+	ISRC           *string `ilst:"----:com.apple.iTunes:ISRC"`
+	UPC            *string `ilst:"----:com.apple.iTunes:UPC"`
+	Label          *string `ilst:"----:com.apple.iTunes:LABEL"`
+	// AudioTraits lists the audio traits of the catalog, e.g. "atmos, lossless, spatial"
+	AudioTraits *string `ilst:"----:com.apple.iTunes:AUDIO_TRAITS"`
+	// VideoRange is the dynamic range of the video, e.g. "SDR" or "Dolby Vision"
+	VideoRange *string `ilst:"----:com.apple.iTunes:VIDEO_RANGE"`
+	// Gapless is written as the iTunSMPB freeform item
+	Gapless *Gapless `ilst:"-"`
+
+	// Audio is not written to the file, it describes the audio stream for file names and hooks
+	Audio *AudioInfo `ilst:"-"`
 }
 
 func detectBinaryDataType(data []byte) uint32 {
@@ -83,7 +103,39 @@ func detectBinaryDataType(data []byte) uint32 {
 	return mp4.DatatypeReserved
 }
 
+// freeformPrefix starts the tags of the fields written as freeform items of the ilst box,
+// "----:<mean>:<name>".
+const freeformPrefix = "----:"
+
+func parseFreeformTag(tag string) (mean string, name string, ok bool) {
+	if !strings.HasPrefix(tag, freeformPrefix) {
+		return
+	}
+	return strings.Cut(tag[len(freeformPrefix):], ":")
+}
+
+// Walk calls back with the data of the fields written as items of the ilst box, but the
+// freeform ones (see WalkFreeform).
 func (m *Metadata) Walk(callback func(mp4.BoxType, *mp4.Data) error) (err error) {
+	return m.fields(func(tag string, data *mp4.Data) error {
+		if strings.HasPrefix(tag, freeformPrefix) {
+			return nil
+		}
+		return callback(mp4.StrToBoxType(tag), data)
+	})
+}
+
+// WalkFreeform calls back with the data of the fields written as freeform items.
+func (m *Metadata) WalkFreeform(callback func(mean string, name string, data *mp4.Data) error) (err error) {
+	return m.fields(func(tag string, data *mp4.Data) error {
+		if mean, name, ok := parseFreeformTag(tag); ok {
+			return callback(mean, name, data)
+		}
+		return nil
+	})
+}
+
+func (m *Metadata) fields(callback func(tag string, data *mp4.Data) error) (err error) {
 
 	t := reflect.TypeOf(*m)
 	v := reflect.ValueOf(*m)
@@ -91,12 +143,10 @@ func (m *Metadata) Walk(callback func(mp4.BoxType, *mp4.Data) error) (err error)
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
 		value := v.Field(i)
-		if value.Pointer() == 0 {
+		if value.Pointer() == 0 || field.Tag.Get("ilst") == "-" {
 			continue
 		}
 
-		boxType := mp4.StrToBoxType(field.Tag.Get("ilst"))
-
 		data := &mp4.Data{}
 		switch field.Type.Kind() {
 		case reflect.Pointer:
@@ -109,6 +159,10 @@ func (m *Metadata) Walk(callback func(mp4.BoxType, *mp4.Data) error) (err error)
 			case reflect.Uint8:
 				data.DataType = mp4.DataTypeInt
 				data.Data = []byte{value.Elem().Interface().(uint8)}
+			// *uint16
+			case reflect.Uint16:
+				data.DataType = mp4.DataTypeInt
+				data.Data = binary.BigEndian.AppendUint16(nil, value.Elem().Interface().(uint16))
 			// *uint32
 			case reflect.Uint32:
 				data.DataType = mp4.DataTypeInt
@@ -148,7 +202,7 @@ func (m *Metadata) Walk(callback func(mp4.BoxType, *mp4.Data) error) (err error)
 			return errors.New("unhandled data")
 		}
 
-		err = callback(boxType, data)
+		err = callback(field.Tag.Get("ilst"), data)
 		if err != nil {
 			return err
 		}
@@ -156,6 +210,104 @@ func (m *Metadata) Walk(callback func(mp4.BoxType, *mp4.Data) error) (err error)
 	return
 }
 
+// freeformItem builds a "----" item of the ilst box, holding data named by a reverse DNS
+// domain (mean) and a name.
+func freeformItem(parent mp4.BoxPath, mean string, name string, data *mp4.Data) (item *boxtree.BoxNode, err error) {
+	boxType := mp4.StrToBoxType("----")
+	item = &boxtree.BoxNode{
+		Info: &mp4.BoxInfo{Type: boxType, Context: mp4.Context{UnderUdta: true, UnderIlst: true}},
+		Box: &mp4.IlstMetaContainer{
+			AnyTypeBox: mp4.AnyTypeBox{
+				Type: boxType,
+			},
+		},
+		Path: boxtree.JoinPath(parent, boxType),
+	}
+
+	context := mp4.Context{UnderUdta: true, UnderIlst: true, UnderIlstMeta: true, UnderIlstFreeMeta: true}
+	for _, field := range []struct {
+		boxType mp4.BoxType
+		value   string
+	}{
+		{mp4.StrToBoxType("mean"), mean},
+		{mp4.StrToBoxType("name"), name},
+	} {
+		item.Children = append(item.Children, &boxtree.BoxNode{
+			Info: &mp4.BoxInfo{Type: field.boxType, Context: context},
+			Box: &mp4.StringData{
+				AnyTypeBox: mp4.AnyTypeBox{Type: field.boxType},
+				// version and flags of the full box
+				Data: append([]byte{0, 0, 0, 0}, field.value...),
+			},
+			Path: boxtree.JoinPath(item.Path, field.boxType),
+		})
+	}
+	item.Children = append(item.Children, &boxtree.BoxNode{
+		Info: &mp4.BoxInfo{Type: mp4.BoxTypeData(), Context: context},
+		Box:  data,
+		Path: boxtree.JoinPath(item.Path, mp4.BoxTypeData()),
+	})
+	err = item.Caching()
+	return
+}
+
+// buildIlst builds the ilst box of the metadata, below the meta box at parent.
+func (m *Metadata) buildIlst(parent mp4.BoxPath) (ilst *boxtree.BoxNode, err error) {
+	ilst = &boxtree.BoxNode{
+		Info: &mp4.BoxInfo{Type: mp4.BoxTypeIlst(), Context: mp4.Context{UnderUdta: true}},
+		Box:  &mp4.Ilst{},
+		Path: boxtree.JoinPath(parent, mp4.BoxTypeIlst()),
+	}
+
+	if err = m.Walk(func(boxType mp4.BoxType, data *mp4.Data) (err error) {
+		item := &boxtree.BoxNode{
+			Info: &mp4.BoxInfo{Type: boxType, Context: mp4.Context{UnderUdta: true, UnderIlst: true}},
+			Box: &mp4.IlstMetaContainer{
+				AnyTypeBox: mp4.AnyTypeBox{
+					Type: boxType,
+				},
+			},
+			Path: boxtree.JoinPath(ilst.Path, boxType),
+		}
+
+		item.Children = append(item.Children, &boxtree.BoxNode{
+			Info: &mp4.BoxInfo{Type: mp4.BoxTypeData(), Context: mp4.Context{UnderUdta: true, UnderIlst: true, UnderIlstMeta: true}},
+			Box:  data,
+			Path: boxtree.JoinPath(item.Path, mp4.BoxTypeData()),
+		})
+		if err = item.Caching(); err != nil {
+			return
+		}
+		ilst.Children = append(ilst.Children, item)
+		return
+	}); err != nil {
+		return
+	}
+
+	if err = m.WalkFreeform(func(mean string, name string, data *mp4.Data) (err error) {
+		var item *boxtree.BoxNode
+		if item, err = freeformItem(ilst.Path, mean, name, data); err != nil {
+			return
+		}
+		ilst.Children = append(ilst.Children, item)
+		return
+	}); err != nil {
+		return
+	}
+
+	if m.Gapless != nil {
+		var item *boxtree.BoxNode
+		data := &mp4.Data{DataType: mp4.DataTypeUTF8, Data: []byte(m.Gapless.ITunSMPB())}
+		if item, err = freeformItem(ilst.Path, "com.apple.iTunes", "iTunSMPB", data); err != nil {
+			return
+		}
+		ilst.Children = append(ilst.Children, item)
+	}
+
+	err = ilst.Caching()
+	return
+}
+
 func (m *Metadata) Attach(root *boxtree.BoxNode) (err error) {
 	var header *cmaf.Header
 	if header, err = cmaf.InitializeHeader(root); err != nil {
@@ -202,42 +354,11 @@ func (m *Metadata) Attach(root *boxtree.BoxNode) (err error) {
 		}
 		meta.Children = append(meta.Children, &hdlr)
 
-		ilst := boxtree.BoxNode{
-			Info: &mp4.BoxInfo{Type: mp4.BoxTypeIlst(), Context: mp4.Context{UnderUdta: true}},
-			Box:  &mp4.Ilst{},
-			Path: boxtree.JoinPath(meta.Path, mp4.BoxTypeIlst()),
-		}
-
-		if err = m.Walk(func(boxType mp4.BoxType, data *mp4.Data) (err error) {
-			item := &boxtree.BoxNode{
-				Info: &mp4.BoxInfo{Type: boxType, Context: mp4.Context{UnderUdta: true, UnderIlst: true}},
-				Box: &mp4.IlstMetaContainer{
-					AnyTypeBox: mp4.AnyTypeBox{
-						Type: boxType,
-					},
-				},
-				Path: boxtree.JoinPath(ilst.Path, boxType),
-			}
-
-			item.Children = append(item.Children, &boxtree.BoxNode{
-				Info: &mp4.BoxInfo{Type: mp4.BoxTypeData(), Context: mp4.Context{UnderUdta: true, UnderIlst: true, UnderIlstMeta: true}},
-				Box:  data,
-				Path: boxtree.JoinPath(item.Path, mp4.BoxTypeData()),
-			})
-			if err = item.Caching(); err != nil {
-				return
-			}
-			ilst.Children = append(ilst.Children, item)
-			return
-		}); err != nil {
-			return
-		}
-
-		if err = ilst.Caching(); err != nil {
+		var ilst *boxtree.BoxNode
+		if ilst, err = m.buildIlst(meta.Path); err != nil {
 			return
 		}
-
-		meta.Children = append(meta.Children, &ilst)
+		meta.Children = append(meta.Children, ilst)
 
 		meta.Children = append(meta.Children, &boxtree.BoxNode{
 			Info: &mp4.BoxInfo{Type: mp4.BoxTypeFree(), Context: mp4.Context{UnderUdta: true}},
@@ -256,3 +377,18 @@ func (m *Metadata) Attach(root *boxtree.BoxNode) (err error) {
 	}
 	return
 }
+
+// Rewrite copies a progressive (non-fragmented) MP4 file, replacing its iTunes metadata,
+// and moves the chunk offsets along with the media data, which is copied without being loaded.
+func (m *Metadata) Rewrite(input io.ReadSeeker, output io.WriteSeeker) (err error) {
+	var root *boxtree.BoxNode
+	if root, err = boxtree.UnmarshalStructure(input); err != nil {
+		return
+	}
+
+	editor := boxtree.NewEditorWithSource(root, input)
+	if err = m.replace(editor); err != nil {
+		return
+	}
+	return editor.Write(output)
+}
@@ -0,0 +1,140 @@
+package metadata
+
+import (
+	"downloader/internal/media/mp4/boxtree"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+// itemData returns the data box of an item of the ilst box.
+func itemData(item *boxtree.BoxNode) *mp4.Data {
+	for _, child := range item.Children {
+		if data, ok := child.Box.(*mp4.Data); ok {
+			return data
+		}
+	}
+	return nil
+}
+
+// freeformTag returns the "----:<mean>:<name>" tag of a freeform item of the ilst box.
+func freeformTag(item *boxtree.BoxNode) string {
+	var mean, name string
+	for _, child := range item.Children {
+		data, ok := child.Box.(*mp4.StringData)
+		if !ok || len(data.Data) < 4 {
+			continue
+		}
+		// version and flags of the full box
+		switch child.Info.Type {
+		case mp4.StrToBoxType("mean"):
+			mean = string(data.Data[4:])
+		case mp4.StrToBoxType("name"):
+			name = string(data.Data[4:])
+		}
+	}
+	return freeformPrefix + mean + ":" + name
+}
+
+func integer(data []byte) (value uint64) {
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return
+}
+
+// decode is the opposite of the encoding of Walk.
+func decode(value reflect.Value, data []byte) (err error) {
+	switch value.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(value.Type().Elem()).Elem()
+		switch elem.Kind() {
+		// *string
+		case reflect.String:
+			elem.SetString(string(data))
+		// *uint8 | *byte | *uint16 | *uint32
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			elem.SetUint(integer(data))
+		// * struct {}, the fields of which may be missing at the end
+		case reflect.Struct:
+			for i := 0; i < elem.NumField(); i++ {
+				field := elem.Field(i)
+				switch field.Kind() {
+				case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+					size := int(field.Type().Size())
+					if len(data) < size {
+						data = nil
+						continue
+					}
+					field.SetUint(integer(data[:size]))
+					data = data[size:]
+				}
+			}
+		default:
+			return errors.New("unhandled data pointer")
+		}
+		value.Set(elem.Addr())
+	// []byte | []uint8
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.Uint8 {
+			return errors.New("unhandled data array")
+		}
+		value.SetBytes(slices.Clone(data))
+	default:
+		return errors.New("unhandled data")
+	}
+	return
+}
+
+// Read reads the ilst box of a file into the fields tagged by ilst, the opposite of Walk and
+// WalkFreeform, and the gapless information. The items it does not know of are left out.
+func Read(root *boxtree.BoxNode) (m *Metadata, err error) {
+	var forest []*boxtree.BoxNode
+	if forest, err = root.P("moov.udta.meta.ilst"); err != nil {
+		return
+	}
+	ilst := forest[0]
+
+	freeform := make(map[string]*boxtree.BoxNode)
+	for _, item := range ilst.Cache[mp4.StrToBoxType("----")] {
+		freeform[freeformTag(item)] = item
+	}
+
+	m = &Metadata{}
+	t := reflect.TypeOf(*m)
+	v := reflect.ValueOf(m).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("ilst")
+		if len(tag) == 0 || tag == "-" {
+			continue
+		}
+		var item *boxtree.BoxNode
+		if strings.HasPrefix(tag, freeformPrefix) {
+			item = freeform[tag]
+		} else if items := ilst.Cache[mp4.StrToBoxType(tag)]; len(items) > 0 {
+			item = items[0]
+		}
+		if item == nil {
+			continue
+		}
+		if data := itemData(item); data != nil {
+			if err = decode(v.Field(i), data.Data); err != nil {
+				return nil, fmt.Errorf("%s: %w", field.Name, err)
+			}
+		}
+	}
+
+	if item := freeform[freeformPrefix+"com.apple.iTunes:iTunSMPB"]; item != nil {
+		if data := itemData(item); data != nil {
+			if m.Gapless, err = ParseITunSMPB(string(data.Data)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package metadata
+
+import (
+	"downloader/internal/media/mp4/boxtree"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeIlst writes a file made of the moov.udta.meta.ilst boxes of the metadata and reads it
+// back.
+func writeIlst(t *testing.T, m *Metadata) *boxtree.BoxNode {
+	root := &boxtree.BoxNode{}
+	require.NoError(t, root.Append(mp4.BoxTypeMoov(), &mp4.Moov{}))
+	moov := root.Children[0]
+	require.NoError(t, moov.Append(mp4.BoxTypeUdta(), &mp4.Udta{}))
+	udta := moov.Children[0]
+	require.NoError(t, udta.Append(mp4.BoxTypeMeta(), &mp4.Meta{}))
+	meta := udta.Children[0]
+	meta.Info.Context = mp4.Context{UnderUdta: true}
+	require.NoError(t, meta.Append(mp4.BoxTypeHdlr(), &mp4.Hdlr{HandlerType: [4]byte{'m', 'd', 'i', 'r'}}))
+	meta.Children[0].Info.Context = mp4.Context{UnderUdta: true}
+	ilst, err := m.buildIlst(meta.Path)
+	require.NoError(t, err)
+	require.NoError(t, boxtree.NewEditor(root).Insert("moov.udta.meta", -1, ilst))
+
+	name := filepath.Join(t.TempDir(), "ilst.mp4")
+	output, err := os.Create(name)
+	require.NoError(t, err)
+	_, err = boxtree.Marshal(output, root)
+	require.NoError(t, err)
+	require.NoError(t, output.Close())
+
+	input, err := os.Open(name)
+	require.NoError(t, err)
+	defer input.Close()
+	root, err = boxtree.Unmarshal(input)
+	require.NoError(t, err)
+	return root
+}
+
+func TestRead(t *testing.T) {
+	expected := &Metadata{
+		Title:        ref("Title"),
+		ArtistName:   ref("Artist"),
+		Genre:        genre(14),
+		GenreName:    ref("Genre"),
+		Track:        &Track{TrackNumber: 3, TrackCount: 12},
+		DiskNumber:   &Disk{DiskNumber: 1, DiskCount: 2},
+		Compilation:  flag(false),
+		ItemID:       ref(uint32(1440833098)),
+		MediaType:    ref(uint8(1)),
+		Cover:        []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0, 0xFF, 0xD9},
+		Comment:      ref("Comment"),
+		Description:  ref("Description"),
+		Tempo:        ref(uint16(120)),
+		EncodingTool: ref("downloader 1.0"),
+		ISRC:         ref("USUM71900001"),
+		Label:        ref("Label"),
+		AudioTraits:  ref("lossless, lossy-stereo"),
+		Gapless:      &Gapless{Priming: 2112, Remainder: 1000, Samples: 44100 * 3},
+	}
+	actual, err := Read(writeIlst(t, expected))
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	t.Run("missing ilst", func(t *testing.T) {
+		_, err := Read(&boxtree.BoxNode{})
+		assert.EqualError(t, err, "not found moov")
+	})
+}
+
+func TestParseITunSMPB(t *testing.T) {
+	gapless := &Gapless{Priming: 2112, Remainder: 448, Samples: 8467328}
+	actual, err := ParseITunSMPB(gapless.ITunSMPB())
+	require.NoError(t, err)
+	assert.Equal(t, gapless, actual)
+
+	_, err = ParseITunSMPB(" 00000000 00000840")
+	assert.Error(t, err)
+}
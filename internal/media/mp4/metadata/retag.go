@@ -0,0 +1,184 @@
+package metadata
+
+import (
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/quicktime"
+	"downloader/pkg/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+// RetagPadding is the room left in the free box of the meta box when the media data of a
+// retagged file has to move, so that it may keep its offsets the next time.
+const RetagPadding = 4096
+
+// Identity is what identifies the track of a file among its tags.
+type Identity struct {
+	ItemID     *uint32
+	ISRC       string
+	MusicVideo bool
+	Preview    bool
+}
+
+// Identify reads the catalog ID (cnID) of the track of a file, and its ISRC, from the xid tag
+// (<provider>:isrc:<code>) of the files tagged before the ISRC item, along with its kind.
+func Identify(root *boxtree.BoxNode) (id Identity) {
+	m, err := Read(root)
+	if err != nil {
+		m = &Metadata{}
+	}
+	id.ItemID = m.ItemID
+	if m.ISRC != nil {
+		id.ISRC = *m.ISRC
+	} else if m.XID != nil {
+		parts := strings.Split(*m.XID, ":")
+		if idx := slices.Index(parts, "isrc"); idx >= 0 && idx+1 < len(parts) {
+			id.ISRC = parts[idx+1]
+		}
+	}
+	if m.MediaType != nil {
+		id.MusicVideo = *m.MediaType == uint8(quicktime.MediaTypeMusicVideo)
+	} else {
+		_, err = root.P("moov.trak[mdia.hdlr.handler_type=vide]")
+		id.MusicVideo = err == nil
+	}
+	if m.Title != nil {
+		id.Preview = strings.HasSuffix(*m.Title, PreviewSuffix)
+	}
+	return
+}
+
+// replace replaces the iTunes metadata of a tree, keeping the freeform items of the old one
+// which are not set anew, such as the gapless playback information of the stream.
+func (m *Metadata) replace(editor *boxtree.Editor) (err error) {
+	kept, _ := editor.Root.P("moov.udta.meta.ilst.----")
+	// the file may have no metadata yet
+	_, _ = editor.Remove("moov.udta.meta")
+	if err = m.Attach(editor.Root); err != nil {
+		return
+	}
+
+	var ilst []*boxtree.BoxNode
+	if ilst, err = editor.Root.P("moov.udta.meta.ilst"); err != nil {
+		return
+	}
+	var tags []string
+	for _, item := range ilst[0].Cache[mp4.StrToBoxType("----")] {
+		tags = append(tags, freeformTag(item))
+	}
+	for _, item := range kept {
+		if !slices.Contains(tags, freeformTag(item)) {
+			if err = editor.Insert("moov.udta.meta.ilst", -1, item); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// pad grows the free box which Attach leaves after the ilst box to a payload of size bytes.
+func pad(root *boxtree.BoxNode, size uint64) (err error) {
+	var forest []*boxtree.BoxNode
+	if forest, err = root.P("moov.udta.meta.free"); err != nil {
+		return
+	}
+	forest[len(forest)-1].Box.(*mp4.Free).Data = make([]byte, size)
+	return
+}
+
+// retag replaces the iTunes metadata of a tree, keeping the offsets of the media data when
+// the new moov box fits in the room of the old one and of the free boxes following it, the
+// rest being left as padding. Otherwise, the moov box grows with RetagPadding bytes of padding.
+func (m *Metadata) retag(editor *boxtree.Editor) (inPlace bool, err error) {
+	root := editor.Root
+	moov := root.Cache[mp4.BoxTypeMoov()]
+	if len(moov) != 1 {
+		return false, errors.New("moov box is required")
+	}
+
+	start := moov[0].Info.Offset
+	end := start + moov[0].Info.Size
+	atEnd := true
+	var free []*boxtree.BoxNode
+	for _, node := range root.Children[slices.Index(root.Children, moov[0])+1:] {
+		if node.Info.Type != mp4.BoxTypeFree() && node.Info.Type != mp4.BoxTypeSkip() {
+			atEnd = false
+			break
+		}
+		free = append(free, node)
+		end = node.Info.Offset + node.Info.Size
+	}
+
+	if err = m.replace(editor); err != nil {
+		return
+	}
+	counter := utils.NewNullWriter()
+	size, err := boxtree.Marshal(counter, moov[0])
+	utils.CloseQuietly(counter)
+	if err != nil {
+		return
+	}
+
+	room := end - start
+	if atEnd {
+		room = max(room, size)
+	}
+	if size > room {
+		return false, pad(root, RetagPadding)
+	}
+	root.Children = slices.DeleteFunc(root.Children, func(node *boxtree.BoxNode) bool {
+		return slices.Contains(free, node)
+	})
+	if err = root.Caching(); err != nil {
+		return
+	}
+	return true, pad(root, room-size)
+}
+
+// Retag replaces the iTunes metadata of an MP4 file, which is written anew to a temporary file
+// replacing it once complete, the media data being copied without being loaded. It reports
+// whether the media data kept its offsets (see retag).
+func (m *Metadata) Retag(name string) (inPlace bool, err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(name); err != nil {
+		return
+	}
+	var input, output *os.File
+	if input, err = os.Open(name); err != nil {
+		return
+	}
+	if output, err = os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*"); err != nil {
+		utils.CloseQuietly(input)
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(output.Name())
+		}
+	}()
+
+	var root *boxtree.BoxNode
+	if root, err = boxtree.UnmarshalStructure(input); err == nil {
+		editor := boxtree.NewEditorWithSource(root, input)
+		if inPlace, err = m.retag(editor); err == nil {
+			err = editor.Write(output)
+		}
+	}
+	utils.CloseQuietly(input)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	if err = os.Chmod(output.Name(), info.Mode()); err != nil {
+		return
+	}
+	err = os.Rename(output.Name(), name)
+	return
+}
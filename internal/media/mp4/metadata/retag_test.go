@@ -0,0 +1,78 @@
+package metadata
+
+import (
+	"downloader/internal/media/mp4/boxtree"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendChild(t *testing.T, parent *boxtree.BoxNode, boxType mp4.BoxType, box mp4.IBox) *boxtree.BoxNode {
+	require.NoError(t, parent.Append(boxType, box))
+	return parent.Children[len(parent.Children)-1]
+}
+
+func TestRetag(t *testing.T) {
+	root := &boxtree.BoxNode{}
+	appendChild(t, root, mp4.BoxTypeFtyp(), &mp4.Ftyp{MajorBrand: [4]byte{'M', '4', 'A', ' '}})
+	moov := appendChild(t, root, mp4.BoxTypeMoov(), &mp4.Moov{})
+	appendChild(t, moov, mp4.BoxTypeMvhd(), &mp4.Mvhd{Timescale: 1000, NextTrackID: 2})
+	trak := appendChild(t, moov, mp4.BoxTypeTrak(), &mp4.Trak{})
+	appendChild(t, trak, mp4.BoxTypeTkhd(), &mp4.Tkhd{TrackID: 1})
+	mdia := appendChild(t, trak, mp4.BoxTypeMdia(), &mp4.Mdia{})
+	appendChild(t, mdia, mp4.BoxTypeMdhd(), &mp4.Mdhd{Timescale: 44100})
+	appendChild(t, mdia, mp4.BoxTypeHdlr(), &mp4.Hdlr{HandlerType: [4]byte{'s', 'o', 'u', 'n'}})
+	minf := appendChild(t, mdia, mp4.BoxTypeMinf(), &mp4.Minf{})
+	appendChild(t, minf, mp4.BoxTypeSmhd(), &mp4.Smhd{})
+	appendChild(t, appendChild(t, minf, mp4.BoxTypeDinf(), &mp4.Dinf{}), mp4.BoxTypeDref(), &mp4.Dref{})
+	stbl := appendChild(t, minf, mp4.BoxTypeStbl(), &mp4.Stbl{})
+	appendChild(t, stbl, mp4.BoxTypeStsd(), &mp4.Stsd{})
+	appendChild(t, stbl, mp4.BoxTypeStts(), &mp4.Stts{})
+	appendChild(t, stbl, mp4.BoxTypeStsc(), &mp4.Stsc{})
+	appendChild(t, stbl, mp4.BoxTypeStco(), &mp4.Stco{})
+	require.NoError(t, root.Append(mp4.BoxTypeFree(), &mp4.Free{Data: make([]byte, 1000)}))
+	data := []byte("media data")
+	require.NoError(t, root.Append(mp4.BoxTypeMdat(), &mp4.Mdat{Data: data}))
+	name := filepath.Join(t.TempDir(), "retag.m4a")
+	output, err := os.Create(name)
+	require.NoError(t, err)
+	_, err = boxtree.Marshal(output, root)
+	require.NoError(t, err)
+	require.NoError(t, output.Close())
+
+	read := func() (*boxtree.BoxNode, *mp4.BoxInfo) {
+		input, err := os.Open(name)
+		require.NoError(t, err)
+		defer input.Close()
+		root, err := boxtree.Unmarshal(input)
+		require.NoError(t, err)
+		mdat := root.Cache[mp4.BoxTypeMdat()][0]
+		assert.Equal(t, data, mdat.Box.(*mp4.Mdat).Data)
+		return root, mdat.Info
+	}
+	_, before := read()
+
+	inPlace, err := (&Metadata{Title: ref("Title")}).Retag(name)
+	require.NoError(t, err)
+	assert.True(t, inPlace)
+	retagged, after := read()
+	assert.Equal(t, before.Offset, after.Offset)
+	assert.Len(t, retagged.Children, 3)
+	m, err := Read(retagged)
+	require.NoError(t, err)
+	assert.Equal(t, "Title", *m.Title)
+
+	inPlace, err = (&Metadata{Cover: make([]byte, 2000)}).Retag(name)
+	require.NoError(t, err)
+	assert.False(t, inPlace)
+	_, after = read()
+	assert.Greater(t, after.Offset, before.Offset)
+
+	entries, err := os.ReadDir(filepath.Dir(name))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,325 @@
+package mp4utils
+
+import (
+	"bytes"
+	"downloader/internal/media/m3u8/hlsutils/codec"
+	"downloader/internal/media/mp4/bitstruct"
+	"downloader/internal/media/mp4/bitstruct/bitio"
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/metadata"
+	"errors"
+	"fmt"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+func BoxTypeAlac() mp4.BoxType { return mp4.StrToBoxType("alac") }
+
+func BoxTypeDec3() mp4.BoxType { return mp4.StrToBoxType("dec3") }
+
+var ErrNotAudioSampleEntry = errors.New("not an audio sample entry")
+
+// SamplingFrequencies indexed by samplingFrequencyIndex (ISO/IEC 14496-3 Table 1.18)
+var SamplingFrequencies = []uint32{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// channelConfigurations maps the channelConfiguration of an AudioSpecificConfig onto its
+// number of channels and layout (ISO/IEC 14496-3 Table 1.19)
+var channelConfigurations = map[uint8]struct {
+	Channels uint8
+	Layout   string
+}{
+	1:  {1, "1.0"},
+	2:  {2, "2.0"},
+	3:  {3, "3.0"},
+	4:  {4, "4.0"},
+	5:  {5, "5.0"},
+	6:  {6, "5.1"},
+	7:  {8, "7.1"},
+	11: {7, "6.1"},
+	12: {8, "7.1"},
+	13: {24, "22.2"},
+	14: {8, "7.1"},
+}
+
+// ALACSpecificConfig is the ALAC magic cookie
+//
+// https://github.com/macosforge/alac/blob/master/ALACMagicCookieDescription.txt
+type ALACSpecificConfig struct {
+	bitstruct.BaseFieldObject
+	FrameLength       uint32 `bit:"0,size=32"`
+	CompatibleVersion uint8  `bit:"1,size=8"`
+	BitDepth          uint8  `bit:"2,size=8"`
+	PB                uint8  `bit:"3,size=8"`
+	MB                uint8  `bit:"4,size=8"`
+	KB                uint8  `bit:"5,size=8"`
+	NumChannels       uint8  `bit:"6,size=8"`
+	MaxRun            uint16 `bit:"7,size=16"`
+	MaxFrameBytes     uint32 `bit:"8,size=32"`
+	AvgBitRate        uint32 `bit:"9,size=32"`
+	SampleRate        uint32 `bit:"10,size=32"`
+}
+
+const alacSpecificConfigSize = 24
+
+// rawPayload returns the payload of a box, whichever type go-mp4 decoded it into.
+func rawPayload(node *boxtree.BoxNode) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := mp4.Marshal(&buf, node.Box, node.Info.Context); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func channelLayout(channels uint8) string {
+	switch channels {
+	case 1:
+		return "1.0"
+	case 2:
+		return "2.0"
+	case 6:
+		return "5.1"
+	case 8:
+		return "7.1"
+	default:
+		return fmt.Sprintf("%dch", channels)
+	}
+}
+
+// AudioInfoOf reads the audio sample entry of a track (a child of stsd), refining the
+// values of the entry with its decoder configuration.
+func AudioInfoOf(entry *boxtree.BoxNode) (info *metadata.AudioInfo, err error) {
+	sampleEntry, ok := entry.Box.(*mp4.AudioSampleEntry)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotAudioSampleEntry, entry.Info.Type)
+	}
+
+	info = &metadata.AudioInfo{
+		SampleRate: sampleEntry.SampleRate >> 16,
+		Channels:   uint8(sampleEntry.ChannelCount),
+	}
+	// unknown sample entries are still described by their generic fields
+	info.Codec, _ = CodecString(entry)
+
+	switch SampleEntryType(entry) {
+	case mp4.BoxTypeMp4a():
+		var esds *mp4.Esds
+		if esds, err = findBox[*mp4.Esds](entry, mp4.BoxTypeEsds()); err != nil {
+			return
+		}
+		for _, descriptor := range esds.Descriptors {
+			if descriptor.Tag == esDecSpecificInfoTag {
+				err = readAudioSpecificConfig(descriptor.Data, info)
+			}
+		}
+	case mp4.BoxType(codec.ALACIndicator):
+		// the magic cookie is a child of the sample entry sharing its type
+		if nodes, found := entry.Cache[BoxTypeAlac()]; found && len(nodes) > 0 {
+			err = readALACSpecificConfig(nodes[0], info)
+		} else {
+			info.BitDepth = uint8(sampleEntry.SampleSize)
+		}
+	case mp4.BoxType(codec.FLACIndicator):
+		info.BitDepth = uint8(sampleEntry.SampleSize)
+	case mp4.BoxType(codec.EC3Indicator):
+		if nodes, found := entry.Cache[BoxTypeDec3()]; found && len(nodes) > 0 {
+			err = readEC3SpecificBox(nodes[0], info)
+		}
+	case mp4.BoxType(codec.AC3Indicator):
+		var dac3 *mp4.Dac3
+		if dac3, err = findBox[*mp4.Dac3](entry, mp4.BoxTypeDAC3()); err != nil {
+			return
+		}
+		info.SampleRate = ac3SampleRate(dac3.Fscod)
+		fullBand, lfe := ac3Channels(dac3.Acmod, dac3.LfeOn)
+		info.Channels = fullBand + lfe
+		info.ChannelLayout = fmt.Sprintf("%d.%d", fullBand, lfe)
+	case mp4.BoxTypeOpus():
+		// Opus is always decoded at 48 kHz
+		info.SampleRate = 48000
+		if dOps, e := findBox[*mp4.DOps](entry, mp4.BoxTypeDOps()); e == nil {
+			info.Channels = dOps.OutputChannelCount
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	if len(info.ChannelLayout) == 0 {
+		info.ChannelLayout = channelLayout(info.Channels)
+	}
+	return
+}
+
+// readAudioSpecificConfig reads the AudioSpecificConfig (ISO/IEC 14496-3 Section 1.6.2.1),
+// taking the explicit signalling of SBR and PS into account.
+func readAudioSpecificConfig(config []byte, info *metadata.AudioInfo) (err error) {
+	r := bitio.NewReader(bytes.NewReader(config))
+
+	var readObjectType = func() (objectType uint64, err error) {
+		if objectType, err = bitio.ReadUint(r, 5); err != nil || objectType != 31 {
+			return
+		}
+		if objectType, err = bitio.ReadUint(r, 6); err != nil {
+			return
+		}
+		return 32 + objectType, nil
+	}
+	var readSamplingFrequency = func() (frequency uint32, err error) {
+		var index uint64
+		if index, err = bitio.ReadUint(r, 4); err != nil {
+			return
+		}
+		if index == 0xF {
+			var explicit uint64
+			explicit, err = bitio.ReadUint(r, 24)
+			return uint32(explicit), err
+		}
+		if int(index) >= len(SamplingFrequencies) {
+			return 0, fmt.Errorf("invalid sampling frequency index %d", index)
+		}
+		return SamplingFrequencies[index], nil
+	}
+
+	var objectType, channelConfiguration uint64
+	if objectType, err = readObjectType(); err != nil {
+		return
+	}
+	if info.SampleRate, err = readSamplingFrequency(); err != nil {
+		return
+	}
+	if channelConfiguration, err = bitio.ReadUint(r, 4); err != nil {
+		return
+	}
+	if configuration, found := channelConfigurations[uint8(channelConfiguration)]; found {
+		info.Channels = configuration.Channels
+		info.ChannelLayout = configuration.Layout
+	}
+
+	if objectType == 5 || objectType == 29 {
+		// SBR doubles the sample rate of the core, PS makes stereo out of mono
+		if info.SampleRate, err = readSamplingFrequency(); err != nil {
+			return
+		}
+		if objectType == 29 && info.Channels == 1 {
+			info.Channels = 2
+			info.ChannelLayout = "2.0"
+		}
+	}
+	return
+}
+
+func readALACSpecificConfig(node *boxtree.BoxNode, info *metadata.AudioInfo) (err error) {
+	var payload []byte
+	if payload, err = rawPayload(node); err != nil {
+		return
+	}
+	if len(payload) < alacSpecificConfigSize {
+		return errors.New("invalid alac magic cookie")
+	}
+	// skip the version and flags of the full box
+	payload = payload[len(payload)-alacSpecificConfigSize:]
+
+	var config ALACSpecificConfig
+	if _, err = bitstruct.Unmarshal(bytes.NewReader(payload), alacSpecificConfigSize, &config); err != nil {
+		return
+	}
+	info.BitDepth = config.BitDepth
+	info.Channels = config.NumChannels
+	info.SampleRate = config.SampleRate
+	return
+}
+
+func ac3SampleRate(fscod uint8) uint32 {
+	switch fscod {
+	case 0:
+		return 48000
+	case 1:
+		return 44100
+	case 2:
+		return 32000
+	default:
+		return 0
+	}
+}
+
+// ac3Channels returns the number of full bandwidth and low frequency channels of an
+// audio coding mode (ETSI TS 102 366 Table 4.3)
+func ac3Channels(acmod uint8, lfeon uint8) (fullBand uint8, lfe uint8) {
+	return []uint8{2, 1, 2, 3, 3, 4, 4, 5}[acmod&7], lfeon & 1
+}
+
+// readEC3SpecificBox reads the dec3 box (ETSI TS 102 366 Section F.6), whose
+// flag_ec3_extension_type_a signals Joint Object Coding, i.e. Dolby Atmos.
+func readEC3SpecificBox(node *boxtree.BoxNode, info *metadata.AudioInfo) (err error) {
+	var payload []byte
+	if payload, err = rawPayload(node); err != nil {
+		return
+	}
+	r := bitio.NewReader(bytes.NewReader(payload))
+
+	var numIndSub uint64
+	if _, err = bitio.ReadUint(r, 13); err != nil { // data_rate
+		return
+	}
+	if numIndSub, err = bitio.ReadUint(r, 3); err != nil {
+		return
+	}
+
+	var fullBand, lfe uint8
+	for i := uint64(0); i <= numIndSub; i++ {
+		var fscod, acmod, lfeon, numDepSub, chanLoc uint64
+		if fscod, err = bitio.ReadUint(r, 2); err != nil {
+			return
+		}
+		// bsid (5), reserved (1), asvc (1), bsmod (3)
+		if _, err = bitio.ReadUint(r, 10); err != nil {
+			return
+		}
+		if acmod, err = bitio.ReadUint(r, 3); err != nil {
+			return
+		}
+		if lfeon, err = bitio.ReadUint(r, 1); err != nil {
+			return
+		}
+		if _, err = bitio.ReadUint(r, 3); err != nil { // reserved
+			return
+		}
+		if numDepSub, err = bitio.ReadUint(r, 4); err != nil {
+			return
+		}
+		if numDepSub > 0 {
+			chanLoc, err = bitio.ReadUint(r, 9)
+		} else {
+			_, err = bitio.ReadUint(r, 1) // reserved
+		}
+		if err != nil {
+			return
+		}
+
+		if i == 0 {
+			info.SampleRate = ac3SampleRate(uint8(fscod))
+			fullBand, lfe = ac3Channels(uint8(acmod), uint8(lfeon))
+			// Lc/Rc, Lrs/Rrs, Cs, Ts, Lsd/Rsd, Lw/Rw, Lvh/Rvh, Cvh, LFE2
+			for bit, channels := range []uint8{2, 2, 1, 1, 2, 2, 2, 1, 1} {
+				if chanLoc&(1<<(8-bit)) != 0 {
+					if bit == 8 {
+						lfe += channels
+					} else {
+						fullBand += channels
+					}
+				}
+			}
+		}
+	}
+	info.Channels = fullBand + lfe
+	info.ChannelLayout = fmt.Sprintf("%d.%d", fullBand, lfe)
+
+	// reserved (7), flag_ec3_extension_type_a (1), complexity_index_type_a (8)
+	var flag uint64
+	if _, e := bitio.ReadUint(r, 7); e == nil {
+		if flag, e = bitio.ReadUint(r, 1); e == nil {
+			info.Atmos = flag == 1
+		}
+	}
+	return
+}
@@ -0,0 +1,158 @@
+package mp4utils
+
+import "errors"
+
+/*************************** AV1 ****************************/
+
+const av1OBUSequenceHeader = 1
+
+// leb128 reads an unsigned LEB128-coded integer (AV1 Section 4.10.5)
+func leb128(data []byte) (value uint64, n int) {
+	for n < len(data) && n < 8 {
+		value |= uint64(data[n]&0x7f) << (7 * n)
+		n++
+		if data[n-1]&0x80 == 0 {
+			return
+		}
+	}
+	return
+}
+
+// uvlc reads a variable length unsigned integer (AV1 Section 4.10.3)
+func (s *syntaxReader) uvlc() uint64 {
+	var leadingZeros uint
+	for !s.flag() && s.err == nil {
+		leadingZeros++
+	}
+	if leadingZeros >= 32 {
+		return 1<<32 - 1
+	}
+	return s.u(leadingZeros) + (1 << leadingZeros) - 1
+}
+
+// parseAV1ConfigOBUs finds the sequence header OBU among the configOBUs of an av1C.
+func parseAV1ConfigOBUs(obus []byte, info *VideoInfo) error {
+	for len(obus) > 0 {
+		header := obus[0]
+		obuType := header >> 3 & 0x0f
+		offset := 1
+		if header&0x04 != 0 { // obu_extension_flag
+			offset++
+		}
+		size := uint64(len(obus) - offset)
+		if header&0x02 != 0 { // obu_has_size_field
+			var n int
+			size, n = leb128(obus[min(offset, len(obus)):])
+			offset += n
+		}
+		if uint64(len(obus)) < uint64(offset)+size {
+			return errors.New("truncated obu")
+		}
+		if obuType == av1OBUSequenceHeader {
+			return parseAV1SequenceHeader(obus[offset:uint64(offset)+size], info)
+		}
+		obus = obus[uint64(offset)+size:]
+	}
+	return errors.New("av1C without sequence header")
+}
+
+// parseAV1SequenceHeader reads sequence_header_obu (AV1 Section 5.5)
+func parseAV1SequenceHeader(obu []byte, info *VideoInfo) error {
+	s := newSyntaxReader(obu)
+
+	seqProfile := s.u(3)
+	s.flag() // still_picture
+	reducedStillPictureHeader := s.flag()
+	if reducedStillPictureHeader {
+		s.skip(5) // seq_level_idx[0]
+	} else {
+		var decoderModelInfoPresent bool
+		var bufferDelayLength uint
+		if s.flag() { // timing_info_present_flag
+			numUnitsInDisplayTick, timeScale := s.u(32), s.u(32)
+			ticksPerPicture := uint64(1)
+			if s.flag() { // equal_picture_interval
+				ticksPerPicture = s.uvlc() + 1
+			}
+			if numUnitsInDisplayTick != 0 {
+				info.FrameRate = float64(timeScale) / float64(numUnitsInDisplayTick*ticksPerPicture)
+			}
+			if decoderModelInfoPresent = s.flag(); decoderModelInfoPresent {
+				bufferDelayLength = uint(s.u(5)) + 1
+				s.skip(32) // num_units_in_decoding_tick
+				s.skip(10) // buffer_removal_time_length_minus_1, frame_presentation_time_length_minus_1
+			}
+		}
+		initialDisplayDelayPresent := s.flag()
+		operatingPointsCntMinus1 := s.u(5)
+		for i := uint64(0); i <= operatingPointsCntMinus1 && s.err == nil; i++ {
+			s.skip(12)      // operating_point_idc
+			if s.u(5) > 7 { // seq_level_idx
+				s.flag() // seq_tier
+			}
+			if decoderModelInfoPresent && s.flag() { // decoder_model_present_for_this_op
+				s.skip(2*bufferDelayLength + 1) // decoder_buffer_delay, encoder_buffer_delay, low_delay_mode_flag
+			}
+			if initialDisplayDelayPresent && s.flag() {
+				s.skip(4) // initial_display_delay_minus_1
+			}
+		}
+	}
+
+	widthBits, heightBits := uint(s.u(4))+1, uint(s.u(4))+1
+	width, height := s.u(widthBits)+1, s.u(heightBits)+1
+
+	if !reducedStillPictureHeader && s.flag() { // frame_id_numbers_present_flag
+		s.skip(7) // delta_frame_id_length_minus_2, additional_frame_id_length_minus_1
+	}
+	s.skip(3) // use_128x128_superblock, enable_filter_intra, enable_intra_edge_filter
+	if !reducedStillPictureHeader {
+		s.skip(4) // enable_interintra_compound, enable_masked_compound, enable_warped_motion, enable_dual_filter
+		enableOrderHint := s.flag()
+		if enableOrderHint {
+			s.skip(2) // enable_jnt_comp, enable_ref_frame_mvs
+		}
+		forceScreenContentTools := uint64(2)
+		if !s.flag() { // seq_choose_screen_content_tools
+			forceScreenContentTools = s.u(1)
+		}
+		if forceScreenContentTools > 0 && !s.flag() { // seq_choose_integer_mv
+			s.skip(1) // seq_force_integer_mv
+		}
+		if enableOrderHint {
+			s.skip(3) // order_hint_bits_minus_1
+		}
+	}
+	s.skip(3) // enable_superres, enable_cdef, enable_restoration
+
+	// color_config (AV1 Section 5.5.2)
+	bitDepth := uint8(8)
+	if s.flag() { // high_bitdepth
+		bitDepth = 10
+		if seqProfile == 2 && s.flag() { // twelve_bit
+			bitDepth = 12
+		}
+	}
+	var monoChrome bool
+	if seqProfile != 1 {
+		monoChrome = s.flag()
+	}
+	if s.flag() { // color_description_present_flag
+		info.ColourPrimaries = uint8(s.u(8))
+		info.TransferCharacteristics = uint8(s.u(8))
+		info.MatrixCoefficients = uint8(s.u(8))
+	}
+	if !monoChrome && info.ColourPrimaries == 1 && info.TransferCharacteristics == 13 && info.MatrixCoefficients == 0 {
+		info.FullRange = true // sRGB
+	} else {
+		info.FullRange = s.flag() // color_range
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	info.Width = uint32(width)
+	info.Height = uint32(height)
+	info.BitDepth = bitDepth
+	return nil
+}
@@ -0,0 +1,216 @@
+package mp4utils
+
+import (
+	"downloader/internal/media/m3u8/hlsutils/codec"
+	"downloader/internal/media/mp4/boxtree"
+	"errors"
+	"fmt"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+/*************************** dvcC ****************************/
+
+func BoxTypeDvcC() mp4.BoxType { return mp4.StrToBoxType("dvcC") }
+
+func BoxTypeDvvC() mp4.BoxType { return mp4.StrToBoxType("dvvC") }
+
+func init() {
+	mp4.AddAnyTypeBoxDef(&DolbyVisionConfiguration{}, BoxTypeDvcC())
+	mp4.AddAnyTypeBoxDef(&DolbyVisionConfiguration{}, BoxTypeDvvC())
+}
+
+// DolbyVisionConfiguration is the Dolby Vision configuration box
+//
+// Dolby Vision Streams Within the ISO Base Media File Format, Section 3.1
+type DolbyVisionConfiguration struct {
+	mp4.AnyTypeBox
+	VersionMajor            uint8     `mp4:"0,size=8"`
+	VersionMinor            uint8     `mp4:"1,size=8"`
+	Profile                 uint8     `mp4:"2,size=7"`
+	Level                   uint8     `mp4:"3,size=6"`
+	RpuPresentFlag          bool      `mp4:"4,size=1"`
+	ElPresentFlag           bool      `mp4:"5,size=1"`
+	BlPresentFlag           bool      `mp4:"6,size=1"`
+	BlSignalCompatibilityID uint8     `mp4:"7,size=4"`
+	Reserved                uint32    `mp4:"8,size=28,const=0"`
+	Reserved2               [4]uint32 `mp4:"9,size=32,const=0"`
+}
+
+/*************************** codecs ****************************/
+
+var ErrConfigurationNotFound = errors.New("decoder configuration not found")
+
+const (
+	esDecoderConfigDescrTag = 0x04
+	esDecSpecificInfoTag    = 0x05
+)
+
+// SampleEntryType returns the type of the sample entry, or the original one for encrypted
+// entries (encv, enca) which keep it in sinf.frma.
+func SampleEntryType(entry *boxtree.BoxNode) mp4.BoxType {
+	if nodes, err := entry.P("sinf.frma"); err == nil {
+		if frma, ok := nodes[0].Box.(*mp4.Frma); ok {
+			return frma.DataFormat
+		}
+	}
+	return entry.Info.Type
+}
+
+func findBox[T mp4.IBox](entry *boxtree.BoxNode, boxTypes ...mp4.BoxType) (box T, err error) {
+	for _, boxType := range boxTypes {
+		if nodes, found := entry.Cache[boxType]; found && len(nodes) > 0 {
+			if box, ok := nodes[0].Box.(T); ok {
+				return box, nil
+			}
+		}
+	}
+	err = fmt.Errorf("%w: %s", ErrConfigurationNotFound, entry.Info.Type)
+	return
+}
+
+// CodecOf builds the codec of a sample entry (a child of stsd) from its decoder
+// configuration, the inverse of codec.Initialize.
+func CodecOf(entry *boxtree.BoxNode) (c codec.ICodec, err error) {
+	indicator := codec.CodecIndicator(SampleEntryType(entry))
+
+	switch {
+	case codec.IsDolbyVision(indicator):
+		var dv *DolbyVisionConfiguration
+		if dv, err = findBox[*DolbyVisionConfiguration](entry, BoxTypeDvcC(), BoxTypeDvvC()); err != nil {
+			return
+		}
+		c = &codec.DolbyVisionCodec{
+			Codec:   codec.Codec{CodecIndicator: indicator},
+			Profile: dv.Profile,
+			Level:   dv.Level,
+		}
+	case indicator == codec.MPEG4IndicatorMP4A || indicator == codec.MPEG4IndicatorMP4V:
+		var esds *mp4.Esds
+		if esds, err = findBox[*mp4.Esds](entry, mp4.BoxTypeEsds()); err != nil {
+			return
+		}
+		mp4Codec := &codec.MP4Codec{Codec: codec.Codec{CodecIndicator: indicator}}
+		for _, descriptor := range esds.Descriptors {
+			switch descriptor.Tag {
+			case esDecoderConfigDescrTag:
+				if descriptor.DecoderConfigDescriptor == nil {
+					continue
+				}
+				mp4Codec.ObjectTypeIndication = descriptor.DecoderConfigDescriptor.ObjectTypeIndication
+			case esDecSpecificInfoTag:
+				if indicator == codec.MPEG4IndicatorMP4A {
+					mp4Codec.ObjectTypeID = audioObjectType(descriptor.Data)
+				}
+			}
+		}
+		c = mp4Codec
+	default:
+		switch indicator.GetCodecFamily() {
+		case codec.FamilyAVC:
+			var avcC *mp4.AVCDecoderConfiguration
+			if avcC, err = findBox[*mp4.AVCDecoderConfiguration](entry, mp4.BoxTypeAvcC()); err != nil {
+				return
+			}
+			c = &codec.AVCCodec{
+				Codec:              codec.Codec{CodecIndicator: indicator},
+				ProfileIndicator:   avcC.Profile,
+				ConstraintSetFlags: avcC.ProfileCompatibility,
+				LevelIndicator:     avcC.Level,
+			}
+		case codec.FamilyHEVC:
+			var hvcC *mp4.HvcC
+			if hvcC, err = findBox[*mp4.HvcC](entry, mp4.BoxTypeHvcC()); err != nil {
+				return
+			}
+			hevcCodec := &codec.HEVCCodec{
+				Codec:           codec.Codec{CodecIndicator: indicator},
+				ConstraintFlags: hvcC.GeneralConstraintIndicator,
+			}
+			hevcCodec.GeneralProfile.GeneralProfileSpace = hvcC.GeneralProfileSpace
+			hevcCodec.GeneralProfile.GeneralProfileIndicator = hvcC.GeneralProfileIdc
+			// general_profile_compatibility_flag[0] is read first, but is the least significant bit
+			for idx, flag := range hvcC.GeneralProfileCompatibility {
+				if flag {
+					hevcCodec.GeneralProfileCompatibilityFlags |= 1 << idx
+				}
+			}
+			if hvcC.GeneralTierFlag {
+				hevcCodec.GeneralTierLevel.GeneralTierFlag = 1
+			}
+			hevcCodec.GeneralTierLevel.GeneralLevelIndicator = hvcC.GeneralLevelIdc
+			c = hevcCodec
+		case codec.FamilyAV1:
+			var av1C *mp4.Av1C
+			if av1C, err = findBox[*mp4.Av1C](entry, mp4.BoxTypeAv1C()); err != nil {
+				return
+			}
+			c = av1Codec(entry, indicator, av1C)
+		default:
+			// the configurations of ac-3, ec-3, alac, fLaC and Opus are not part of their codec strings
+			c = &codec.Codec{CodecIndicator: indicator}
+		}
+	}
+	return
+}
+
+// CodecString returns the RFC 6381 codec string of a sample entry, e.g. "mp4a.40.2".
+func CodecString(entry *boxtree.BoxNode) (str string, err error) {
+	defer func() {
+		// codec.CodecIndicator.GetCodecFamily panics on unknown sample entries
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %s", codec.ErrCodecUnsupported, entry.Info.Type)
+		}
+	}()
+
+	var c codec.ICodec
+	if c, err = CodecOf(entry); err != nil {
+		return
+	}
+	return c.String(), nil
+}
+
+// audioObjectType reads the audioObjectType of an AudioSpecificConfig (ISO/IEC 14496-3
+// Section 1.6.2.1), including its escape value.
+func audioObjectType(config []byte) uint8 {
+	if len(config) == 0 {
+		return 0
+	}
+	objectType := config[0] >> 3
+	if objectType == codec.AudioObjectTypeEscape && len(config) > 1 {
+		objectType = 32 + (config[0]&0x07)<<3 | config[1]>>5
+	}
+	return objectType
+}
+
+func av1Codec(entry *boxtree.BoxNode, indicator codec.CodecIndicator, av1C *mp4.Av1C) *codec.AV1Codec {
+	c := &codec.AV1Codec{
+		SampleEntry:             indicator,
+		Profile:                 av1C.SeqProfile,
+		BitDepth:                8,
+		Monochrome:              av1C.Monochrome != 0,
+		ColorPrimaries:          1,
+		TransferCharacteristics: 1,
+		MatrixCoefficients:      1,
+	}
+	c.LevelTier.Level.X = 2 + av1C.SeqLevelIdx0>>2
+	c.LevelTier.Level.Y = av1C.SeqLevelIdx0 & 3
+	c.LevelTier.SeqTier = av1C.SeqTier0
+	if av1C.HighBitdepth != 0 {
+		c.BitDepth = 10
+		if av1C.TwelveBit != 0 {
+			c.BitDepth = 12
+		}
+	}
+	c.ChromaSubsampling.SubsamplingX = av1C.ChromaSubsamplingX != 0
+	c.ChromaSubsampling.SubsamplingY = av1C.ChromaSubsamplingY != 0
+	c.ChromaSubsampling.ChromaSamplePosition = av1C.ChromaSamplePosition != 0
+
+	if colr, err := findBox[*mp4.Colr](entry, mp4.BoxTypeColr()); err == nil && colr.ColourType == [4]byte{'n', 'c', 'l', 'x'} {
+		c.ColorPrimaries = uint8(colr.ColourPrimaries)
+		c.TransferCharacteristics = uint8(colr.TransferCharacteristics)
+		c.MatrixCoefficients = uint8(colr.MatrixCoefficients)
+		c.VideoFullRangeFlag = colr.FullRangeFlag
+	}
+	return c
+}
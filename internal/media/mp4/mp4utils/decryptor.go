@@ -20,6 +20,8 @@ func (t *TrackCryptInfo) IsEncrypted() bool {
 type IDecryptor interface {
 	cmaf.IContext
 	GetSamples() map[uint32][]Sample
+	GetSampleGroups() SampleGroups
+	GetSourceFragments() SourceFragments
 	DecryptHeader([][]byte) error
 	DecryptSegment(*cmaf.Segment, [][]byte) error
 	DecryptFragment(cmaf.MovieFragmentBox, *mp4.Mdat, [][]byte) error
@@ -32,13 +34,18 @@ type ISampleDecryptor interface {
 type DecryptContext struct {
 	cmaf.Context
 	ISampleDecryptor
-	Samples map[uint32][]Sample
+	Samples         map[uint32][]Sample
+	SampleGroups    SampleGroups
+	SourceFragments SourceFragments
 }
 
 func (ctx *DecryptContext) Initialize(input io.ReadSeeker) (err error) {
 	if ctx.Samples == nil {
 		ctx.Samples = make(map[uint32][]Sample)
 	}
+	if ctx.SampleGroups == nil {
+		ctx.SampleGroups = make(SampleGroups)
+	}
 	return ctx.Context.Initialize(input)
 }
 
@@ -46,20 +53,55 @@ func (ctx *DecryptContext) Finalize(output io.WriteSeeker) (err error) {
 	return ctx.Context.Finalize(output)
 }
 
+func (ctx *DecryptContext) AddInitialization(input io.ReadSeeker) (init *cmaf.Initialization, err error) {
+	if init, err = ctx.ReadInitialization(input); err != nil {
+		return
+	}
+	if err = decryptSampleEntries(init.Header); err != nil {
+		return
+	}
+	err = ctx.MergeInitialization(init)
+	return
+}
+
 func (ctx *DecryptContext) GetTrackCryptInfo(trackID uint32) (info TrackCryptInfo, err error) {
-	for _, trak := range ctx.Header.Moov.Trak {
+	hdr := ctx.CurrentHeader()
+	for _, trak := range hdr.Moov.Trak {
 		if trak.Tkhd.TrackID == trackID {
 			for _, entry := range trak.Mdia.Minf.Stbl.Stsd.Entries {
 				info.Sinf = entry.Sinf
 			}
 		}
 	}
-	for _, trex := range ctx.Header.Moov.Mvex.Trex {
+	for _, trex := range hdr.Moov.Mvex.Trex {
 		if trex.TrackID == trackID {
 			info.Trex = trex
 		}
 	}
-	info.Pssh = ctx.Header.Moov.Pssh
+	info.Pssh = hdr.Moov.Pssh
+	return
+}
+
+func decryptSampleEntries(hdr *cmaf.Header) (err error) {
+	for _, trak := range hdr.Moov.Trak {
+		for _, entry := range trak.Mdia.Minf.Stbl.Stsd.Entries {
+			if entry.Sinf == nil {
+				continue
+			}
+			entry.Node.Info.Type = entry.Sinf.Frma.DataFormat
+			switch hdr.GetMediaType() {
+			case cmaf.MediaTypeAudio:
+				entry.AudioSampleEntry.AnyTypeBox.Type = entry.Sinf.Frma.DataFormat
+			case cmaf.MediaTypeVideo:
+				entry.VisualSampleEntry.AnyTypeBox.Type = entry.Sinf.Frma.DataFormat
+			default:
+				entry.SampleEntry.AnyTypeBox.Type = entry.Sinf.Frma.DataFormat
+			}
+			if _, err = entry.Node.Remove(mp4.BoxTypeSinf()); err != nil {
+				return
+			}
+		}
+	}
 	return
 }
 
@@ -67,6 +109,14 @@ func (ctx *DecryptContext) GetSamples() map[uint32][]Sample {
 	return ctx.Samples
 }
 
+func (ctx *DecryptContext) GetSampleGroups() SampleGroups {
+	return ctx.SampleGroups
+}
+
+func (ctx *DecryptContext) GetSourceFragments() SourceFragments {
+	return ctx.SourceFragments
+}
+
 func (ctx *DecryptContext) DecryptHeader(keys [][]byte) (err error) {
 
 	{ // Ftyp
@@ -87,24 +137,8 @@ func (ctx *DecryptContext) DecryptHeader(keys [][]byte) (err error) {
 	}
 
 	{ // SampleEntry
-		for _, trak := range ctx.Header.Moov.Trak {
-			for _, entry := range trak.Mdia.Minf.Stbl.Stsd.Entries {
-				if entry.Sinf == nil {
-					continue
-				}
-				entry.Node.Info.Type = entry.Sinf.Frma.DataFormat
-				switch ctx.Header.GetMediaType() {
-				case cmaf.MediaTypeAudio:
-					entry.AudioSampleEntry.AnyTypeBox.Type = entry.Sinf.Frma.DataFormat
-				case cmaf.MediaTypeVideo:
-					entry.VisualSampleEntry.AnyTypeBox.Type = entry.Sinf.Frma.DataFormat
-				default:
-					entry.SampleEntry.AnyTypeBox.Type = entry.Sinf.Frma.DataFormat
-				}
-				if _, err = entry.Node.Remove(mp4.BoxTypeSinf()); err != nil {
-					return
-				}
-			}
+		if err = decryptSampleEntries(ctx.Header); err != nil {
+			return
 		}
 	}
 
@@ -147,6 +181,7 @@ func (ctx *DecryptContext) DecryptFragment(moof cmaf.MovieFragmentBox, mdat *mp4
 	totalDeleted += size
 
 	var samples []Sample
+	ctx.SourceFragments.begin()
 	for _, traf := range moof.Traf {
 		var cryptInfo TrackCryptInfo
 		if cryptInfo, err = ctx.GetTrackCryptInfo(traf.Tfhd.TrackID); err != nil {
@@ -162,7 +197,11 @@ func (ctx *DecryptContext) DecryptFragment(moof cmaf.MovieFragmentBox, mdat *mp4
 			}
 		}
 
-		ctx.Samples[traf.Tfhd.TrackID] = append(ctx.Samples[traf.Tfhd.TrackID], samples...)
+		trackID := remapSamples(ctx.CurrentInitialization(), traf.Tfhd.TrackID, samples)
+		ctx.SourceFragments.add(trackID, uint64(len(ctx.Samples[trackID])), uint32(len(samples)))
+		// the sample encryption groups do not apply to the decrypted samples
+		ctx.SampleGroups.Add(trackID, traf, uint64(len(ctx.Samples[trackID])), uint32(len(samples)), [4]byte{'s', 'e', 'i', 'g'})
+		ctx.Samples[trackID] = append(ctx.Samples[trackID], samples...)
 
 		for _, boxType := range []mp4.BoxType{
 			mp4.BoxTypeSaiz(),
@@ -0,0 +1,604 @@
+package mp4utils
+
+import (
+	"cmp"
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/cmaf"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+// sampleIsNonSyncSample is the sample_is_non_sync_sample bit of the sample flags
+const sampleIsNonSyncSample = 0x00010000
+
+type FragmentOptions struct {
+	// Sidx writes a sidx box indexing the fragments after the initialization segment
+	Sidx bool
+	// Mfra writes a mfra box ending the file, locating the fragments of every track
+	Mfra bool
+}
+
+// sourceRun is the run of samples of a track described by a traf box of the input
+type sourceRun struct {
+	TrackID     uint32
+	FirstSample uint32
+	SampleCount uint32
+}
+
+// SourceFragments records the moof boxes of the input, as the runs of samples of their traf
+// boxes, so that a fragmented output keeps the fragments of the input.
+type SourceFragments [][]sourceRun
+
+// begin records a moof box, whose traf boxes are then added.
+func (fragments *SourceFragments) begin() {
+	*fragments = append(*fragments, nil)
+}
+
+// add records a traf box of the last moof box, of sampleCount samples following offset
+// samples of the track.
+func (fragments *SourceFragments) add(trackID uint32, offset uint64, sampleCount uint32) {
+	if n := len(*fragments); n > 0 && sampleCount > 0 {
+		(*fragments)[n-1] = append((*fragments)[n-1], sourceRun{
+			TrackID:     trackID,
+			FirstSample: uint32(offset),
+			SampleCount: sampleCount,
+		})
+	}
+}
+
+// fragmentRun is a run of contiguous samples of a track described by a single sample entry,
+// written as a traf box of a fragment
+type fragmentRun struct {
+	Track       int
+	FirstSample uint32
+	SampleCount uint32
+	DecodeTime  uint64
+}
+
+type fragment struct {
+	Start time.Duration
+	Runs  []fragmentRun
+}
+
+// referenceTrack returns the index of the track whose sync samples start the fragments.
+func (ctx *MuxContext) referenceTrack() int {
+	return max(slices.IndexFunc(ctx.tracks, func(track muxTrack) bool {
+		return track.Trak.Mdia.Hdlr != nil && track.Trak.Mdia.Hdlr.HandlerType == cmaf.HandlerTypeVideo
+	}), 0)
+}
+
+// layoutSourceFragments lays the samples of the tracks out in the fragments of the input,
+// a traf box of the input being a run of the fragment of its moof box.
+func (ctx *MuxContext) layoutSourceFragments() (fragments []fragment, err error) {
+	tracks := make(map[uint32]int)
+	for idx, track := range ctx.tracks {
+		tracks[track.Trak.Tkhd.TrackID] = idx
+	}
+	next := make([]uint32, len(ctx.tracks))
+	decodeTimes := make([]uint64, len(ctx.tracks))
+
+	for _, runs := range ctx.SourceFragments {
+		var f fragment
+		for _, run := range runs {
+			trackIdx, found := tracks[run.TrackID]
+			if !found {
+				return nil, fmt.Errorf("traf track id %d does not match any track", run.TrackID)
+			}
+			track := ctx.tracks[trackIdx]
+			if run.FirstSample != next[trackIdx] || int(run.FirstSample+run.SampleCount) > len(track.Samples) {
+				return nil, fmt.Errorf("fragments of track %d are not contiguous", run.TrackID)
+			}
+			start := track.duration(decodeTimes[trackIdx])
+			if len(f.Runs) == 0 || start < f.Start {
+				f.Start = start
+			}
+			f.Runs = append(f.Runs, fragmentRun{
+				Track:       trackIdx,
+				FirstSample: run.FirstSample,
+				SampleCount: run.SampleCount,
+				DecodeTime:  decodeTimes[trackIdx],
+			})
+			for _, sample := range track.Samples[run.FirstSample : run.FirstSample+run.SampleCount] {
+				decodeTimes[trackIdx] += uint64(sample.SampleDuration)
+			}
+			next[trackIdx] += run.SampleCount
+		}
+		if len(f.Runs) > 0 {
+			fragments = append(fragments, f)
+		}
+	}
+	return
+}
+
+// fragmentAt returns the index of the fragment a sample decoded at start goes to, the last
+// one starting at or before it.
+func (ctx *MuxContext) fragmentAt(start time.Duration) int {
+	idx, found := slices.BinarySearchFunc(ctx.fragments, start, func(f fragment, start time.Duration) int {
+		return cmp.Compare(f.Start, start)
+	})
+	if !found {
+		idx--
+	}
+	return max(idx, 0)
+}
+
+// splitTrack adds the samples of a track without fragments of its own (e.g. subtitles) to the
+// fragments they are decoded within, as one run per fragment and sample entry.
+func (ctx *MuxContext) splitTrack(trackIdx int) {
+	track := ctx.tracks[trackIdx]
+	var decodeTime uint64
+	for idx, sample := range track.Samples {
+		runs := &ctx.fragments[ctx.fragmentAt(track.duration(decodeTime))].Runs
+		if len(*runs) == 0 || (*runs)[len(*runs)-1].Track != trackIdx ||
+			sample.SampleDescriptionIndex != track.Samples[idx-1].SampleDescriptionIndex {
+			*runs = append(*runs, fragmentRun{
+				Track:       trackIdx,
+				FirstSample: uint32(idx),
+				DecodeTime:  decodeTime,
+			})
+		}
+		(*runs)[len(*runs)-1].SampleCount++
+		decodeTime += uint64(sample.SampleDuration)
+	}
+}
+
+// clearSampleTable empties the sample table of a track, whose samples are described by the
+// movie fragments instead. The sgpd boxes are kept, as the sbgp boxes of the fragments may
+// refer to them.
+func clearSampleTable(trak *cmaf.TrackBox) (err error) {
+	stbl := &trak.Mdia.Minf.Stbl
+	stbl.Stts.Entries = []mp4.SttsEntry{}
+	stbl.Stts.EntryCount = 0
+	stbl.Stsc.Entries = []mp4.StscEntry{}
+	stbl.Stsc.EntryCount = 0
+	stbl.Stsz.SampleSize = 0
+	stbl.Stsz.SampleCount = 0
+	stbl.Stsz.EntrySize = []uint32{}
+	if _, err = trak.SetChunkOffsets(nil); err != nil {
+		return
+	}
+	for _, boxType := range []mp4.BoxType{mp4.BoxTypeCtts(), mp4.BoxTypeStss(), mp4.BoxTypeSdtp(), mp4.BoxTypeSbgp()} {
+		if _, err = stbl.Node.Remove(boxType); err != nil {
+			return
+		}
+	}
+	stbl.Ctts, stbl.Stss, stbl.Sdtp, stbl.Sbgp = nil, nil, nil, nil
+	return stbl.Node.Caching()
+}
+
+// appendTrackExtends appends a trex box for each of the tracks to the mvex box of the movie.
+func (ctx *MuxContext) appendTrackExtends(mvex *boxtree.BoxNode, tracks []muxTrack) (err error) {
+	for _, track := range tracks {
+		if _, err = appendBox(mvex, &mp4.Trex{
+			TrackID:                       track.Trak.Tkhd.TrackID,
+			DefaultSampleDescriptionIndex: 1,
+		}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Fragmentize keeps a segmented movie fragmented, instead of desegmentizing it: an
+// initialization segment, whose mvex box declares the tracks, followed by the fragments of
+// the input, whose moof and mdat boxes are written again by Finalize from the samples (e.g.
+// once decrypted).
+func (ctx *MuxContext) Fragmentize(options FragmentOptions) (err error) {
+	if ctx.tracks != nil {
+		return errors.New("movie is already desegmentized")
+	}
+	if len(ctx.SourceFragments) == 0 {
+		return errors.New("movie is not fragmented")
+	}
+	if ctx.Header, err = cmaf.InitializeHeader(ctx.Root); err != nil {
+		return
+	}
+
+	// the fragments and any index of the input are written again by Finalize
+	for _, boxType := range []mp4.BoxType{mp4.BoxTypeMoof(), mp4.BoxTypeMdat(), mp4.BoxTypeSidx(), mp4.BoxTypeMfra()} {
+		if _, err = ctx.Root.Remove(boxType); err != nil {
+			return
+		}
+	}
+
+	ctx.setDurations()
+	ctx.tracks = []muxTrack{}
+	for idx := range ctx.Header.Moov.Trak {
+		trak := &ctx.Header.Moov.Trak[idx]
+		if err = clearSampleTable(trak); err != nil {
+			return
+		}
+		ctx.tracks = append(ctx.tracks, muxTrack{
+			Trak:      trak,
+			Samples:   ctx.Samples[trak.Tkhd.TrackID],
+			Timescale: trak.Mdia.Mdhd.Timescale,
+			Groups:    trackSampleGroups(trak, ctx.SampleGroups[trak.Tkhd.TrackID], uint64(len(ctx.Samples[trak.Tkhd.TrackID]))),
+		})
+	}
+
+	{ // moov.mvex
+		if _, err = ctx.Header.Moov.Node.Remove(mp4.BoxTypeMvex()); err != nil {
+			return
+		}
+		var mvex *boxtree.BoxNode
+		if mvex, err = appendBox(ctx.Header.Moov.Node, &mp4.Mvex{}); err != nil {
+			return
+		}
+		mehd := &mp4.Mehd{}
+		mehd.SetVersion(1)
+		mehd.FragmentDurationV1 = ctx.Header.Moov.Mvhd.GetDuration()
+		if _, err = appendBox(mvex, mehd); err != nil {
+			return
+		}
+		if err = ctx.appendTrackExtends(mvex, ctx.tracks); err != nil {
+			return
+		}
+		if err = ctx.Header.Moov.Node.Caching(); err != nil {
+			return
+		}
+	}
+
+	if ftyp := ctx.Header.Ftyp; ftyp != nil && !slices.ContainsFunc(ftyp.CompatibleBrands, func(brand mp4.CompatibleBrandElem) bool {
+		return brand.CompatibleBrand == mp4.BrandISO6()
+	}) {
+		ftyp.CompatibleBrands = append(ftyp.CompatibleBrands, mp4.CompatibleBrandElem{CompatibleBrand: mp4.BrandISO6()})
+	}
+
+	if ctx.fragments, err = ctx.layoutSourceFragments(); err != nil {
+		return
+	}
+	// e.g. when every trun box of the input is empty
+	if len(ctx.fragments) == 0 {
+		return errors.New("movie has no samples to fragment")
+	}
+	ctx.fragmentOptions = options
+	ctx.mediaData = nil
+
+	ctx.Header, err = cmaf.InitializeHeader(ctx.Root)
+	return
+}
+
+// muxFragmentedTrack adds the tracks of other to a fragmentized movie. The fragments of
+// other, if fragmentized too, join the fragment of the movie they start within, while the
+// samples of a desegmentized movie (e.g. subtitles) are split across the fragments.
+func (ctx *MuxContext) muxFragmentedTrack(other *MuxContext) (err error) {
+	if other.tracks == nil {
+		return errors.New("movie is neither desegmentized nor fragmentized")
+	}
+	var mvex []*boxtree.BoxNode
+	if mvex, err = ctx.Root.P("moov.mvex"); err != nil {
+		return
+	}
+
+	first := len(ctx.tracks)
+	if err = ctx.adoptTracks(other); err != nil {
+		return
+	}
+	for idx := first; idx < len(ctx.tracks); idx++ {
+		if err = clearSampleTable(ctx.tracks[idx].Trak); err != nil {
+			return
+		}
+	}
+	if err = ctx.appendTrackExtends(mvex[0], ctx.tracks[first:]); err != nil {
+		return
+	}
+	if err = ctx.Header.Moov.Node.Caching(); err != nil {
+		return
+	}
+
+	if other.fragments != nil {
+		for _, f := range other.fragments {
+			runs := &ctx.fragments[ctx.fragmentAt(f.Start)].Runs
+			for _, run := range f.Runs {
+				run.Track += first
+				*runs = append(*runs, run)
+			}
+		}
+	} else {
+		for idx := first; idx < len(ctx.tracks); idx++ {
+			ctx.splitTrack(idx)
+		}
+	}
+
+	ctx.Header, err = cmaf.InitializeHeader(ctx.Root)
+	return
+}
+
+// runSamples returns the samples of a run, in the order of the mdat box.
+func (ctx *MuxContext) runSamples(run fragmentRun) []Sample {
+	return ctx.tracks[run.Track].Samples[run.FirstSample : run.FirstSample+run.SampleCount]
+}
+
+// fragmentDataSize returns the size of the payload of the mdat box of a fragment.
+func (ctx *MuxContext) fragmentDataSize(f fragment) (size uint64) {
+	for _, run := range f.Runs {
+		for _, sample := range ctx.runSamples(run) {
+			size += uint64(sample.SampleSize)
+		}
+	}
+	return
+}
+
+// newTrackRun describes the samples in a trun box, the version and the flags of which fit
+// the composition time offsets of the samples.
+func newTrackRun(samples []Sample) *mp4.Trun {
+	trun := &mp4.Trun{SampleCount: uint32(len(samples))}
+
+	var flags uint32 = 0x000001 | 0x000100 | 0x000200 | 0x000400
+	var version uint8
+	for _, sample := range samples {
+		if sample.SampleCompositionTimeOffsetV0 != 0 || sample.SampleCompositionTimeOffsetV1 != 0 {
+			flags |= 0x000800
+		}
+		version = max(version, sample.Version)
+	}
+	trun.SetVersion(version)
+	trun.SetFlags(flags)
+
+	for _, sample := range samples {
+		entry := mp4.TrunEntry{
+			SampleDuration: sample.SampleDuration,
+			SampleSize:     sample.SampleSize,
+			SampleFlags:    sample.SampleFlags,
+		}
+		if sample.Version == 1 {
+			entry.SampleCompositionTimeOffsetV1 = sample.SampleCompositionTimeOffsetV1
+		} else if version == 1 {
+			entry.SampleCompositionTimeOffsetV1 = int32(sample.SampleCompositionTimeOffsetV0)
+		} else {
+			entry.SampleCompositionTimeOffsetV0 = sample.SampleCompositionTimeOffsetV0
+		}
+		trun.Entries = append(trun.Entries, entry)
+	}
+	return trun
+}
+
+// fragmentBox builds the moof box of a fragment, whose data offsets point after the header
+// of the mdat box following it. It returns the tree holding the moof box and its size.
+func (ctx *MuxContext) fragmentBox(sequenceNumber uint32, f fragment, headerSize uint64) (root *boxtree.BoxNode, size uint64, err error) {
+	root = &boxtree.BoxNode{Path: mp4.BoxPath{}}
+	var moof *boxtree.BoxNode
+	if moof, err = appendBox(root, &mp4.Moof{}); err != nil {
+		return
+	}
+	if _, err = appendBox(moof, &mp4.Mfhd{SequenceNumber: sequenceNumber}); err != nil {
+		return
+	}
+
+	truns := make([]*mp4.Trun, len(f.Runs))
+	for idx, run := range f.Runs {
+		samples := ctx.runSamples(run)
+
+		var traf *boxtree.BoxNode
+		if traf, err = appendBox(moof, &mp4.Traf{}); err != nil {
+			return
+		}
+		tfhd := &mp4.Tfhd{
+			TrackID:                ctx.tracks[run.Track].Trak.Tkhd.TrackID,
+			SampleDescriptionIndex: max(samples[0].SampleDescriptionIndex, 1),
+		}
+		tfhd.SetFlags(mp4.TfhdDefaultBaseIsMoof | mp4.TfhdSampleDescriptionIndexPresent)
+		if _, err = appendBox(traf, tfhd); err != nil {
+			return
+		}
+		tfdt := &mp4.Tfdt{BaseMediaDecodeTimeV1: run.DecodeTime}
+		tfdt.SetVersion(1)
+		if _, err = appendBox(traf, tfdt); err != nil {
+			return
+		}
+		truns[idx] = newTrackRun(samples)
+		if _, err = appendBox(traf, truns[idx]); err != nil {
+			return
+		}
+		var groups []mp4.IBox
+		if groups, err = fragmentSampleGroups(ctx.tracks[run.Track], uint64(run.FirstSample), run.SampleCount); err != nil {
+			return
+		}
+		for _, box := range groups {
+			if _, err = appendBox(traf, box); err != nil {
+				return
+			}
+		}
+	}
+
+	if size, err = layout(root); err != nil {
+		return
+	}
+	// the data offsets are relative to the moof box, as the runs follow each other in the mdat box
+	offset := size + headerSize
+	for idx, run := range f.Runs {
+		if offset > math.MaxInt32 {
+			return nil, 0, errors.New("fragment is too large")
+		}
+		truns[idx].DataOffset = int32(offset)
+		for _, sample := range ctx.runSamples(run) {
+			offset += uint64(sample.SampleSize)
+		}
+	}
+	return
+}
+
+// streamAccessPoint tells whether a run of samples starts with a stream access point, and
+// its type: 1 unless the samples following the first one until the next sync sample include
+// leading samples, which are presented before it (2 if they are decodable, 3 otherwise).
+func streamAccessPoint(samples []Sample) (startsWithSAP bool, sapType uint32) {
+	if len(samples) == 0 || samples[0].SampleFlags&sampleIsNonSyncSample != 0 {
+		return false, 0
+	}
+	sapType = 1
+	for _, sample := range samples[1:] {
+		if sample.SampleFlags&sampleIsNonSyncSample == 0 {
+			break
+		}
+		flags, err := UnmarshalSampleFlags(sample.SampleFlags)
+		if err != nil {
+			break
+		}
+		switch flags.IsLeading {
+		case 1:
+			return true, 3
+		case 3:
+			sapType = 2
+		}
+	}
+	return true, sapType
+}
+
+// writeSegmentIndex writes a sidx box referencing the fragments, of the given sizes, as
+// subsegments of the reference track.
+func (ctx *MuxContext) writeSegmentIndex(output io.WriteSeeker, sizes []uint64) (err error) {
+	if len(ctx.fragments) > math.MaxUint16 {
+		return errors.New("too many fragments to be indexed")
+	}
+	reference := ctx.referenceTrack()
+	track := ctx.tracks[reference]
+
+	sidx := &mp4.Sidx{
+		ReferenceID:    track.Trak.Tkhd.TrackID,
+		Timescale:      track.Timescale,
+		ReferenceCount: uint16(len(ctx.fragments)),
+	}
+	sidx.SetVersion(1)
+	for idx, f := range ctx.fragments {
+		if sizes[idx] > math.MaxInt32 {
+			return errors.New("fragment is too large to be indexed")
+		}
+		var duration uint64
+		var samples []Sample
+		for _, run := range f.Runs {
+			if run.Track == reference {
+				samples = append(samples, ctx.runSamples(run)...)
+			}
+		}
+		for _, sample := range samples {
+			duration += uint64(sample.SampleDuration)
+		}
+		startsWithSAP, sapType := streamAccessPoint(samples)
+		sidx.References = append(sidx.References, mp4.SidxReference{
+			ReferencedSize:     uint32(sizes[idx]),
+			SubsegmentDuration: uint32(duration),
+			StartsWithSAP:      startsWithSAP,
+			SAPType:            sapType,
+		})
+	}
+
+	root := &boxtree.BoxNode{Path: mp4.BoxPath{}}
+	if _, err = appendBox(root, sidx); err != nil {
+		return
+	}
+	_, err = boxtree.Marshal(output, root)
+	return
+}
+
+// writeRandomAccess writes a mfra box locating the first run of each track in the fragments,
+// which start at the given offsets.
+func (ctx *MuxContext) writeRandomAccess(output io.WriteSeeker, offsets []uint64) (err error) {
+	root := &boxtree.BoxNode{Path: mp4.BoxPath{}}
+	var mfra *boxtree.BoxNode
+	if mfra, err = appendBox(root, &mp4.Mfra{}); err != nil {
+		return
+	}
+	for trackIdx, track := range ctx.tracks {
+		tfra := &mp4.Tfra{
+			TrackID:             track.Trak.Tkhd.TrackID,
+			LengthSizeOfTrafNum: 1,
+		}
+		tfra.SetVersion(1)
+		for idx, f := range ctx.fragments {
+			if runIdx := slices.IndexFunc(f.Runs, func(run fragmentRun) bool { return run.Track == trackIdx }); runIdx >= 0 {
+				tfra.Entries = append(tfra.Entries, mp4.TfraEntry{
+					TimeV1:       f.Runs[runIdx].DecodeTime,
+					MoofOffsetV1: offsets[idx],
+					TrafNumber:   uint32(runIdx + 1),
+					TrunNumber:   1,
+					SampleNumber: 1,
+				})
+			}
+		}
+		tfra.NumberOfEntry = uint32(len(tfra.Entries))
+		if _, err = appendBox(mfra, tfra); err != nil {
+			return
+		}
+	}
+	mfro := &mp4.Mfro{}
+	if _, err = appendBox(mfra, mfro); err != nil {
+		return
+	}
+
+	var size uint64
+	if size, err = layout(root); err != nil {
+		return
+	}
+	mfro.Size = uint32(size)
+	_, err = boxtree.Marshal(output, root)
+	return
+}
+
+// writeFragments writes the initialization segment of a fragmentized movie, then its
+// fragments, streaming the media data of each from the samples.
+func (ctx *MuxContext) writeFragments(output io.WriteSeeker) (err error) {
+	if _, err = boxtree.Marshal(output, ctx.Root); err != nil {
+		return
+	}
+
+	if ctx.fragmentOptions.Sidx {
+		// the moof boxes are built twice, as the sidx box preceding them needs their sizes
+		sizes := make([]uint64, len(ctx.fragments))
+		for idx, f := range ctx.fragments {
+			dataSize := ctx.fragmentDataSize(f)
+			headerSize := mediaDataHeaderSize(dataSize)
+			var moofSize uint64
+			if _, moofSize, err = ctx.fragmentBox(uint32(idx+1), f, headerSize); err != nil {
+				return
+			}
+			sizes[idx] = moofSize + headerSize + dataSize
+		}
+		if err = ctx.writeSegmentIndex(output, sizes); err != nil {
+			return
+		}
+	}
+
+	offsets := make([]uint64, len(ctx.fragments))
+	for idx, f := range ctx.fragments {
+		var offset int64
+		if offset, err = output.Seek(0, io.SeekCurrent); err != nil {
+			return
+		}
+		offsets[idx] = uint64(offset)
+
+		dataSize := ctx.fragmentDataSize(f)
+		headerSize := mediaDataHeaderSize(dataSize)
+		var moof *boxtree.BoxNode
+		if moof, _, err = ctx.fragmentBox(uint32(idx+1), f, headerSize); err != nil {
+			return
+		}
+		if _, err = boxtree.Marshal(output, moof); err != nil {
+			return
+		}
+		if _, err = mp4.WriteBoxInfo(output, &mp4.BoxInfo{
+			Type:       mp4.BoxTypeMdat(),
+			HeaderSize: headerSize,
+			Size:       headerSize + dataSize,
+		}); err != nil {
+			return
+		}
+		var samples []Sample
+		for _, run := range f.Runs {
+			samples = append(samples, ctx.runSamples(run)...)
+		}
+		if err = writePayloads(output, samples); err != nil {
+			return
+		}
+	}
+
+	if ctx.fragmentOptions.Mfra {
+		return ctx.writeRandomAccess(output, offsets)
+	}
+	return
+}
@@ -0,0 +1,168 @@
+package mp4utils
+
+import (
+	"bytes"
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/cmaf"
+	"downloader/pkg/utils"
+	"testing"
+	"time"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHandlerTrack(trackID uint32, handlerType [4]byte) *cmaf.TrackBox {
+	trak := &cmaf.TrackBox{Tkhd: &mp4.Tkhd{TrackID: trackID}}
+	trak.Mdia.Hdlr = &mp4.Hdlr{HandlerType: handlerType}
+	return trak
+}
+
+func TestLayoutSourceFragments(t *testing.T) {
+	var video []Sample
+	for range 8 {
+		video = append(video, Sample{SampleDuration: 250, SampleDescriptionIndex: 1})
+	}
+	var audio []Sample
+	for range 5 {
+		audio = append(audio, Sample{SampleDuration: 19200, SampleDescriptionIndex: 1})
+	}
+
+	ctx := NewMuxContext()
+	ctx.tracks = []muxTrack{
+		{Trak: newHandlerTrack(1, cmaf.HandlerTypeVideo), Samples: video, Timescale: 1000},
+	}
+	ctx.SourceFragments.begin()
+	ctx.SourceFragments.add(1, 0, 6)
+	ctx.SourceFragments.begin()
+	ctx.SourceFragments.add(1, 6, 2)
+
+	fragments, err := ctx.layoutSourceFragments()
+	require.NoError(t, err)
+	assert.Equal(t, []fragment{
+		{Start: 0, Runs: []fragmentRun{{0, 0, 6, 0}}},
+		{Start: 1500 * time.Millisecond, Runs: []fragmentRun{{0, 6, 2, 1500}}},
+	}, fragments)
+
+	t.Run("split", func(t *testing.T) {
+		ctx.fragments = fragments
+		ctx.tracks = append(ctx.tracks, muxTrack{Trak: newHandlerTrack(2, cmaf.HandlerTypeSound), Samples: audio, Timescale: 48000})
+		// the sample entry changes at 0.8 s
+		ctx.tracks[1].Samples[2].SampleDescriptionIndex = 2
+		ctx.tracks[1].Samples[3].SampleDescriptionIndex = 2
+		ctx.splitTrack(1)
+		assert.Equal(t, []fragmentRun{{0, 0, 6, 0}, {1, 0, 2, 0}, {1, 2, 2, 38400}}, ctx.fragments[0].Runs)
+		assert.Equal(t, []fragmentRun{{0, 6, 2, 1500}, {1, 4, 1, 76800}}, ctx.fragments[1].Runs)
+	})
+
+	t.Run("not contiguous", func(t *testing.T) {
+		ctx.SourceFragments = nil
+		ctx.SourceFragments.begin()
+		ctx.SourceFragments.add(1, 2, 6)
+		_, err := ctx.layoutSourceFragments()
+		assert.Error(t, err)
+	})
+}
+
+func TestStreamAccessPoint(t *testing.T) {
+	leading := func(isLeading uint32) Sample {
+		return Sample{SampleFlags: isLeading<<26 | sampleIsNonSyncSample}
+	}
+	for _, tc := range []struct {
+		name          string
+		samples       []Sample
+		startsWithSAP bool
+		sapType       uint32
+	}{
+		{"sync", []Sample{{}, leading(2)}, true, 1},
+		{"decodable leading", []Sample{{}, leading(3)}, true, 2},
+		{"leading", []Sample{{}, leading(3), leading(1)}, true, 3},
+		{"next sync", []Sample{{}, {}, leading(1)}, true, 1},
+		{"non-sync", []Sample{leading(2)}, false, 0},
+		{"empty", nil, false, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			startsWithSAP, sapType := streamAccessPoint(tc.samples)
+			assert.Equal(t, tc.startsWithSAP, startsWithSAP)
+			assert.Equal(t, tc.sapType, sapType)
+		})
+	}
+}
+
+func TestNewTrackRun(t *testing.T) {
+	trun := newTrackRun([]Sample{
+		{SampleSize: 10, SampleCompositionTimeOffsetV0: 512},
+		{SampleSize: 20, SampleCompositionTimeOffsetV1: -512, Version: 1},
+	})
+	assert.Equal(t, uint8(1), trun.GetVersion())
+	assert.Equal(t, uint32(0x000f01), trun.GetFlags())
+	assert.Equal(t, int32(512), trun.Entries[0].SampleCompositionTimeOffsetV1)
+	assert.Equal(t, int32(-512), trun.Entries[1].SampleCompositionTimeOffsetV1)
+
+	trun = newTrackRun([]Sample{{SampleSize: 10}})
+	assert.Equal(t, uint8(0), trun.GetVersion())
+	assert.Equal(t, uint32(0x000701), trun.GetFlags())
+}
+
+// marshal writes the boxes of a tree, each box of which is followed by its children, if any.
+func marshal(t *testing.T, boxes ...any) *bytes.Reader {
+	root := &boxtree.BoxNode{Path: mp4.BoxPath{}}
+	var build func(parent *boxtree.BoxNode, boxes []any)
+	build = func(parent *boxtree.BoxNode, boxes []any) {
+		var node *boxtree.BoxNode
+		for _, box := range boxes {
+			switch box := box.(type) {
+			case mp4.IBox:
+				var err error
+				node, err = appendBox(parent, box)
+				require.NoError(t, err)
+			case []any:
+				build(node, box)
+			}
+		}
+	}
+	build(root, boxes)
+	buf := utils.NewBufferWriter()
+	_, err := boxtree.Marshal(buf, root)
+	require.NoError(t, err)
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestFragmentizeEmptyFragments(t *testing.T) {
+	ctx := NewMuxContext()
+	require.NoError(t, ctx.Initialize(marshal(t,
+		&mp4.Ftyp{MajorBrand: [4]byte{'i', 's', 'o', '6'}},
+		&mp4.Moov{}, []any{
+			&mp4.Mvhd{Timescale: 1000, NextTrackID: 2},
+			&mp4.Trak{}, []any{
+				&mp4.Tkhd{TrackID: 1},
+				&mp4.Mdia{}, []any{
+					&mp4.Mdhd{Timescale: 44100},
+					&mp4.Hdlr{HandlerType: cmaf.HandlerTypeSound},
+					&mp4.Minf{}, []any{
+						&mp4.Smhd{},
+						&mp4.Dinf{}, []any{&mp4.Dref{}},
+						&mp4.Stbl{}, []any{&mp4.Stsd{}, &mp4.Stts{}, &mp4.Stsc{}, &mp4.Stsz{}, &mp4.Stco{}},
+					},
+				},
+			},
+			&mp4.Mvex{}, []any{&mp4.Trex{TrackID: 1, DefaultSampleDescriptionIndex: 1}},
+		},
+	)))
+
+	// a fragment whose trun box has no sample
+	tfhd := &mp4.Tfhd{TrackID: 1}
+	tfhd.SetFlags(0x020000)
+	_, err := ctx.AddSegment(marshal(t,
+		&mp4.Moof{}, []any{
+			&mp4.Mfhd{SequenceNumber: 1},
+			&mp4.Traf{}, []any{tfhd, &mp4.Tfdt{}, &mp4.Trun{}},
+		},
+		&mp4.Mdat{},
+	))
+	require.NoError(t, err)
+	require.Len(t, ctx.SourceFragments, 1)
+
+	assert.EqualError(t, ctx.Fragmentize(FragmentOptions{}), "movie has no samples to fragment")
+}
@@ -0,0 +1,123 @@
+package mp4utils
+
+import (
+	"downloader/internal/media/m3u8/hlsutils/codec"
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/cmaf"
+	"downloader/internal/media/mp4/metadata"
+	"errors"
+	"math"
+	"slices"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+// soundTrack returns the first audio track of the movie, along with the header of the movie.
+func (ctx *MuxContext) soundTrack() (trak *cmaf.TrackBox, header *cmaf.Header, err error) {
+	if header, err = cmaf.InitializeHeader(ctx.Root); err != nil {
+		return
+	}
+	for idx := range header.Moov.Trak {
+		trak = &header.Moov.Trak[idx]
+		if trak.Mdia.Hdlr != nil && trak.Mdia.Hdlr.HandlerType == cmaf.HandlerTypeSound && len(trak.Mdia.Minf.Stbl.Stsd.Entries) > 0 {
+			return trak, header, nil
+		}
+	}
+	return nil, nil, errors.New("no audio track")
+}
+
+// Gapless derives the priming and remainder samples of the audio track from the stream
+// itself, which tells them exactly in two cases only: the edit list written by the encoder,
+// giving the priming samples and the valid ones (once in the timescale of the media), the
+// rest of the last frame being the remainder; and ALAC, which is neither primed nor padded.
+// Anything else would be an estimate (e.g. the 2112 samples AAC encoders usually prime with,
+// a duration rounded to the millisecond, or an edit list of rounded or unknown duration,
+// while a roll sample group only tells how many frames to decode ahead), for which no
+// gapless playback info is given (nil), so that neither iTunSMPB nor an edit list is written.
+func (ctx *MuxContext) Gapless() (gapless *metadata.Gapless, err error) {
+	var trak *cmaf.TrackBox
+	var header *cmaf.Header
+	if trak, header, err = ctx.soundTrack(); err != nil {
+		return
+	}
+	movieTimescale := uint64(header.Moov.Mvhd.Timescale)
+	samples := ctx.Samples[trak.Tkhd.TrackID]
+	if len(samples) == 0 {
+		return nil, errors.New("audio track without samples")
+	}
+	var total uint64
+	for _, sample := range samples {
+		total += uint64(sample.SampleDuration)
+	}
+
+	gapless = &metadata.Gapless{}
+	if elst := trak.Edts.Elst; elst != nil && len(elst.Entries) == 1 && elst.GetMediaTime(0) > 0 {
+		valid := elst.GetSegmentDuration(0) * uint64(trak.Mdia.Mdhd.Timescale)
+		if movieTimescale == 0 || valid == 0 || valid%movieTimescale != 0 {
+			return nil, nil
+		}
+		gapless.Priming = uint32(elst.GetMediaTime(0))
+		gapless.Samples = valid / movieTimescale
+	} else if SampleEntryType(trak.Mdia.Minf.Stbl.Stsd.Entries[0].Node) == mp4.BoxType(codec.ALACIndicator) {
+		gapless.Samples = total
+	} else {
+		return nil, nil
+	}
+
+	if uint64(gapless.Priming)+gapless.Samples > total {
+		return nil, errors.New("the edit list exceeds the duration of the audio track")
+	}
+	gapless.Remainder = uint32(total - uint64(gapless.Priming) - gapless.Samples)
+	return
+}
+
+// SetEditList makes the audio track skip its priming and remainder samples, with an edit
+// list replacing any former one. The durations of the track and of the movie become that of
+// the edit list.
+func (ctx *MuxContext) SetEditList(gapless *metadata.Gapless) (err error) {
+	var trak *cmaf.TrackBox
+	var header *cmaf.Header
+	if trak, header, err = ctx.soundTrack(); err != nil {
+		return
+	}
+	if trak.Mdia.Mdhd.Timescale == 0 {
+		return errors.New("audio track without timescale")
+	}
+
+	duration := gapless.Samples * uint64(header.Moov.Mvhd.Timescale) / uint64(trak.Mdia.Mdhd.Timescale)
+	elst := &mp4.Elst{EntryCount: 1}
+	entry := mp4.ElstEntry{MediaRateInteger: 1}
+	if duration > math.MaxUint32 {
+		elst.SetVersion(1)
+		entry.SegmentDurationV1 = duration
+		entry.MediaTimeV1 = int64(gapless.Priming)
+	} else {
+		entry.SegmentDurationV0 = uint32(duration)
+		entry.MediaTimeV0 = int32(gapless.Priming)
+	}
+	elst.Entries = []mp4.ElstEntry{entry}
+
+	if _, err = trak.Node.Remove(mp4.BoxTypeEdts()); err != nil {
+		return
+	}
+	// edts follows tkhd
+	idx := slices.IndexFunc(trak.Node.Children, func(node *boxtree.BoxNode) bool {
+		return node.Info.Type == mp4.BoxTypeTkhd()
+	})
+	if err = trak.Node.Insert(idx+1, mp4.BoxTypeEdts(), &mp4.Edts{}); err != nil {
+		return
+	}
+	if _, err = appendBox(trak.Node.Children[idx+1], elst); err != nil {
+		return
+	}
+
+	setTrackHeaderDuration(trak.Tkhd, duration)
+	movieDuration := duration
+	for _, other := range header.Moov.Trak {
+		if other.Tkhd != trak.Tkhd {
+			movieDuration = max(movieDuration, other.Tkhd.GetDuration())
+		}
+	}
+	setMovieHeaderDuration(header.Moov.Mvhd, movieDuration)
+	return
+}
@@ -0,0 +1,79 @@
+package mp4utils
+
+import (
+	"downloader/internal/media/m3u8/hlsutils/codec"
+	"downloader/internal/media/mp4/cmaf"
+	"downloader/internal/media/mp4/metadata"
+	"testing"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGapless(t *testing.T) {
+	mp4a := mp4.BoxTypeMp4a()
+	for _, tt := range []struct {
+		name string
+		// dataFormat is that of an encrypted (enca) entry, as the tracks of the catalog are
+		dataFormat mp4.BoxType
+		// segmentDuration is that of the edit list in the timescale of the movie (1000), none if 0
+		segmentDuration uint32
+		gapless         *metadata.Gapless
+		err             string
+	}{
+		// 100ms are exactly 4410 samples at 44100Hz
+		{name: "edit list", dataFormat: mp4a, segmentDuration: 100, gapless: &metadata.Gapless{Priming: 2112, Samples: 4410, Remainder: 3718}},
+		// 101ms are 4454.1 samples, rounded by the encoder one way or the other
+		{name: "rounded edit list", dataFormat: mp4a, segmentDuration: 101},
+		{name: "edit list beyond the track", dataFormat: mp4a, segmentDuration: 200, err: "the edit list exceeds the duration of the audio track"},
+		// the priming of AAC would only be an estimate
+		{name: "aac", dataFormat: mp4a},
+		{name: "alac", dataFormat: mp4.BoxType(codec.ALACIndicator), gapless: &metadata.Gapless{Samples: 10240}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			trak := []any{&mp4.Tkhd{TrackID: 1}}
+			if tt.segmentDuration != 0 {
+				elst := &mp4.Elst{EntryCount: 1, Entries: []mp4.ElstEntry{{SegmentDurationV0: tt.segmentDuration, MediaTimeV0: 2112, MediaRateInteger: 1}}}
+				trak = append(trak, &mp4.Edts{}, []any{elst})
+			}
+			trak = append(trak, &mp4.Mdia{}, []any{
+				&mp4.Mdhd{Timescale: 44100},
+				&mp4.Hdlr{HandlerType: cmaf.HandlerTypeSound},
+				&mp4.Minf{}, []any{
+					&mp4.Smhd{},
+					&mp4.Dinf{}, []any{&mp4.Dref{}},
+					&mp4.Stbl{}, []any{
+						&mp4.Stsd{EntryCount: 1}, []any{
+							&mp4.AudioSampleEntry{
+								SampleEntry:  mp4.SampleEntry{AnyTypeBox: mp4.AnyTypeBox{Type: mp4.BoxTypeEnca()}, DataReferenceIndex: 1},
+								ChannelCount: 2, SampleSize: 16, SampleRate: 44100 << 16,
+							}, []any{&mp4.Sinf{}, []any{&mp4.Frma{DataFormat: tt.dataFormat}}},
+						},
+						&mp4.Stts{}, &mp4.Stsc{}, &mp4.Stsz{}, &mp4.Stco{},
+					},
+				},
+			})
+			ctx := NewMuxContext()
+			require.NoError(t, ctx.Initialize(marshal(t,
+				&mp4.Ftyp{MajorBrand: [4]byte{'i', 's', 'o', '6'}},
+				&mp4.Moov{}, []any{
+					&mp4.Mvhd{Timescale: 1000, NextTrackID: 2},
+					&mp4.Trak{}, trak,
+					&mp4.Mvex{}, []any{&mp4.Trex{TrackID: 1, DefaultSampleDescriptionIndex: 1}},
+				},
+			)))
+			for range 10 {
+				ctx.Samples[1] = append(ctx.Samples[1], Sample{SampleDuration: 1024})
+			}
+
+			gapless, err := ctx.Gapless()
+			if len(tt.err) != 0 {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.gapless, gapless)
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package mp4utils
+
+import (
+	"bytes"
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/cmaf"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+// fragmentGroupIndexBase is added to the indexes of the group descriptions of a fragment,
+// as opposed to those of the track (ISO/IEC 14496-12 8.9.4)
+const fragmentGroupIndexBase = 0x10000
+
+// unmappedSample marks the samples which a fragment does not map to any group description,
+// which fall back to the default description of the track, if any
+const unmappedSample = math.MaxUint32
+
+func groupingTypeOf(groupingType [4]byte) uint32 {
+	return binary.BigEndian.Uint32(groupingType[:])
+}
+
+// groupDescriptions holds the entries of a sgpd box as raw bytes, whatever their grouping
+// type, so that they can be compared and merged.
+type groupDescriptions struct {
+	GroupingType                  uint32
+	DefaultSampleDescriptionIndex uint32
+	Entries                       [][]byte
+}
+
+// legacyEntryLength returns the length of a sample group entry of a sgpd box which does not
+// tell it (version 0 or 2), for the grouping types known to have a fixed length.
+func legacyEntryLength(groupingType uint32, data []byte) (uint32, error) {
+	switch groupingType {
+	case groupingTypeOf([4]byte{'r', 'o', 'l', 'l'}), groupingTypeOf([4]byte{'p', 'r', 'o', 'l'}):
+		return 2, nil
+	case groupingTypeOf([4]byte{'r', 'a', 'p', ' '}), groupingTypeOf([4]byte{'t', 'e', 'l', 'e'}):
+		return 1, nil
+	case groupingTypeOf([4]byte{'s', 'e', 'i', 'g'}):
+		// a constant IV follows the KID when the samples are protected without per-sample IV
+		if len(data) > 20 && data[2] == 1 && data[3] == 0 {
+			return 21 + uint32(data[20]), nil
+		}
+		return 20, nil
+	}
+	return 0, errors.New("unsupported sample group description")
+}
+
+func readGroupDescriptions(sgpd *mp4.Sgpd) (d *groupDescriptions, err error) {
+	var buf bytes.Buffer
+	if _, err = mp4.Marshal(&buf, sgpd, mp4.Context{}); err != nil {
+		return
+	}
+	data := buf.Bytes()
+	var read = func() (value uint32, err error) {
+		if len(data) < 4 {
+			return 0, errors.New("invalid sgpd box")
+		}
+		value = binary.BigEndian.Uint32(data)
+		data = data[4:]
+		return
+	}
+
+	d = &groupDescriptions{GroupingType: groupingTypeOf(sgpd.GroupingType)}
+	version := sgpd.GetVersion()
+	data = data[min(len(data), 8):] // version, flags and grouping type
+	var defaultLength, count uint32
+	if version == 1 {
+		if defaultLength, err = read(); err != nil {
+			return
+		}
+	}
+	if version >= 2 {
+		if d.DefaultSampleDescriptionIndex, err = read(); err != nil {
+			return
+		}
+	}
+	if count, err = read(); err != nil {
+		return
+	}
+	if version != 1 && count > 0 {
+		if _, err = legacyEntryLength(d.GroupingType, data); err != nil {
+			// the entries of other grouping types are taken as they are, sharing their length
+			if len(data) == 0 || uint32(len(data))%count != 0 {
+				return
+			}
+			defaultLength, err = uint32(len(data))/count, nil
+		}
+	}
+	for range count {
+		length := defaultLength
+		if version == 1 && defaultLength == 0 {
+			if length, err = read(); err != nil {
+				return
+			}
+		} else if version != 1 && defaultLength == 0 {
+			if length, err = legacyEntryLength(d.GroupingType, data); err != nil {
+				return
+			}
+		}
+		if uint32(len(data)) < length {
+			return nil, errors.New("invalid sgpd box")
+		}
+		d.Entries = append(d.Entries, bytes.Clone(data[:length]))
+		data = data[length:]
+	}
+	return
+}
+
+// box builds a sgpd box (version 1) holding the descriptions.
+func (d *groupDescriptions) box() (sgpd *mp4.Sgpd, err error) {
+	var defaultLength uint32
+	if len(d.Entries) > 0 && !slices.ContainsFunc(d.Entries, func(entry []byte) bool {
+		return len(entry) != len(d.Entries[0])
+	}) {
+		defaultLength = uint32(len(d.Entries[0]))
+	}
+
+	payload := []byte{1, 0, 0, 0}
+	payload = binary.BigEndian.AppendUint32(payload, d.GroupingType)
+	payload = binary.BigEndian.AppendUint32(payload, defaultLength)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len(d.Entries)))
+	for _, entry := range d.Entries {
+		if defaultLength == 0 {
+			payload = binary.BigEndian.AppendUint32(payload, uint32(len(entry)))
+		}
+		payload = append(payload, entry...)
+	}
+
+	sgpd = &mp4.Sgpd{}
+	_, err = mp4.Unmarshal(bytes.NewReader(payload), uint64(len(payload)), sgpd, mp4.Context{})
+	return
+}
+
+// sampleGroup is a sample grouping of a track, merged across its fragments: the descriptions
+// of the fragments and the run-length table mapping the samples onto them.
+type sampleGroup struct {
+	GroupingType          uint32
+	GroupingTypeParameter uint32
+	Version               uint8
+	// Descriptions are indexed from fragmentGroupIndexBase + 1, following those of the track
+	Descriptions [][]byte
+	Entries      []mp4.SbgpEntry
+	SampleCount  uint64
+}
+
+func (group *sampleGroup) appendRun(count uint32, index uint32) {
+	if count == 0 {
+		return
+	}
+	if n := len(group.Entries); n > 0 && group.Entries[n-1].GroupDescriptionIndex == index {
+		group.Entries[n-1].SampleCount += count
+	} else {
+		group.Entries = append(group.Entries, mp4.SbgpEntry{SampleCount: count, GroupDescriptionIndex: index})
+	}
+	group.SampleCount += uint64(count)
+}
+
+// describe returns the index of a description of a fragment among those of the group.
+func (group *sampleGroup) describe(description []byte) uint32 {
+	idx := slices.IndexFunc(group.Descriptions, func(d []byte) bool {
+		return bytes.Equal(d, description)
+	})
+	if idx < 0 {
+		idx = len(group.Descriptions)
+		group.Descriptions = append(group.Descriptions, description)
+	}
+	return fragmentGroupIndexBase + uint32(idx) + 1
+}
+
+// SampleGroups gathers the sample groups (sbgp and sgpd boxes) of the fragments of each track,
+// by track ID.
+type SampleGroups map[uint32][]*sampleGroup
+
+func (groups SampleGroups) group(trackID uint32, sbgp *mp4.Sbgp, offset uint64) (group *sampleGroup) {
+	idx := slices.IndexFunc(groups[trackID], func(group *sampleGroup) bool {
+		return group.GroupingType == sbgp.GroupingType && group.GroupingTypeParameter == sbgp.GroupingTypeParameter
+	})
+	if idx >= 0 {
+		group = groups[trackID][idx]
+	} else {
+		group = &sampleGroup{
+			GroupingType:          sbgp.GroupingType,
+			GroupingTypeParameter: sbgp.GroupingTypeParameter,
+			Version:               sbgp.GetVersion(),
+		}
+		groups[trackID] = append(groups[trackID], group)
+	}
+	group.appendRun(uint32(offset-min(group.SampleCount, offset)), unmappedSample)
+	return
+}
+
+// Add appends the sample groups of a track fragment of sampleCount samples, following offset
+// samples of the track. The grouping types to exclude are dropped, e.g. seig once decrypted,
+// as well as those whose descriptions cannot be read.
+func (groups SampleGroups) Add(trackID uint32, traf cmaf.TrackFragmentBox, offset uint64, sampleCount uint32, exclude ...[4]byte) {
+	descriptions := make(map[uint32]*groupDescriptions)
+	for _, sgpd := range traf.Sgpd {
+		if d, err := readGroupDescriptions(sgpd); err == nil {
+			descriptions[d.GroupingType] = d
+		}
+	}
+
+	for _, sbgp := range traf.Sbgp {
+		if slices.ContainsFunc(exclude, func(groupingType [4]byte) bool {
+			return groupingTypeOf(groupingType) == sbgp.GroupingType
+		}) {
+			continue
+		}
+		if slices.ContainsFunc(sbgp.Entries, func(entry mp4.SbgpEntry) bool {
+			return entry.GroupDescriptionIndex > fragmentGroupIndexBase
+		}) && descriptions[sbgp.GroupingType] == nil {
+			continue
+		}
+
+		group := groups.group(trackID, sbgp, offset)
+		var mapped uint32
+		for _, entry := range sbgp.Entries {
+			index := entry.GroupDescriptionIndex
+			if index > fragmentGroupIndexBase {
+				d := descriptions[sbgp.GroupingType]
+				if int(index-fragmentGroupIndexBase) > len(d.Entries) {
+					index = unmappedSample
+				} else {
+					index = group.describe(d.Entries[index-fragmentGroupIndexBase-1])
+				}
+			}
+			count := min(entry.SampleCount, sampleCount-mapped)
+			group.appendRun(count, index)
+			mapped += count
+		}
+		group.appendRun(sampleCount-mapped, unmappedSample)
+	}
+
+	// the other groups of the track do not map the samples of the fragment
+	end := offset + uint64(sampleCount)
+	for _, group := range groups[trackID] {
+		group.appendRun(uint32(end-min(group.SampleCount, end)), unmappedSample)
+	}
+}
+
+// trackSampleGroups adds to the sample groups of the fragments of a track a group mapping
+// all its samples onto the first description of each sgpd box of its sample table no
+// fragment refers to, as the track alone describes its samples then.
+func trackSampleGroups(trak *cmaf.TrackBox, groups []*sampleGroup, sampleCount uint64) []*sampleGroup {
+	groups = slices.Clip(groups)
+	for _, sgpd := range trak.Mdia.Minf.Stbl.Sgpd {
+		groupingType := groupingTypeOf(sgpd.GroupingType)
+		if sgpd.EntryCount == 0 || slices.ContainsFunc(groups, func(group *sampleGroup) bool {
+			return group.GroupingType == groupingType
+		}) {
+			continue
+		}
+		group := &sampleGroup{GroupingType: groupingType}
+		group.appendRun(uint32(sampleCount), 1)
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// writeSampleGroups replaces the sample groups of the sample table of a track by the groups
+// gathered from its fragments, the descriptions of the fragments following those of the
+// track in a single sgpd box per grouping type. A sgpd box of the track which cannot be read
+// is kept as it is, along with the groups referring to it alone.
+func writeSampleGroups(trak *cmaf.TrackBox, groups []*sampleGroup, sampleCount uint64) (err error) {
+	stbl := trak.Mdia.Minf.Stbl.Node
+	if _, err = stbl.Remove(mp4.BoxTypeSbgp()); err != nil {
+		return
+	}
+
+	for _, group := range groups {
+		// the descriptions of the track, if any
+		descriptions := &groupDescriptions{GroupingType: group.GroupingType}
+		idx := slices.IndexFunc(stbl.Children, func(node *boxtree.BoxNode) bool {
+			sgpd, ok := node.Box.(*mp4.Sgpd)
+			return ok && groupingTypeOf(sgpd.GroupingType) == group.GroupingType
+		})
+		unread := false
+		if idx >= 0 {
+			sgpd := stbl.Children[idx].Box.(*mp4.Sgpd)
+			if descriptions, err = readGroupDescriptions(sgpd); err != nil {
+				if len(group.Descriptions) != 0 {
+					// the descriptions of the fragments cannot follow those of the track
+					err = nil
+					continue
+				}
+				descriptions = &groupDescriptions{
+					GroupingType:                  group.GroupingType,
+					DefaultSampleDescriptionIndex: sgpd.DefaultSampleDescriptionIndex,
+				}
+				unread, err = true, nil
+			}
+		}
+		trackDescriptions := uint32(len(descriptions.Entries))
+		descriptions.Entries = append(descriptions.Entries, group.Descriptions...)
+
+		group.appendRun(uint32(sampleCount-min(group.SampleCount, sampleCount)), unmappedSample)
+		sbgp := &mp4.Sbgp{GroupingType: group.GroupingType, GroupingTypeParameter: group.GroupingTypeParameter}
+		sbgp.SetVersion(group.Version)
+		for _, entry := range group.Entries {
+			switch {
+			case entry.GroupDescriptionIndex == unmappedSample:
+				entry.GroupDescriptionIndex = descriptions.DefaultSampleDescriptionIndex
+			case entry.GroupDescriptionIndex > fragmentGroupIndexBase:
+				entry.GroupDescriptionIndex += trackDescriptions - fragmentGroupIndexBase
+			}
+			if n := len(sbgp.Entries); n > 0 && sbgp.Entries[n-1].GroupDescriptionIndex == entry.GroupDescriptionIndex {
+				sbgp.Entries[n-1].SampleCount += entry.SampleCount
+				continue
+			}
+			sbgp.Entries = append(sbgp.Entries, entry)
+		}
+		sbgp.EntryCount = uint32(len(sbgp.Entries))
+
+		if !unread {
+			var sgpd *mp4.Sgpd
+			if sgpd, err = descriptions.box(); err != nil {
+				return
+			}
+			if idx >= 0 {
+				stbl.Children = slices.Delete(stbl.Children, idx, idx+1)
+			}
+			if err = stbl.Append(mp4.BoxTypeSgpd(), sgpd); err != nil {
+				return
+			}
+		}
+		if err = stbl.Append(mp4.BoxTypeSbgp(), sbgp); err != nil {
+			return
+		}
+	}
+	return stbl.Caching()
+}
+
+// trackDefaultDescription returns the index of the description of the track which applies to
+// the samples no sbgp box maps, if any.
+func trackDefaultDescription(trak *cmaf.TrackBox, groupingType uint32) uint32 {
+	for _, sgpd := range trak.Mdia.Minf.Stbl.Sgpd {
+		if groupingTypeOf(sgpd.GroupingType) == groupingType {
+			return sgpd.DefaultSampleDescriptionIndex
+		}
+	}
+	return 0
+}
+
+// fragmentSampleGroups returns the sgpd and sbgp boxes of a track fragment holding count
+// samples of the track following first samples. The sbgp boxes refer to the descriptions of
+// the track, kept by its sample table, and to those of the fragment, held by its own sgpd
+// boxes.
+func fragmentSampleGroups(track muxTrack, first uint64, count uint32) (boxes []mp4.IBox, err error) {
+	end := first + uint64(count)
+	for _, group := range track.Groups {
+		var entries []mp4.SbgpEntry
+		var position uint64
+		for _, entry := range group.Entries {
+			if start, stop := max(position, first), min(position+uint64(entry.SampleCount), end); start < stop {
+				entries = append(entries, mp4.SbgpEntry{SampleCount: uint32(stop - start), GroupDescriptionIndex: entry.GroupDescriptionIndex})
+			}
+			position += uint64(entry.SampleCount)
+		}
+		if !slices.ContainsFunc(entries, func(entry mp4.SbgpEntry) bool {
+			return entry.GroupDescriptionIndex != unmappedSample
+		}) {
+			// the default description of the track, if any, applies to the samples
+			continue
+		}
+
+		descriptions := &groupDescriptions{GroupingType: group.GroupingType}
+		indexes := make(map[uint32]uint32)
+		sbgp := &mp4.Sbgp{GroupingType: group.GroupingType, GroupingTypeParameter: group.GroupingTypeParameter}
+		sbgp.SetVersion(group.Version)
+		for _, entry := range entries {
+			switch {
+			case entry.GroupDescriptionIndex == unmappedSample:
+				entry.GroupDescriptionIndex = trackDefaultDescription(track.Trak, group.GroupingType)
+			case entry.GroupDescriptionIndex > fragmentGroupIndexBase:
+				index, found := indexes[entry.GroupDescriptionIndex]
+				if !found {
+					descriptions.Entries = append(descriptions.Entries, group.Descriptions[entry.GroupDescriptionIndex-fragmentGroupIndexBase-1])
+					index = fragmentGroupIndexBase + uint32(len(descriptions.Entries))
+					indexes[entry.GroupDescriptionIndex] = index
+				}
+				entry.GroupDescriptionIndex = index
+			}
+			if n := len(sbgp.Entries); n > 0 && sbgp.Entries[n-1].GroupDescriptionIndex == entry.GroupDescriptionIndex {
+				sbgp.Entries[n-1].SampleCount += entry.SampleCount
+				continue
+			}
+			sbgp.Entries = append(sbgp.Entries, entry)
+		}
+		sbgp.EntryCount = uint32(len(sbgp.Entries))
+
+		if len(descriptions.Entries) > 0 {
+			var sgpd *mp4.Sgpd
+			if sgpd, err = descriptions.box(); err != nil {
+				return
+			}
+			boxes = append(boxes, sgpd)
+		}
+		boxes = append(boxes, sbgp)
+	}
+	return
+}
@@ -0,0 +1,135 @@
+package mp4utils
+
+import (
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/cmaf"
+	"testing"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var groupingTypeRoll = [4]byte{'r', 'o', 'l', 'l'}
+
+func newRollDescriptions(distances ...int16) *mp4.Sgpd {
+	sgpd := &mp4.Sgpd{
+		GroupingType:  groupingTypeRoll,
+		DefaultLength: 2,
+		EntryCount:    uint32(len(distances)),
+		RollDistances: distances,
+	}
+	sgpd.SetVersion(1)
+	return sgpd
+}
+
+func newSampleToGroup(groupingType [4]byte, entries ...mp4.SbgpEntry) *mp4.Sbgp {
+	return &mp4.Sbgp{
+		GroupingType: groupingTypeOf(groupingType),
+		EntryCount:   uint32(len(entries)),
+		Entries:      entries,
+	}
+}
+
+func TestSampleGroups(t *testing.T) {
+	groups := make(SampleGroups)
+	// the first fragment refers to the description of the track and to one of its own
+	groups.Add(1, cmaf.TrackFragmentBox{
+		Sgpd: []*mp4.Sgpd{newRollDescriptions(-2)},
+		Sbgp: []*mp4.Sbgp{newSampleToGroup(groupingTypeRoll, mp4.SbgpEntry{SampleCount: 2, GroupDescriptionIndex: 1}, mp4.SbgpEntry{SampleCount: 2, GroupDescriptionIndex: 0x10001})},
+	}, 0, 4)
+	// the second fragment has no sample group
+	groups.Add(1, cmaf.TrackFragmentBox{}, 4, 3)
+	// the third fragment describes the same roll distance again, along with a new one, and
+	// maps only some of its samples
+	groups.Add(1, cmaf.TrackFragmentBox{
+		Sgpd: []*mp4.Sgpd{newRollDescriptions(-3, -2)},
+		Sbgp: []*mp4.Sbgp{
+			newSampleToGroup(groupingTypeRoll, mp4.SbgpEntry{SampleCount: 1, GroupDescriptionIndex: 0x10002}, mp4.SbgpEntry{SampleCount: 1, GroupDescriptionIndex: 0x10001}),
+			newSampleToGroup([4]byte{'s', 'e', 'i', 'g'}, mp4.SbgpEntry{SampleCount: 3, GroupDescriptionIndex: 0x10001}),
+		},
+	}, 7, 3, [4]byte{'s', 'e', 'i', 'g'})
+
+	require.Len(t, groups[1], 1)
+	group := groups[1][0]
+	assert.Equal(t, [][]byte{{0xff, 0xfe}, {0xff, 0xfd}}, group.Descriptions)
+	assert.Equal(t, uint64(10), group.SampleCount)
+
+	t.Run("fragment", func(t *testing.T) {
+		track := muxTrack{Trak: &cmaf.TrackBox{}, Groups: groups[1]}
+		// the fragment holds the last sample of the first fragment up to the third one
+		boxes, err := fragmentSampleGroups(track, 3, 6)
+		require.NoError(t, err)
+		require.Len(t, boxes, 2)
+		assert.Equal(t, []int16{-2, -3}, boxes[0].(*mp4.Sgpd).RollDistances)
+		assert.Equal(t, []mp4.SbgpEntry{
+			{SampleCount: 1, GroupDescriptionIndex: 0x10001},
+			{SampleCount: 3, GroupDescriptionIndex: 0},
+			{SampleCount: 1, GroupDescriptionIndex: 0x10001},
+			{SampleCount: 1, GroupDescriptionIndex: 0x10002},
+		}, boxes[1].(*mp4.Sbgp).Entries)
+
+		// no sample of the second fragment is mapped
+		boxes, err = fragmentSampleGroups(track, 4, 3)
+		require.NoError(t, err)
+		assert.Empty(t, boxes)
+	})
+
+	root := &boxtree.BoxNode{}
+	require.NoError(t, root.Append(mp4.BoxTypeMoov(), &mp4.Moov{}))
+	trak := newTrack(t, root.Children[0])
+	stbl := trak.Mdia.Minf.Stbl.Node
+	require.NoError(t, stbl.Append(mp4.BoxTypeSgpd(), newRollDescriptions(-1)))
+
+	require.NoError(t, writeSampleGroups(trak, groups[1], 12))
+
+	sgpd, err := stbl.P("sgpd")
+	require.NoError(t, err)
+	require.Len(t, sgpd, 1)
+	assert.Equal(t, []int16{-1, -2, -3}, sgpd[0].Box.(*mp4.Sgpd).RollDistances)
+
+	sbgp, err := stbl.P("sbgp")
+	require.NoError(t, err)
+	require.Len(t, sbgp, 1)
+	assert.Equal(t, []mp4.SbgpEntry{
+		{SampleCount: 2, GroupDescriptionIndex: 1},
+		{SampleCount: 2, GroupDescriptionIndex: 2},
+		{SampleCount: 3, GroupDescriptionIndex: 0},
+		{SampleCount: 1, GroupDescriptionIndex: 2},
+		{SampleCount: 1, GroupDescriptionIndex: 3},
+		{SampleCount: 3, GroupDescriptionIndex: 0},
+	}, sbgp[0].Box.(*mp4.Sbgp).Entries)
+}
+
+func TestUnsupportedGroupDescriptions(t *testing.T) {
+	groupingType := [4]byte{'t', 's', 't', 'x'}
+
+	// the entries of an unknown grouping type are taken as of the same length
+	d, err := readGroupDescriptions(&mp4.Sgpd{GroupingType: groupingType, EntryCount: 2, Unsupported: []byte{1, 2, 3, 4}})
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{1, 2}, {3, 4}}, d.Entries)
+
+	root := &boxtree.BoxNode{}
+	require.NoError(t, root.Append(mp4.BoxTypeMoov(), &mp4.Moov{}))
+	trak := newTrack(t, root.Children[0])
+	stbl := trak.Mdia.Minf.Stbl.Node
+	unsupported := &mp4.Sgpd{GroupingType: groupingType, EntryCount: 2, Unsupported: []byte{1, 2, 3}}
+	require.NoError(t, stbl.Append(mp4.BoxTypeSgpd(), unsupported))
+	trak.Mdia.Minf.Stbl.Sgpd = []*mp4.Sgpd{unsupported}
+
+	// the track describes its samples alone, with a sgpd box which cannot be read
+	groups := trackSampleGroups(trak, nil, 5)
+	require.Len(t, groups, 1)
+	require.NoError(t, writeSampleGroups(trak, groups, 5))
+
+	sgpd, err := stbl.P("sgpd")
+	require.NoError(t, err)
+	require.Len(t, sgpd, 1)
+	assert.Same(t, unsupported, sgpd[0].Box)
+
+	sbgp, err := stbl.P("sbgp")
+	require.NoError(t, err)
+	require.Len(t, sbgp, 1)
+	assert.Equal(t, groupingTypeOf(groupingType), sbgp[0].Box.(*mp4.Sbgp).GroupingType)
+	assert.Equal(t, []mp4.SbgpEntry{{SampleCount: 5, GroupDescriptionIndex: 1}}, sbgp[0].Box.(*mp4.Sbgp).Entries)
+}
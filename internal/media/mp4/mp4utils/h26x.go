@@ -0,0 +1,414 @@
+package mp4utils
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+/*************************** AVC ****************************/
+
+// profile_idc of the profiles whose SPS carries chroma_format_idc and bit depths
+var avcHighProfiles = []uint64{100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135}
+
+// parseAVCSequenceParameterSet reads seq_parameter_set_data (ITU-T H.264 Section 7.3.2.1.1)
+// and its VUI (Annex E.1.1).
+func parseAVCSequenceParameterSet(nalu []byte, info *VideoInfo) error {
+	if len(nalu) < 4 {
+		return errors.New("sequence parameter set too short")
+	}
+	s := newSyntaxReader(unescapeRBSP(nalu[1:])) // skip the NAL unit header
+
+	profileIdc := s.u(8)
+	s.skip(16) // constraint_set flags, level_idc
+	s.ue()     // seq_parameter_set_id
+
+	chromaFormatIdc := uint64(1)
+	var separateColourPlane bool
+	bitDepthLuma := uint64(8)
+	if slices.Contains(avcHighProfiles, profileIdc) {
+		if chromaFormatIdc = s.ue(); chromaFormatIdc == 3 {
+			separateColourPlane = s.flag()
+		}
+		bitDepthLuma = 8 + s.ue()
+		s.ue()        // bit_depth_chroma_minus8
+		s.flag()      // qpprime_y_zero_transform_bypass_flag
+		if s.flag() { // seq_scaling_matrix_present_flag
+			count := 8
+			if chromaFormatIdc == 3 {
+				count = 12
+			}
+			for i := 0; i < count; i++ {
+				if s.flag() { // seq_scaling_list_present_flag
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					skipAVCScalingList(s, size)
+				}
+			}
+		}
+	}
+
+	s.ue()          // log2_max_frame_num_minus4
+	switch s.ue() { // pic_order_cnt_type
+	case 0:
+		s.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		s.flag() // delta_pic_order_always_zero_flag
+		s.se()   // offset_for_non_ref_pic
+		s.se()   // offset_for_top_to_bottom_field
+		for i := s.ue(); i > 0 && s.err == nil; i-- {
+			s.se() // offset_for_ref_frame
+		}
+	}
+	s.ue()   // max_num_ref_frames
+	s.flag() // gaps_in_frame_num_value_allowed_flag
+	widthInMbs := s.ue() + 1
+	heightInMapUnits := s.ue() + 1
+	frameMbsOnly := s.flag()
+	if !frameMbsOnly {
+		s.flag() // mb_adaptive_frame_field_flag
+	}
+	s.flag() // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom uint64
+	if s.flag() { // frame_cropping_flag
+		cropLeft, cropRight, cropTop, cropBottom = s.ue(), s.ue(), s.ue(), s.ue()
+	}
+
+	frameHeightFactor := uint64(2)
+	if frameMbsOnly {
+		frameHeightFactor = 1
+	}
+	cropUnitX, cropUnitY := uint64(1), frameHeightFactor
+	if chromaFormatIdc != 0 && !separateColourPlane {
+		subWidthC, subHeightC := uint64(2), uint64(2) // 4:2:0
+		switch chromaFormatIdc {
+		case 2:
+			subHeightC = 1
+		case 3:
+			subWidthC, subHeightC = 1, 1
+		}
+		cropUnitX, cropUnitY = subWidthC, subHeightC*frameHeightFactor
+	}
+
+	if s.flag() { // vui_parameters_present_flag
+		readVUIVideoSignal(s, info)
+		if s.flag() { // chroma_loc_info_present_flag
+			s.ue()
+			s.ue()
+		}
+		if s.flag() { // timing_info_present_flag
+			numUnitsInTick, timeScale := s.u(32), s.u(32)
+			if numUnitsInTick != 0 {
+				// a frame lasts two ticks (field pairs)
+				info.FrameRate = float64(timeScale) / float64(2*numUnitsInTick)
+			}
+		}
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	info.Width = uint32(widthInMbs*16 - cropUnitX*(cropLeft+cropRight))
+	info.Height = uint32(frameHeightFactor*heightInMapUnits*16 - cropUnitY*(cropTop+cropBottom))
+	info.BitDepth = uint8(bitDepthLuma)
+	return nil
+}
+
+// skipAVCScalingList reads scaling_list (ITU-T H.264 Section 7.3.2.1.1.1)
+func skipAVCScalingList(s *syntaxReader, size int) {
+	lastScale, nextScale := int64(8), int64(8)
+	for j := 0; j < size && s.err == nil; j++ {
+		if nextScale != 0 {
+			nextScale = (lastScale + s.se() + 256) % 256
+		}
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+}
+
+// readVUIVideoSignal reads the beginning of the VUI, shared by H.264 (Annex E.1.1) and
+// H.265 (Annex E.2.1), up to the colour description.
+func readVUIVideoSignal(s *syntaxReader, info *VideoInfo) {
+	if s.flag() { // aspect_ratio_info_present_flag
+		if s.u(8) == 255 { // aspect_ratio_idc == EXTENDED_SAR
+			s.skip(32) // sar_width, sar_height
+		}
+	}
+	if s.flag() { // overscan_info_present_flag
+		s.flag() // overscan_appropriate_flag
+	}
+	if s.flag() { // video_signal_type_present_flag
+		s.skip(3) // video_format
+		info.FullRange = s.flag()
+		if s.flag() { // colour_description_present_flag
+			info.ColourPrimaries = uint8(s.u(8))
+			info.TransferCharacteristics = uint8(s.u(8))
+			info.MatrixCoefficients = uint8(s.u(8))
+		}
+	}
+}
+
+/*************************** HEVC ****************************/
+
+// NAL unit types of the parameter sets (ITU-T H.265 Table 7-1)
+const (
+	hevcNaluTypeVPS = 32
+	hevcNaluTypeSPS = 33
+)
+
+// parseHEVCParameterSets reads the SPS of a hvcC, and its VPS for the frame rate when the
+// SPS has no timing information.
+func parseHEVCParameterSets(hvcC *mp4.HvcC, info *VideoInfo) (err error) {
+	var vps, sps []byte
+	for _, array := range hvcC.NaluArrays {
+		if len(array.Nalus) == 0 {
+			continue
+		}
+		switch array.NaluType {
+		case hevcNaluTypeVPS:
+			vps = array.Nalus[0].NALUnit
+		case hevcNaluTypeSPS:
+			sps = array.Nalus[0].NALUnit
+		}
+	}
+	if sps == nil {
+		return errors.New("hvcC without sequence parameter set")
+	}
+	if err = parseHEVCSequenceParameterSet(sps, info); err != nil {
+		return
+	}
+	if info.FrameRate == 0 && vps != nil {
+		err = parseHEVCVideoParameterSet(vps, info)
+	}
+	return
+}
+
+// skipHEVCProfileTierLevel reads profile_tier_level (ITU-T H.265 Section 7.3.3)
+func skipHEVCProfileTierLevel(s *syntaxReader, maxSubLayersMinus1 uint64) {
+	s.skip(88) // general profile, tier, compatibility and constraint flags
+	s.skip(8)  // general_level_idc
+	subLayerProfilePresent := make([]bool, maxSubLayersMinus1)
+	subLayerLevelPresent := make([]bool, maxSubLayersMinus1)
+	for i := range maxSubLayersMinus1 {
+		subLayerProfilePresent[i] = s.flag()
+		subLayerLevelPresent[i] = s.flag()
+	}
+	if maxSubLayersMinus1 > 0 {
+		s.skip(uint(2 * (8 - maxSubLayersMinus1))) // reserved_zero_2bits
+	}
+	for i := range maxSubLayersMinus1 {
+		if subLayerProfilePresent[i] {
+			s.skip(88)
+		}
+		if subLayerLevelPresent[i] {
+			s.skip(8)
+		}
+	}
+}
+
+// parseHEVCSequenceParameterSet reads seq_parameter_set_rbsp (ITU-T H.265 Section 7.3.2.2)
+// and its VUI (Annex E.2.1).
+func parseHEVCSequenceParameterSet(nalu []byte, info *VideoInfo) error {
+	if len(nalu) < 3 {
+		return errors.New("sequence parameter set too short")
+	}
+	s := newSyntaxReader(unescapeRBSP(nalu[2:])) // skip the NAL unit header
+
+	s.skip(4) // sps_video_parameter_set_id
+	maxSubLayersMinus1 := s.u(3)
+	s.flag() // sps_temporal_id_nesting_flag
+	skipHEVCProfileTierLevel(s, maxSubLayersMinus1)
+	s.ue() // sps_seq_parameter_set_id
+
+	chromaFormatIdc := s.ue()
+	var separateColourPlane bool
+	if chromaFormatIdc == 3 {
+		separateColourPlane = s.flag()
+	}
+	width, height := s.ue(), s.ue()
+	if s.flag() { // conformance_window_flag
+		left, right, top, bottom := s.ue(), s.ue(), s.ue(), s.ue()
+		subWidthC, subHeightC := uint64(1), uint64(1)
+		if !separateColourPlane {
+			switch chromaFormatIdc {
+			case 1:
+				subWidthC, subHeightC = 2, 2
+			case 2:
+				subWidthC = 2
+			}
+		}
+		width -= subWidthC * (left + right)
+		height -= subHeightC * (top + bottom)
+	}
+	bitDepthLuma := 8 + s.ue()
+	s.ue() // bit_depth_chroma_minus8
+	log2MaxPicOrderCntLsb := s.ue() + 4
+
+	first := maxSubLayersMinus1
+	if s.flag() { // sps_sub_layer_ordering_info_present_flag
+		first = 0
+	}
+	for i := first; i <= maxSubLayersMinus1 && s.err == nil; i++ {
+		s.ue() // sps_max_dec_pic_buffering_minus1
+		s.ue() // sps_max_num_reorder_pics
+		s.ue() // sps_max_latency_increase_plus1
+	}
+	for range 6 {
+		s.ue() // log2_min_luma_coding_block_size_minus3 ... max_transform_hierarchy_depth_intra
+	}
+	if s.flag() { // scaling_list_enabled_flag
+		if s.flag() { // sps_scaling_list_data_present_flag
+			skipHEVCScalingListData(s)
+		}
+	}
+	s.flag()      // amp_enabled_flag
+	s.flag()      // sample_adaptive_offset_enabled_flag
+	if s.flag() { // pcm_enabled_flag
+		s.skip(8) // pcm_sample_bit_depth_luma_minus1, pcm_sample_bit_depth_chroma_minus1
+		s.ue()    // log2_min_pcm_luma_coding_block_size_minus3
+		s.ue()    // log2_diff_max_min_pcm_luma_coding_block_size
+		s.flag()  // pcm_loop_filter_disabled_flag
+	}
+	skipHEVCShortTermRefPicSets(s)
+	if s.flag() { // long_term_ref_pics_present_flag
+		for i := s.ue(); i > 0 && s.err == nil; i-- {
+			s.skip(uint(log2MaxPicOrderCntLsb)) // lt_ref_pic_poc_lsb_sps
+			s.flag()                            // used_by_curr_pic_lt_sps_flag
+		}
+	}
+	s.flag() // sps_temporal_mvp_enabled_flag
+	s.flag() // strong_intra_smoothing_enabled_flag
+
+	if s.flag() { // vui_parameters_present_flag
+		readVUIVideoSignal(s, info)
+		if s.flag() { // chroma_loc_info_present_flag
+			s.ue()
+			s.ue()
+		}
+		s.flag()      // neutral_chroma_indication_flag
+		s.flag()      // field_seq_flag
+		s.flag()      // frame_field_info_present_flag
+		if s.flag() { // default_display_window_flag
+			s.ue()
+			s.ue()
+			s.ue()
+			s.ue()
+		}
+		if s.flag() { // vui_timing_info_present_flag
+			numUnitsInTick, timeScale := s.u(32), s.u(32)
+			if numUnitsInTick != 0 {
+				info.FrameRate = float64(timeScale) / float64(numUnitsInTick)
+			}
+		}
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	info.Width = uint32(width)
+	info.Height = uint32(height)
+	info.BitDepth = uint8(bitDepthLuma)
+	return nil
+}
+
+// skipHEVCScalingListData reads scaling_list_data (ITU-T H.265 Section 7.3.4)
+func skipHEVCScalingListData(s *syntaxReader) {
+	for sizeId := 0; sizeId < 4; sizeId++ {
+		step := 1
+		if sizeId == 3 {
+			step = 3
+		}
+		for matrixId := 0; matrixId < 6; matrixId += step {
+			if !s.flag() { // scaling_list_pred_mode_flag
+				s.ue() // scaling_list_pred_matrix_id_delta
+				continue
+			}
+			coefNum := min(64, 1<<(4+(sizeId<<1)))
+			if sizeId > 1 {
+				s.se() // scaling_list_dc_coef_minus8
+			}
+			for i := 0; i < coefNum && s.err == nil; i++ {
+				s.se() // scaling_list_delta_coef
+			}
+		}
+	}
+}
+
+// skipHEVCShortTermRefPicSets reads num_short_term_ref_pic_sets and the st_ref_pic_set of
+// the SPS (ITU-T H.265 Section 7.3.7), keeping the number of pictures of each set since
+// the sets predicted from others depend on it.
+func skipHEVCShortTermRefPicSets(s *syntaxReader) {
+	numSets := s.ue()
+	if numSets > 64 {
+		s.err = errors.New("invalid num_short_term_ref_pic_sets")
+		return
+	}
+	numDeltaPocs := make([]uint64, numSets)
+	for idx := range numSets {
+		if idx != 0 && s.flag() { // inter_ref_pic_set_prediction_flag
+			// delta_idx_minus1 is only present in slice headers (idx == numSets)
+			s.flag() // delta_rps_sign
+			s.ue()   // abs_delta_rps_minus1
+			refIdx := idx - 1
+			for j := uint64(0); j <= numDeltaPocs[refIdx] && s.err == nil; j++ {
+				used := s.flag()      // used_by_curr_pic_flag
+				if used || s.flag() { // use_delta_flag
+					numDeltaPocs[idx]++
+				}
+			}
+			continue
+		}
+		numNegative, numPositive := s.ue(), s.ue()
+		if numNegative > 16 || numPositive > 16 {
+			s.err = errors.New("invalid st_ref_pic_set")
+			return
+		}
+		for i := uint64(0); i < numNegative+numPositive; i++ {
+			s.ue()   // delta_poc_minus1
+			s.flag() // used_by_curr_pic_flag
+		}
+		numDeltaPocs[idx] = numNegative + numPositive
+	}
+}
+
+// parseHEVCVideoParameterSet reads the frame rate of video_parameter_set_rbsp (ITU-T H.265
+// Section 7.3.2.1).
+func parseHEVCVideoParameterSet(nalu []byte, info *VideoInfo) error {
+	if len(nalu) < 3 {
+		return errors.New("video parameter set too short")
+	}
+	s := newSyntaxReader(unescapeRBSP(nalu[2:]))
+
+	s.skip(12) // vps_video_parameter_set_id ... vps_max_layers_minus1
+	maxSubLayersMinus1 := s.u(3)
+	s.flag()   // vps_temporal_id_nesting_flag
+	s.skip(16) // vps_reserved_0xffff_16bits
+	skipHEVCProfileTierLevel(s, maxSubLayersMinus1)
+
+	first := maxSubLayersMinus1
+	if s.flag() { // vps_sub_layer_ordering_info_present_flag
+		first = 0
+	}
+	for i := first; i <= maxSubLayersMinus1 && s.err == nil; i++ {
+		s.ue()
+		s.ue()
+		s.ue()
+	}
+	maxLayerId := s.u(6)
+	numLayerSetsMinus1 := s.ue()
+	for i := uint64(1); i <= numLayerSetsMinus1 && s.err == nil; i++ {
+		s.skip(uint(maxLayerId + 1)) // layer_id_included_flag
+	}
+	if s.flag() { // vps_timing_info_present_flag
+		numUnitsInTick, timeScale := s.u(32), s.u(32)
+		if numUnitsInTick != 0 {
+			info.FrameRate = float64(timeScale) / float64(numUnitsInTick)
+		}
+	}
+	return s.err
+}
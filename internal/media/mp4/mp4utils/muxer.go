@@ -1,12 +1,15 @@
 package mp4utils
 
 import (
+	"cmp"
 	"downloader/internal/media/mp4/boxtree"
 	"downloader/internal/media/mp4/cmaf"
 	"downloader/pkg/utils"
 	"errors"
 	"io"
+	"math"
 	"slices"
+	"time"
 
 	"github.com/Spidey120703/go-mp4"
 )
@@ -15,14 +18,37 @@ const DefaultChunkSize uint32 = 22
 
 type MuxContext struct {
 	cmaf.Context
-	Samples   map[uint32][]Sample
-	ChunkSize uint32
+	Samples      map[uint32][]Sample
+	SampleGroups SampleGroups
+	// SourceFragments records the fragments of the input, kept by Fragmentize
+	SourceFragments SourceFragments
+	ChunkSize       uint32
+	// InterleaveDuration makes chunks span a fixed duration of every track, laid out by
+	// decode time, instead of ChunkSize samples of one track after the other.
+	InterleaveDuration time.Duration
+	// tracks holds the tracks of the movie once desegmentized, and mediaData their samples
+	// in the order of the mdat box
+	tracks    []muxTrack
+	mediaData []Sample
+	// fragments holds the fragments of the movie once fragmentized, written by Finalize
+	fragments       []fragment
+	fragmentOptions FragmentOptions
+}
+
+// muxTrack is a track of a desegmentized (or fragmentized) movie along with its samples,
+// and the sample groups of its fragments once fragmentized.
+type muxTrack struct {
+	Trak      *cmaf.TrackBox
+	Samples   []Sample
+	Timescale uint32
+	Groups    []*sampleGroup
 }
 
 func NewMuxContext() *MuxContext {
 	return &MuxContext{
-		ChunkSize: DefaultChunkSize,
-		Samples:   make(map[uint32][]Sample),
+		ChunkSize:    DefaultChunkSize,
+		Samples:      make(map[uint32][]Sample),
+		SampleGroups: make(SampleGroups),
 	}
 }
 
@@ -30,6 +56,8 @@ func OfDecryptor(decryptor IDecryptor) *MuxContext {
 	muxer := NewMuxContext()
 	muxer.Root = decryptor.GetRoot()
 	muxer.Samples = decryptor.GetSamples()
+	muxer.SampleGroups = decryptor.GetSampleGroups()
+	muxer.SourceFragments = decryptor.GetSourceFragments()
 	return muxer
 }
 
@@ -41,7 +69,7 @@ func (ctx *MuxContext) GetChunkSize() uint32 {
 }
 
 func (ctx *MuxContext) getTrackExtendsBox(trackID uint32) (trex *mp4.Trex, err error) {
-	for _, trex = range ctx.Header.Moov.Mvex.Trex {
+	for _, trex = range ctx.CurrentHeader().Moov.Mvex.Trex {
 		if trex.TrackID == trackID {
 			break
 		}
@@ -60,6 +88,9 @@ func (ctx *MuxContext) Initialize(input io.ReadSeeker) (err error) {
 	if ctx.Samples == nil {
 		ctx.Samples = make(map[uint32][]Sample)
 	}
+	if ctx.SampleGroups == nil {
+		ctx.SampleGroups = make(SampleGroups)
+	}
 	err = ctx.Context.Initialize(input)
 	if err != nil {
 		return
@@ -67,11 +98,14 @@ func (ctx *MuxContext) Initialize(input io.ReadSeeker) (err error) {
 	for idx := range ctx.Header.Moof {
 		var trex *mp4.Trex
 		var samples []Sample
+		ctx.SourceFragments.begin()
 		for _, traf := range ctx.Header.Moof[idx].Traf {
 			if trex, err = ctx.getTrackExtendsBox(traf.Tfhd.TrackID); err != nil {
 				return
 			}
 			samples = GetFullSamples(traf, ctx.Header.Mdat[idx], trex)
+			ctx.SourceFragments.add(traf.Tfhd.TrackID, uint64(len(ctx.Samples[traf.Tfhd.TrackID])), uint32(len(samples)))
+			ctx.SampleGroups.Add(traf.Tfhd.TrackID, traf, uint64(len(ctx.Samples[traf.Tfhd.TrackID])), uint32(len(samples)))
 			ctx.Samples[traf.Tfhd.TrackID] = append(ctx.Samples[traf.Tfhd.TrackID], samples...)
 		}
 	}
@@ -79,6 +113,17 @@ func (ctx *MuxContext) Initialize(input io.ReadSeeker) (err error) {
 }
 
 func (ctx *MuxContext) AddSegment(input io.ReadSeeker) (seg *cmaf.Segment, err error) {
+	return ctx.addSegment(input, nil)
+}
+
+// AddSegmentAt adds a media segment read from filePath, where input starts at offset (e.g.
+// the byte range of the segment). Its samples refer to the file rather than holding their
+// data, which is streamed from there by Finalize.
+func (ctx *MuxContext) AddSegmentAt(input io.ReadSeeker, filePath string, offset int64) (seg *cmaf.Segment, err error) {
+	return ctx.addSegment(input, &sampleSource{FilePath: filePath, Offset: offset})
+}
+
+func (ctx *MuxContext) addSegment(input io.ReadSeeker, source *sampleSource) (seg *cmaf.Segment, err error) {
 	seg, err = ctx.Context.AddSegment(input)
 	if err != nil {
 		return
@@ -86,62 +131,129 @@ func (ctx *MuxContext) AddSegment(input io.ReadSeeker) (seg *cmaf.Segment, err e
 	for idx := range seg.Moof {
 		var trex *mp4.Trex
 		var samples []Sample
+		ctx.SourceFragments.begin()
 		for _, traf := range seg.Moof[idx].Traf {
 			if trex, err = ctx.getTrackExtendsBox(traf.Tfhd.TrackID); err != nil {
 				return
 			}
 			samples = GetFullSamples(traf, seg.Mdat[idx], trex)
-			ctx.Samples[traf.Tfhd.TrackID] = append(ctx.Samples[traf.Tfhd.TrackID], samples...)
+			if source != nil {
+				source.detach(traf, samples)
+			}
+			trackID := remapSamples(ctx.CurrentInitialization(), traf.Tfhd.TrackID, samples)
+			ctx.SourceFragments.add(trackID, uint64(len(ctx.Samples[trackID])), uint32(len(samples)))
+			ctx.SampleGroups.Add(trackID, traf, uint64(len(ctx.Samples[trackID])), uint32(len(samples)))
+			ctx.Samples[trackID] = append(ctx.Samples[trackID], samples...)
+		}
+		if source != nil {
+			seg.Mdat[idx].Data = nil
 		}
 	}
 	return
 }
 
-func (ctx *MuxContext) Desegmentize() (err error) {
-
-	if ctx.Header, err = cmaf.InitializeHeader(ctx.Root); err != nil {
-		return
+// duration converts a decode time of the track into a duration
+func (track muxTrack) duration(decodeTime uint64) time.Duration {
+	if track.Timescale == 0 {
+		return 0
 	}
+	timescale := uint64(track.Timescale)
+	return time.Duration(decodeTime/timescale)*time.Second + time.Duration(decodeTime%timescale)*time.Second/time.Duration(timescale)
+}
 
-	{ // moov.mvhd
-		var maxDuration uint64
-		for _, samples := range ctx.Samples {
-			var duration uint64
-			for _, sample := range samples {
-				duration += uint64(sample.SampleDuration)
-			}
-			maxDuration = max(maxDuration, duration)
+// chunk is a run of contiguous samples of a track, described by a single sample entry
+type chunk struct {
+	FirstSample            uint32
+	SampleCount            uint32
+	SampleDescriptionIndex uint32
+	Start                  time.Duration // decode time of the first sample
+}
+
+// layoutChunks splits the samples of a track into chunks, starting a new chunk whenever the
+// sample description changes (e.g. after a discontinuity), so that each chunk refers to one
+// sample description. Chunks hold at most ChunkSize samples, or the samples decoded
+// within a period of InterleaveDuration.
+func (ctx *MuxContext) layoutChunks(track muxTrack) (chunks []chunk) {
+	var decodeTime uint64
+	var period time.Duration = -1
+	for idx, sample := range track.Samples {
+		start := track.duration(decodeTime)
+		sampleDescriptionIndex := max(sample.SampleDescriptionIndex, 1)
+		split := len(chunks) == 0 || chunks[len(chunks)-1].SampleDescriptionIndex != sampleDescriptionIndex
+		if ctx.InterleaveDuration > 0 {
+			split = split || start/ctx.InterleaveDuration != period
+			period = start / ctx.InterleaveDuration
+		} else {
+			split = split || chunks[len(chunks)-1].SampleCount == ctx.GetChunkSize()
 		}
-		switch ctx.Header.Moov.Mvhd.GetVersion() {
-		case 0:
-			ctx.Header.Moov.Mvhd.DurationV0 = uint32(maxDuration)
-		case 1:
-			ctx.Header.Moov.Mvhd.DurationV1 = maxDuration
-		default:
-			ctx.Header.Moov.Mvhd.DurationV0 = uint32(maxDuration)
-			ctx.Header.Moov.Mvhd.DurationV1 = maxDuration
+		if split {
+			chunks = append(chunks, chunk{
+				FirstSample:            uint32(idx),
+				SampleDescriptionIndex: sampleDescriptionIndex,
+				Start:                  start,
+			})
 		}
+		chunks[len(chunks)-1].SampleCount++
+		decodeTime += uint64(sample.SampleDuration)
 	}
+	return
+}
 
-	for _, trak := range ctx.Header.Moov.Trak {
-		samples := ctx.Samples[trak.Tkhd.TrackID]
+func setTrackHeaderDuration(tkhd *mp4.Tkhd, duration uint64) {
+	switch tkhd.GetVersion() {
+	case 0:
+		tkhd.DurationV0 = uint32(duration)
+	case 1:
+		tkhd.DurationV1 = duration
+	default:
+		tkhd.DurationV0 = uint32(duration)
+		tkhd.DurationV1 = duration
+	}
+}
+
+func setMovieHeaderDuration(mvhd *mp4.Mvhd, duration uint64) {
+	switch mvhd.GetVersion() {
+	case 0:
+		mvhd.DurationV0 = uint32(duration)
+	case 1:
+		mvhd.DurationV1 = duration
+	default:
+		mvhd.DurationV0 = uint32(duration)
+		mvhd.DurationV1 = duration
+	}
+}
+
+// trackHeaderDuration returns the duration of a track in the movie timescale, that of its
+// edit list if any, or else that of its media, of the given duration in the media timescale.
+func trackHeaderDuration(trak *cmaf.TrackBox, mediaDuration uint64, movieTimescale uint32) (duration uint64) {
+	if elst := trak.Edts.Elst; elst != nil && len(elst.Entries) != 0 {
+		for idx := range elst.Entries {
+			duration += elst.GetSegmentDuration(idx)
+		}
+		return
+	}
+	if trak.Mdia.Mdhd.Timescale == 0 {
+		return mediaDuration
+	}
+	return mediaDuration * uint64(movieTimescale) / uint64(trak.Mdia.Mdhd.Timescale)
+}
 
+// setDurations sets the durations of the movie and of its tracks from their samples and edit
+// lists.
+func (ctx *MuxContext) setDurations() {
+	movieTimescale := ctx.Header.Moov.Mvhd.Timescale
+	var maxDuration uint64
+	for _, trak := range ctx.Header.Moov.Trak {
 		var duration uint64
-		for _, sample := range samples {
+		for _, sample := range ctx.Samples[trak.Tkhd.TrackID] {
 			duration += uint64(sample.SampleDuration)
 		}
 
 		{ // moov.trak.tkhd
 			trak.Tkhd.SetFlags(0x3)
-			switch trak.Tkhd.GetVersion() {
-			case 0:
-				trak.Tkhd.DurationV0 = uint32(duration)
-			case 1:
-				trak.Tkhd.DurationV1 = duration
-			default:
-				trak.Tkhd.DurationV0 = uint32(duration)
-				trak.Tkhd.DurationV1 = duration
-			}
+			trackDuration := trackHeaderDuration(&trak, duration, movieTimescale)
+			setTrackHeaderDuration(trak.Tkhd, trackDuration)
+			maxDuration = max(maxDuration, trackDuration)
 		}
 
 		{ // moov.trak.mdia.mdhd
@@ -155,6 +267,22 @@ func (ctx *MuxContext) Desegmentize() (err error) {
 				trak.Mdia.Mdhd.DurationV1 = duration
 			}
 		}
+	}
+
+	// moov.mvhd
+	setMovieHeaderDuration(ctx.Header.Moov.Mvhd, maxDuration)
+}
+
+func (ctx *MuxContext) Desegmentize() (err error) {
+
+	if ctx.Header, err = cmaf.InitializeHeader(ctx.Root); err != nil {
+		return
+	}
+
+	ctx.setDurations()
+
+	for _, trak := range ctx.Header.Moov.Trak {
+		samples := ctx.Samples[trak.Tkhd.TrackID]
 
 		{ // moov.trak.mdia.minf.stbl.stts
 			trak.Mdia.Minf.Stbl.Stts.Entries = []mp4.SttsEntry{}
@@ -175,23 +303,6 @@ func (ctx *MuxContext) Desegmentize() (err error) {
 		}
 
 		sampleCount := uint32(len(samples))
-		{ // moov.trak.mdia.minf.stbl.stsc
-			trak.Mdia.Minf.Stbl.Stsc.Entries = []mp4.StscEntry{
-				{
-					FirstChunk:             1,
-					SamplesPerChunk:        ctx.GetChunkSize(),
-					SampleDescriptionIndex: 1,
-				},
-			}
-			if sampleCount%ctx.GetChunkSize() != 0 {
-				trak.Mdia.Minf.Stbl.Stsc.Entries = append(trak.Mdia.Minf.Stbl.Stsc.Entries, mp4.StscEntry{
-					FirstChunk:             sampleCount/ctx.GetChunkSize() + 1,
-					SamplesPerChunk:        sampleCount % ctx.GetChunkSize(),
-					SampleDescriptionIndex: 1,
-				})
-			}
-			trak.Mdia.Minf.Stbl.Stsc.EntryCount = uint32(len(trak.Mdia.Minf.Stbl.Stsc.Entries))
-		}
 
 		{ // moov.trak.mdia.minf.stbl.stsz
 			trak.Mdia.Minf.Stbl.Stsz.SampleCount = sampleCount
@@ -201,12 +312,6 @@ func (ctx *MuxContext) Desegmentize() (err error) {
 			}
 		}
 
-		{ // moov.trak.mdia.minf.stbl.stco
-			chunkCount := (sampleCount + ctx.GetChunkSize() - 1) / ctx.GetChunkSize()
-			trak.Mdia.Minf.Stbl.Stco.EntryCount = chunkCount
-			trak.Mdia.Minf.Stbl.Stco.ChunkOffset = make([]uint32, chunkCount)
-		}
-
 		var flags uint32 = 0
 		for _, moof := range ctx.Header.Moof {
 			for _, traf := range moof.Traf {
@@ -294,32 +399,12 @@ func (ctx *MuxContext) Desegmentize() (err error) {
 			}
 		}
 
-		if trak.Mdia.Minf.Stbl.Sgpd != nil {
-			sgpd, found := trak.Mdia.Minf.Stbl.Node.Cache[mp4.BoxTypeSgpd()]
-			if !found {
-				goto end
-			}
-			if _, err = trak.Mdia.Minf.Stbl.Node.Remove(mp4.BoxTypeSgpd()); err != nil {
-				return
-			}
-			trak.Mdia.Minf.Stbl.Node.Children = append(
-				trak.Mdia.Minf.Stbl.Node.Children,
-				sgpd[0],
-			)
-
-			sbgp := &mp4.Sbgp{}
-			sbgp.Entries = append(sbgp.Entries, mp4.SbgpEntry{
-				SampleCount:           sampleCount,
-				GroupDescriptionIndex: 1,
-			})
-			sbgp.EntryCount = uint32(len(sbgp.Entries))
-
-			if err = trak.Mdia.Minf.Stbl.Node.Append(mp4.BoxTypeSbgp(), sbgp); err != nil {
+		if groups := trackSampleGroups(&trak, ctx.SampleGroups[trak.Tkhd.TrackID], uint64(sampleCount)); len(groups) > 0 {
+			if err = writeSampleGroups(&trak, groups, uint64(sampleCount)); err != nil {
 				return
 			}
 		}
 
-	end:
 		err = trak.Mdia.Minf.Stbl.Node.Caching()
 		if err != nil {
 			return
@@ -337,39 +422,24 @@ func (ctx *MuxContext) Desegmentize() (err error) {
 		return
 	}
 
-	var offset uint64
-	counter := utils.NewNullWriter()
-	defer utils.CloseQuietly(counter)
-
-	if offset, err = boxtree.Marshal(counter, ctx.Root); err != nil {
-		return
+	ctx.tracks = []muxTrack{}
+	for idx := range ctx.Header.Moov.Trak {
+		trak := &ctx.Header.Moov.Trak[idx]
+		ctx.tracks = append(ctx.tracks, muxTrack{
+			Trak:      trak,
+			Samples:   ctx.Samples[trak.Tkhd.TrackID],
+			Timescale: trak.Mdia.Mdhd.Timescale,
+		})
 	}
-
-	{ // mdat
-		offset += 8
-		mdat := &mp4.Mdat{}
-		for _, trak := range ctx.Header.Moov.Trak {
-			samples := ctx.Samples[trak.Tkhd.TrackID]
-
-			var i int
-			for idx, sample := range samples {
-				if uint32(idx)%ctx.ChunkSize == 0 {
-					trak.Mdia.Minf.Stbl.Stco.ChunkOffset[i] = uint32(offset)
-					i += 1
-				}
-				mdat.Data = append(mdat.Data, sample.Data...)
-				offset += uint64(len(sample.Data))
-			}
-		}
-		if err = ctx.Root.Append(mp4.BoxTypeMdat(), mdat); err != nil {
-			return
-		}
+	// the media data is written from the samples by Finalize
+	if err = ctx.Root.Append(mp4.BoxTypeMdat(), &mp4.Mdat{}); err != nil {
+		return
 	}
-
-	if ctx.Header, err = cmaf.InitializeHeader(ctx.Root); err != nil {
+	if err = ctx.layoutTracks(); err != nil {
 		return
 	}
 
+	ctx.Header, err = cmaf.InitializeHeader(ctx.Root)
 	return
 }
 
@@ -380,6 +450,9 @@ func (ctx *MuxContext) MuxTrack(other *MuxContext) (err error) {
 	if other.Header, err = cmaf.InitializeHeader(other.Root); err != nil {
 		return
 	}
+	if ctx.fragments != nil {
+		return ctx.muxFragmentedTrack(other)
+	}
 	if len(ctx.Header.Moof) != 0 || len(other.Header.Moof) != 0 {
 		return errors.New("fMP4 is not compatible")
 	}
@@ -387,60 +460,166 @@ func (ctx *MuxContext) MuxTrack(other *MuxContext) (err error) {
 		return errors.New("multi-mdat box is not compatible")
 	}
 
-	var traks []*boxtree.BoxNode
-	if traks, err = other.Root.P("moov.trak"); err != nil {
+	if ctx.tracks == nil || other.tracks == nil {
+		return errors.New("movie is not desegmentized")
+	}
+
+	if err = ctx.adoptTracks(other); err != nil {
+		return
+	}
+	if err = ctx.layoutTracks(); err != nil {
+		return
+	}
+
+	ctx.Header, err = cmaf.InitializeHeader(ctx.Root)
+	return
+}
+
+// adoptTracks moves the trak boxes of other into the movie, numbering them after its tracks.
+func (ctx *MuxContext) adoptTracks(other *MuxContext) (err error) {
+	var trakNodes []*boxtree.BoxNode
+	if trakNodes, err = other.Root.P("moov.trak"); err != nil {
 		return
 	}
 	trackID := ctx.Header.Moov.Mvhd.NextTrackID
-	for idx, trak := range traks {
+	for idx, trak := range trakNodes {
 		tkhd := trak.Cache[mp4.BoxTypeTkhd()][0]
 		tkhd.Box.(*mp4.Tkhd).TrackID = trackID
 		ctx.Header.Moov.Node.Children = slices.Insert(ctx.Header.Moov.Node.Children, 2+idx, trak)
 		trackID += 1
 	}
 	ctx.Header.Moov.Mvhd.NextTrackID = trackID
-
-	if ctx.Header, err = cmaf.InitializeHeader(ctx.Root); err != nil {
+	if err = ctx.Header.Moov.Node.Caching(); err != nil {
 		return
 	}
 
-	var offset uint64
-	counter := utils.NewNullWriter()
-	defer utils.CloseQuietly(counter)
-	{ // other's moov.trak.mdia.minf.stbl.stco
-		if offset, err = boxtree.Marshal(counter, ctx.Root); err != nil {
-			return
-		}
-		for _, trak := range other.Header.Moov.Trak {
-			headerSize := trak.Mdia.Minf.Stbl.Stco.ChunkOffset[0]
-			for idx := range trak.Mdia.Minf.Stbl.Stco.ChunkOffset {
-				trak.Mdia.Minf.Stbl.Stco.ChunkOffset[idx] += uint32(offset) - headerSize
+	ctx.tracks = append(ctx.tracks, other.tracks...)
+	return
+}
+
+// layoutTracks lays the chunks of the tracks out in the mdat box ending the movie, and
+// describes them in the stsc and stco (or co64) boxes of the tracks.
+func (ctx *MuxContext) layoutTracks() (err error) {
+	children := ctx.Root.Children
+	if len(children) == 0 || children[len(children)-1].Info.Type != mp4.BoxTypeMdat() {
+		return errors.New("mdat box is not the last box of the movie")
+	}
+	mdat := children[len(children)-1]
+
+	var chunks [][]chunk
+	var traks []*cmaf.TrackBox
+	for _, track := range ctx.tracks {
+		trackChunks := ctx.layoutChunks(track)
+		chunks = append(chunks, trackChunks)
+		traks = append(traks, track.Trak)
+
+		stsc := track.Trak.Mdia.Minf.Stbl.Stsc
+		stsc.Entries = []mp4.StscEntry{}
+		for idx, c := range trackChunks {
+			if len(stsc.Entries) > 0 {
+				last := &stsc.Entries[len(stsc.Entries)-1]
+				if last.SamplesPerChunk == c.SampleCount && last.SampleDescriptionIndex == c.SampleDescriptionIndex {
+					continue
+				}
 			}
+			stsc.Entries = append(stsc.Entries, mp4.StscEntry{
+				FirstChunk:             uint32(idx + 1),
+				SamplesPerChunk:        c.SampleCount,
+				SampleDescriptionIndex: c.SampleDescriptionIndex,
+			})
 		}
+		stsc.EntryCount = uint32(len(stsc.Entries))
 	}
 
-	mdatNode := ctx.Root.Cache[mp4.BoxTypeMdat()][0]
+	offsets, size := ctx.layoutMediaData(chunks)
+	setMediaDataHeader(mdat, size)
+	return placeMediaData(ctx.Root, mdat, traks, offsets)
+}
 
-	if _, err = ctx.Root.Remove(mp4.BoxTypeMdat()); err != nil {
-		return
+// layoutMediaData places the chunks of the tracks in the payload of the mdat box, one track
+// after the other, or by decode time when interleaved. It returns the offsets of the chunks
+// of each track relative to the payload and the size of the payload, the samples being
+// kept in mediaData in the order they are written. Only the sizes of the samples are used,
+// as recorded in stsz.
+func (ctx *MuxContext) layoutMediaData(chunks [][]chunk) (offsets [][]uint64, size uint64) {
+	type placedChunk struct {
+		Track int
+		chunk
+	}
+	var placed []placedChunk
+	for track := range chunks {
+		for _, c := range chunks[track] {
+			placed = append(placed, placedChunk{track, c})
+		}
+	}
+	if ctx.InterleaveDuration > 0 {
+		// the chunks of each track keep their order, being sorted by their start already
+		slices.SortStableFunc(placed, func(a, b placedChunk) int {
+			return cmp.Compare(a.Start, b.Start)
+		})
+	}
+
+	offsets = make([][]uint64, len(chunks))
+	for track := range chunks {
+		offsets[track] = []uint64{}
+	}
+	ctx.mediaData = []Sample{}
+	for _, c := range placed {
+		offsets[c.Track] = append(offsets[c.Track], size)
+		samples := ctx.tracks[c.Track].Samples[c.FirstSample : c.FirstSample+c.SampleCount]
+		for _, sample := range samples {
+			size += uint64(sample.SampleSize)
+		}
+		ctx.mediaData = append(ctx.mediaData, samples...)
+	}
+	return
+}
+
+// setMediaDataHeader makes an mdat box use a 64-bit largesize when its payload does not fit
+// in the 32-bit size of a compact header.
+func setMediaDataHeader(mdat *boxtree.BoxNode, size uint64) {
+	if mediaDataHeaderSize(size) == mp4.LargeHeaderSize {
+		mdat.Info.HeaderSize = mp4.LargeHeaderSize
+	}
+}
+
+// mediaDataHeaderSize returns the size of the header of an mdat box holding size bytes.
+func mediaDataHeaderSize(size uint64) uint64 {
+	if size+mp4.SmallHeaderSize > math.MaxUint32 {
+		return mp4.LargeHeaderSize
 	}
+	return mp4.SmallHeaderSize
+}
 
-	mdat := mdatNode.Box.(*mp4.Mdat)
-	mdat.Data = append(mdat.Data, other.Header.Mdat[0].Data...)
+// layout computes the offsets and sizes of the boxes of a tree without writing them.
+func layout(root *boxtree.BoxNode) (size uint64, err error) {
+	counter := utils.NewNullWriter()
+	defer utils.CloseQuietly(counter)
+	return boxtree.Marshal(counter, root)
+}
 
-	{ // ctx's moov.trak.mdia.minf.stbl.stco
-		if offset, err = boxtree.Marshal(counter, ctx.Root); err != nil {
+// placeMediaData sets the chunk offsets of the tracks, given relative to the payload of mdat,
+// laying the tree out again until the moov box, which they are part of, stops growing (e.g.
+// once stco boxes are promoted to co64).
+func placeMediaData(root *boxtree.BoxNode, mdat *boxtree.BoxNode, traks []*cmaf.TrackBox, offsets [][]uint64) (err error) {
+	for previous := uint64(math.MaxUint64); ; {
+		if _, err = layout(root); err != nil {
+			return
+		}
+		start := mdat.Info.Offset + mdat.Info.HeaderSize
+		if start == previous {
 			return
 		}
-		offset += 8
-		for _, trak := range ctx.Header.Moov.Trak {
-			oldHeaderSize := trak.Mdia.Minf.Stbl.Stco.ChunkOffset[0]
-			for idx := range trak.Mdia.Minf.Stbl.Stco.ChunkOffset {
-				trak.Mdia.Minf.Stbl.Stco.ChunkOffset[idx] += uint32(offset) - oldHeaderSize
+		previous = start
+
+		for idx, trak := range traks {
+			absolute := make([]uint64, len(offsets[idx]))
+			for i, offset := range offsets[idx] {
+				absolute[i] = start + offset
+			}
+			if _, err = trak.SetChunkOffsets(absolute); err != nil {
+				return
 			}
 		}
 	}
-
-	ctx.Root.Children = append(ctx.Root.Children, mdatNode)
-	return ctx.Root.Caching()
 }
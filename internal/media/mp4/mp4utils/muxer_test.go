@@ -0,0 +1,236 @@
+package mp4utils
+
+import (
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/cmaf"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const gibibyte = 1 << 30
+
+// newLargeMuxContext describes a video track of three 2 GiB samples followed by an audio
+// track of two small ones, without holding any media data.
+func newLargeMuxContext() *MuxContext {
+	ctx := NewMuxContext()
+	ctx.ChunkSize = 2
+	ctx.tracks = []muxTrack{
+		{Samples: []Sample{{SampleSize: 2 * gibibyte}, {SampleSize: 2 * gibibyte}, {SampleSize: 2 * gibibyte}}},
+		{Samples: []Sample{{SampleSize: 1024}, {SampleSize: 512}}},
+	}
+	return ctx
+}
+
+// layoutChunks lays the chunks of every track of the context out.
+func layoutChunks(ctx *MuxContext) (chunks [][]chunk) {
+	for _, track := range ctx.tracks {
+		chunks = append(chunks, ctx.layoutChunks(track))
+	}
+	return
+}
+
+// newTrack appends a track with an empty stco box to the moov box of root.
+func newTrack(t *testing.T, moov *boxtree.BoxNode) *cmaf.TrackBox {
+	node := moov
+	for _, box := range []mp4.IBox{&mp4.Trak{}, &mp4.Mdia{}, &mp4.Minf{}, &mp4.Stbl{}} {
+		require.NoError(t, node.Append(box.GetType(), box))
+		node = node.Children[len(node.Children)-1]
+	}
+	trak := &cmaf.TrackBox{}
+	trak.Mdia.Minf.Stbl.Stco = &mp4.Stco{}
+	trak.Mdia.Minf.Stbl.Node = node
+	require.NoError(t, node.Append(mp4.BoxTypeStco(), trak.Mdia.Minf.Stbl.Stco))
+	return trak
+}
+
+func TestLayoutMediaData(t *testing.T) {
+	ctx := newLargeMuxContext()
+	offsets, size := ctx.layoutMediaData(layoutChunks(ctx))
+
+	assert.Equal(t, [][]uint64{{0, 4 * gibibyte}, {6 * gibibyte}}, offsets)
+	assert.Equal(t, uint64(6*gibibyte+1536), size)
+	assert.Len(t, ctx.mediaData, 5)
+}
+
+func TestInterleave(t *testing.T) {
+	var newSamples = func(count int, duration uint32, size uint32) (samples []Sample) {
+		for range count {
+			samples = append(samples, Sample{SampleDuration: duration, SampleSize: size})
+		}
+		return
+	}
+	ctx := NewMuxContext()
+	ctx.InterleaveDuration = 500 * time.Millisecond
+	ctx.tracks = []muxTrack{
+		{Samples: newSamples(4, 250, 100), Timescale: 1000},  // video, 250 ms per sample
+		{Samples: newSamples(5, 9600, 10), Timescale: 48000}, // audio, 200 ms per sample
+		{Samples: newSamples(1, 2000, 5), Timescale: 1000},   // subtitles, a single cue
+	}
+
+	chunks := layoutChunks(ctx)
+	assert.Equal(t, [][]chunk{
+		{{0, 2, 1, 0}, {2, 2, 1, 500 * time.Millisecond}},
+		{{0, 3, 1, 0}, {3, 2, 1, 600 * time.Millisecond}},
+		{{0, 1, 1, 0}},
+	}, chunks)
+
+	// video, audio, subtitles, video, audio
+	offsets, size := ctx.layoutMediaData(chunks)
+	assert.Equal(t, [][]uint64{{0, 235}, {200, 435}}, offsets[:2])
+	assert.Equal(t, []uint64{230}, offsets[2])
+	assert.Equal(t, uint64(455), size)
+
+	t.Run("disabled", func(t *testing.T) {
+		ctx.InterleaveDuration = 0
+		offsets, _ := ctx.layoutMediaData(layoutChunks(ctx))
+		assert.Equal(t, [][]uint64{{0}, {400}, {450}}, offsets)
+	})
+}
+
+func TestLayoutChunksSampleDescriptions(t *testing.T) {
+	var newSamples = func(sampleDescriptionIndexes ...uint32) (samples []Sample) {
+		for _, idx := range sampleDescriptionIndexes {
+			samples = append(samples, Sample{SampleDuration: 100, SampleSize: 10, SampleDescriptionIndex: idx})
+		}
+		return
+	}
+	track := muxTrack{Samples: newSamples(1, 1, 1, 2, 2, 2, 2, 1), Timescale: 1000}
+
+	t.Run("chunk size", func(t *testing.T) {
+		ctx := NewMuxContext()
+		ctx.ChunkSize = 3
+		assert.Equal(t, []chunk{
+			{0, 3, 1, 0},
+			{3, 3, 2, 300 * time.Millisecond},
+			{6, 1, 2, 600 * time.Millisecond},
+			{7, 1, 1, 700 * time.Millisecond},
+		}, ctx.layoutChunks(track))
+	})
+
+	t.Run("interleaved", func(t *testing.T) {
+		ctx := NewMuxContext()
+		ctx.InterleaveDuration = 500 * time.Millisecond
+		assert.Equal(t, []chunk{
+			{0, 3, 1, 0},
+			{3, 2, 2, 300 * time.Millisecond},
+			{5, 2, 2, 500 * time.Millisecond},
+			{7, 1, 1, 700 * time.Millisecond},
+		}, ctx.layoutChunks(track))
+	})
+
+	t.Run("stsc", func(t *testing.T) {
+		root := &boxtree.BoxNode{}
+		require.NoError(t, root.Append(mp4.BoxTypeMoov(), &mp4.Moov{}))
+		trak := newTrack(t, root.Children[0])
+		trak.Mdia.Minf.Stbl.Stsc = &mp4.Stsc{}
+		require.NoError(t, root.Append(mp4.BoxTypeMdat(), &mp4.Mdat{}))
+
+		ctx := NewMuxContext()
+		ctx.Root = root
+		ctx.ChunkSize = 2
+		ctx.tracks = []muxTrack{{Trak: trak, Samples: newSamples(0, 1, 1, 2, 2, 2, 1, 1), Timescale: 1000}}
+		require.NoError(t, ctx.layoutTracks())
+
+		// an index of 0 (unset in the fragments) refers to the first sample description
+		assert.Equal(t, []mp4.StscEntry{
+			{FirstChunk: 1, SamplesPerChunk: 2, SampleDescriptionIndex: 1},
+			{FirstChunk: 2, SamplesPerChunk: 1, SampleDescriptionIndex: 1},
+			{FirstChunk: 3, SamplesPerChunk: 2, SampleDescriptionIndex: 2},
+			{FirstChunk: 4, SamplesPerChunk: 1, SampleDescriptionIndex: 2},
+			{FirstChunk: 5, SamplesPerChunk: 2, SampleDescriptionIndex: 1},
+		}, trak.Mdia.Minf.Stbl.Stsc.Entries)
+		assert.Len(t, trak.ChunkOffsets(), 5)
+	})
+}
+
+func TestPlaceMediaData(t *testing.T) {
+	t.Run("stco", func(t *testing.T) {
+		root := &boxtree.BoxNode{}
+		require.NoError(t, root.Append(mp4.BoxTypeMoov(), &mp4.Moov{}))
+		trak := newTrack(t, root.Children[0])
+		require.NoError(t, root.Append(mp4.BoxTypeMdat(), &mp4.Mdat{}))
+		mdat := root.Children[1]
+
+		setMediaDataHeader(mdat, 4096)
+		require.NoError(t, placeMediaData(root, mdat, []*cmaf.TrackBox{trak}, [][]uint64{{0, 1024}}))
+
+		assert.Nil(t, trak.Mdia.Minf.Stbl.Co64)
+		start := uint32(mdat.Info.Offset + mp4.SmallHeaderSize)
+		assert.Equal(t, []uint32{start, start + 1024}, trak.Mdia.Minf.Stbl.Stco.ChunkOffset)
+	})
+
+	t.Run("co64", func(t *testing.T) {
+		ctx := newLargeMuxContext()
+		offsets, size := ctx.layoutMediaData(layoutChunks(ctx))
+
+		root := &boxtree.BoxNode{}
+		require.NoError(t, root.Append(mp4.BoxTypeMoov(), &mp4.Moov{}))
+		video := newTrack(t, root.Children[0])
+		audio := newTrack(t, root.Children[0])
+		require.NoError(t, root.Append(mp4.BoxTypeMdat(), &mp4.Mdat{}))
+		mdat := root.Children[1]
+
+		setMediaDataHeader(mdat, size)
+		require.NoError(t, placeMediaData(root, mdat, []*cmaf.TrackBox{video, audio}, offsets))
+
+		// both tracks end beyond 4 GiB, so both use 64-bit chunk offsets
+		for _, trak := range []*cmaf.TrackBox{video, audio} {
+			assert.Nil(t, trak.Mdia.Minf.Stbl.Stco)
+			require.NotNil(t, trak.Mdia.Minf.Stbl.Co64)
+			_, err := trak.Mdia.Minf.Stbl.Node.P("co64")
+			assert.NoError(t, err)
+			_, err = trak.Mdia.Minf.Stbl.Node.P("stco")
+			assert.Error(t, err)
+		}
+
+		// the offsets account for the grown moov box and the largesize of the mdat box
+		moovSize, err := layout(&boxtree.BoxNode{Children: root.Children[:1]})
+		require.NoError(t, err)
+		start := moovSize + mp4.LargeHeaderSize
+		assert.Equal(t, []uint64{start, start + 4*gibibyte}, video.ChunkOffsets())
+		assert.Equal(t, []uint64{start + 6*gibibyte}, audio.ChunkOffsets())
+	})
+}
+
+func TestSetMediaDataHeader(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		size       uint64
+		headerSize uint64
+	}{
+		{"small", 1024, mp4.SmallHeaderSize},
+		{"limit", math.MaxUint32 - mp4.SmallHeaderSize, mp4.SmallHeaderSize},
+		{"large", math.MaxUint32 - mp4.SmallHeaderSize + 1, mp4.LargeHeaderSize},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := &boxtree.BoxNode{}
+			require.NoError(t, root.Append(mp4.BoxTypeMdat(), &mp4.Mdat{Data: []byte{0xde, 0xad}}))
+			setMediaDataHeader(root.Children[0], tt.size)
+
+			file, err := os.Create(filepath.Join(t.TempDir(), "mdat.mp4"))
+			require.NoError(t, err)
+			defer file.Close()
+			_, err = boxtree.Marshal(file, root)
+			require.NoError(t, err)
+
+			data, err := os.ReadFile(file.Name())
+			require.NoError(t, err)
+			require.Len(t, data, int(tt.headerSize)+2)
+			assert.Equal(t, "mdat", string(data[4:8]))
+			if tt.headerSize == mp4.LargeHeaderSize {
+				assert.Equal(t, uint32(1), binary.BigEndian.Uint32(data))
+				assert.Equal(t, uint64(len(data)), binary.BigEndian.Uint64(data[8:]))
+			} else {
+				assert.Equal(t, uint32(len(data)), binary.BigEndian.Uint32(data))
+			}
+		})
+	}
+}
@@ -40,18 +40,40 @@ type Sample struct {
 	SampleCompositionTimeOffsetV0 uint32
 	SampleCompositionTimeOffsetV1 int32
 	Version                       uint8
+	// FilePath and FileOffset locate the payload of a sample whose Data is not held in memory
+	FilePath   string
+	FileOffset int64
+}
+
+// remapSamples maps the track ID and sample description indexes of the samples onto
+// the movie when they follow a discontinuity, returning the track ID in the movie.
+func remapSamples(init *cmaf.Initialization, trackID uint32, samples []Sample) uint32 {
+	if init == nil {
+		return trackID
+	}
+	for idx := range samples {
+		samples[idx].SampleDescriptionIndex = init.MapSampleDescriptionIndex(trackID, samples[idx].SampleDescriptionIndex)
+	}
+	return init.MapTrackID(trackID)
 }
 
 func GetFullSamples(traf cmaf.TrackFragmentBox, mdat *mp4.Mdat, trex *mp4.Trex) (samples []Sample) {
 	//data := mdat.Data
+	moof := traf.Node.Parent
 	for _, trun := range traf.Trun {
+		// the data offsets are relative to the moof box, an explicit base_data_offset being
+		// relative to the start of the file instead
 		dataOffset := func() uint64 {
-			if trun.CheckFlag(0x1) {
+			if traf.Tfhd.CheckFlag(0x1) {
+				base := int64(traf.Tfhd.BaseDataOffset) - int64(moof.Info.Offset)
+				if trun.CheckFlag(0x1) {
+					base += int64(trun.DataOffset)
+				}
+				return uint64(base)
+			} else if trun.CheckFlag(0x1) {
 				return uint64(trun.DataOffset)
-			} else if traf.Tfhd.CheckFlag(0x1) {
-				return traf.Tfhd.BaseDataOffset
 			} else {
-				return traf.Node.Parent.Info.Size + 8
+				return moof.Info.Size + 8
 			}
 		}()
 		for _, entry := range trun.Entries {
@@ -102,8 +124,11 @@ func GetFullSamples(traf cmaf.TrackFragmentBox, mdat *mp4.Mdat, trex *mp4.Trex)
 			//sample.Data = data[:sample.SampleSize]
 			//data = data[sample.SampleSize:]
 
-			offset := sample.DataOffset - traf.Node.Parent.Info.Size - 8
-			sample.Data = mdat.Data[offset : offset+uint64(sample.SampleSize)]
+			// the payload is left out when it is not in the mdat box following the moof box,
+			// e.g. when a base_data_offset refers to a file the input is only a part of
+			if offset := sample.DataOffset - moof.Info.Size - 8; sample.DataOffset >= moof.Info.Size+8 && offset+uint64(sample.SampleSize) <= uint64(len(mdat.Data)) {
+				sample.Data = mdat.Data[offset : offset+uint64(sample.SampleSize)]
+			}
 			samples = append(samples, sample)
 			dataOffset += uint64(sample.SampleSize)
 		}
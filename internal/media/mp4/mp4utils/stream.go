@@ -0,0 +1,158 @@
+package mp4utils
+
+import (
+	"bufio"
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/cmaf"
+	"downloader/pkg/utils"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+// sampleSource is the file an input is read from, starting at Offset, which the samples
+// found in the input refer to instead of holding their data.
+type sampleSource struct {
+	FilePath string
+	Offset   int64
+}
+
+// detach makes the samples of a track fragment refer to their payload in the file. Their
+// offsets are relative to the moof box, which is at Offset in the file, unless the tfhd box
+// gives an explicit base_data_offset, which is already relative to the start of the file.
+func (s *sampleSource) detach(traf cmaf.TrackFragmentBox, samples []Sample) {
+	moof := traf.Node.Parent
+	offset := s.Offset + int64(moof.Info.Offset)
+	if traf.Tfhd.CheckFlag(0x1) {
+		offset = int64(moof.Info.Offset)
+	}
+	for idx := range samples {
+		samples[idx].FilePath = s.FilePath
+		samples[idx].FileOffset = offset + int64(samples[idx].DataOffset)
+		samples[idx].Data = nil
+	}
+}
+
+// SampleSpool holds the payloads of samples in a file rather than in memory, for those
+// which do not match the segment files anymore, e.g. once decrypted.
+type SampleSpool struct {
+	FilePath string
+	file     *os.File
+	writer   *bufio.Writer
+	offset   int64
+}
+
+func NewSampleSpool(filePath string) (spool *SampleSpool, err error) {
+	spool = &SampleSpool{FilePath: filePath}
+	if spool.file, err = os.Create(filePath); err != nil {
+		return nil, err
+	}
+	spool.writer = bufio.NewWriter(spool.file)
+	return
+}
+
+// Spool appends the payloads of the samples to the spool, which they refer to afterward.
+func (s *SampleSpool) Spool(samples []Sample) (err error) {
+	for idx := range samples {
+		data := samples[idx].Data
+		if data == nil {
+			continue
+		}
+		if _, err = s.writer.Write(data); err != nil {
+			return
+		}
+		samples[idx].FilePath = s.FilePath
+		samples[idx].FileOffset = s.offset
+		samples[idx].Data = nil
+		s.offset += int64(len(data))
+	}
+	return
+}
+
+func (s *SampleSpool) Close() (err error) {
+	if err = s.writer.Flush(); err != nil {
+		utils.CloseQuietly(s.file)
+		return
+	}
+	return s.file.Close()
+}
+
+// mediaDataSize returns the size of the payload of the mdat box of a desegmentized movie.
+func (ctx *MuxContext) mediaDataSize() (size uint64) {
+	for _, sample := range ctx.mediaData {
+		size += uint64(sample.SampleSize)
+	}
+	return
+}
+
+// writePayloads copies the payloads of the samples to the output, reading those which are
+// not held in memory from their files.
+func writePayloads(output io.Writer, samples []Sample) (err error) {
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, file := range files {
+			utils.CloseQuietly(file)
+		}
+	}()
+
+	writer := bufio.NewWriter(output)
+	for _, sample := range samples {
+		if len(sample.FilePath) == 0 {
+			if _, err = writer.Write(sample.Data); err != nil {
+				return
+			}
+			continue
+		}
+		file, found := files[sample.FilePath]
+		if !found {
+			if file, err = os.Open(sample.FilePath); err != nil {
+				return
+			}
+			files[sample.FilePath] = file
+		}
+		var n int64
+		if n, err = io.Copy(writer, io.NewSectionReader(file, sample.FileOffset, int64(sample.SampleSize))); err != nil {
+			return
+		}
+		if n != int64(sample.SampleSize) {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return writer.Flush()
+}
+
+// Finalize writes the movie. The media data of a desegmentized (or fragmentized) movie is
+// streamed after its boxes, from the samples, so that it is never held in memory as a whole.
+func (ctx *MuxContext) Finalize(output io.WriteSeeker) (err error) {
+	if ctx.fragments != nil {
+		return ctx.writeFragments(output)
+	}
+	if ctx.mediaData == nil {
+		return ctx.Context.Finalize(output)
+	}
+
+	children := ctx.Root.Children
+	if len(children) == 0 || children[len(children)-1].Info.Type != mp4.BoxTypeMdat() {
+		return errors.New("mdat box is not the last box of the movie")
+	}
+	mdat := children[len(children)-1]
+
+	ctx.Root.Children = children[:len(children)-1]
+	_, err = boxtree.Marshal(output, ctx.Root)
+	ctx.Root.Children = children
+	if err != nil {
+		return
+	}
+
+	headerSize := max(mdat.Info.HeaderSize, mp4.SmallHeaderSize)
+	if _, err = mp4.WriteBoxInfo(output, &mp4.BoxInfo{
+		Type:       mp4.BoxTypeMdat(),
+		HeaderSize: headerSize,
+		Size:       headerSize + ctx.mediaDataSize(),
+	}); err != nil {
+		return
+	}
+	return writePayloads(output, ctx.mediaData)
+}
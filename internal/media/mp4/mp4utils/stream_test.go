@@ -0,0 +1,83 @@
+package mp4utils
+
+import (
+	"bytes"
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/cmaf"
+	"path/filepath"
+	"testing"
+
+	"github.com/Spidey120703/go-mp4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleSpool(t *testing.T) {
+	spool, err := NewSampleSpool(filepath.Join(t.TempDir(), "samples"))
+	require.NoError(t, err)
+
+	samples := []Sample{
+		{Data: []byte{0x01, 0x02, 0x03}, SampleSize: 3},
+		{Data: []byte{0x04}, SampleSize: 1},
+		{Data: []byte{0x05, 0x06}, SampleSize: 2},
+	}
+	require.NoError(t, spool.Spool(samples[:2]))
+	require.NoError(t, spool.Spool(samples[:2])) // spooled samples are skipped
+	require.NoError(t, spool.Close())
+
+	for idx, offset := range []int64{0, 3} {
+		assert.Nil(t, samples[idx].Data)
+		assert.Equal(t, spool.FilePath, samples[idx].FilePath)
+		assert.Equal(t, offset, samples[idx].FileOffset)
+	}
+
+	// spooled samples are read back from the spool, the others from memory
+	var buf bytes.Buffer
+	require.NoError(t, writePayloads(&buf, []Sample{samples[2], samples[1], samples[0]}))
+	assert.Equal(t, []byte{0x05, 0x06, 0x04, 0x01, 0x02, 0x03}, buf.Bytes())
+
+	t.Run("truncated", func(t *testing.T) {
+		truncated := samples[0]
+		truncated.FileOffset = 2
+		assert.Error(t, writePayloads(&bytes.Buffer{}, []Sample{truncated}))
+	})
+}
+
+func TestDetach(t *testing.T) {
+	moof := &boxtree.BoxNode{Info: &mp4.BoxInfo{Offset: 100, Size: 50}}
+	mdat := &mp4.Mdat{Data: []byte{0x01, 0x02, 0x03, 0x04, 0x05}}
+	source := &sampleSource{FilePath: "segment.mp4", Offset: 1000}
+	for _, tt := range []struct {
+		name           string
+		baseDataOffset uint64
+		dataOffset     int32
+		data           [][]byte
+		fileOffsets    []int64
+	}{
+		// relative to the moof box, which starts at 1100 in the file
+		{name: "moof", dataOffset: 58, data: [][]byte{{0x01, 0x02, 0x03}, {0x04, 0x05}}, fileOffsets: []int64{1158, 1161}},
+		// relative to the start of the file, beyond the mdat box following the moof box
+		{name: "base data offset", baseDataOffset: 2000, dataOffset: 8, data: [][]byte{nil, nil}, fileOffsets: []int64{2008, 2011}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tfhd := &mp4.Tfhd{BaseDataOffset: tt.baseDataOffset}
+			if tt.baseDataOffset != 0 {
+				tfhd.AddFlag(0x1)
+			}
+			trun := &mp4.Trun{DataOffset: tt.dataOffset, Entries: []mp4.TrunEntry{{SampleSize: 3}, {SampleSize: 2}}}
+			trun.AddFlag(0x1 | 0x200)
+			traf := cmaf.TrackFragmentBox{Tfhd: tfhd, Trun: []*mp4.Trun{trun}, Node: &boxtree.BoxNode{Parent: moof}}
+
+			samples := GetFullSamples(traf, mdat, &mp4.Trex{})
+			require.Len(t, samples, 2)
+			for idx := range samples {
+				assert.Equal(t, tt.data[idx], samples[idx].Data)
+			}
+			source.detach(traf, samples)
+			for idx := range samples {
+				assert.Nil(t, samples[idx].Data)
+				assert.Equal(t, tt.fileOffsets[idx], samples[idx].FileOffset)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package mp4utils
+
+import (
+	"downloader/internal/media/mp4/boxtree"
+	"downloader/internal/media/mp4/cmaf"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+/*************************** tx3g ****************************/
+
+func BoxTypeTx3g() mp4.BoxType { return mp4.StrToBoxType("tx3g") }
+
+func BoxTypeFtab() mp4.BoxType { return mp4.StrToBoxType("ftab") }
+
+func init() {
+	mp4.AddAnyTypeBoxDef(&Tx3g{}, BoxTypeTx3g())
+	mp4.AddBoxDef(&Ftab{})
+}
+
+// Tx3g is the 3GPP timed text sample entry
+//
+// 3GPP TS 26.245 Section 5.16
+type Tx3g struct {
+	mp4.SampleEntry         `mp4:"0,extend"`
+	DisplayFlags            uint32   `mp4:"1,size=32,hex"`
+	HorizontalJustification int8     `mp4:"2,size=8"`
+	VerticalJustification   int8     `mp4:"3,size=8"`
+	BackgroundColorRGBA     [4]uint8 `mp4:"4,size=8,hex"`
+	DefaultTextBox          [4]int16 `mp4:"5,size=16"` // top, left, bottom, right
+	StartChar               uint16   `mp4:"6,size=16"`
+	EndChar                 uint16   `mp4:"7,size=16"`
+	FontID                  uint16   `mp4:"8,size=16"`
+	FaceStyleFlags          uint8    `mp4:"9,size=8"`
+	FontSize                uint8    `mp4:"10,size=8"`
+	TextColorRGBA           [4]uint8 `mp4:"11,size=8,hex"`
+}
+
+// Ftab is the 3GPP font table box
+//
+// 3GPP TS 26.245 Section 5.16
+type Ftab struct {
+	mp4.Box
+	EntryCount  uint16 `mp4:"0,size=16"`
+	FontRecords []byte `mp4:"1,size=8"` // font-ID (16) | font-name-length (8) | font (8 * font-name-length)
+}
+
+func (*Ftab) GetType() mp4.BoxType {
+	return BoxTypeFtab()
+}
+
+var HandlerTypeSubtitle = [4]byte{'s', 'b', 't', 'l'}
+
+const TextTimescale uint32 = 1000
+
+// ISO639Language converts an RFC 5646 language tag (as used by the EXT-X-MEDIA LANGUAGE
+// attribute) to the packed ISO-639-2/T code of mdhd.
+func ISO639Language(tag string) (language [3]byte) {
+	var code = "und"
+	primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+	switch len(primary) {
+	case 2:
+		if c, found := iso639Alpha3[primary]; found {
+			code = c
+		}
+	case 3:
+		code = primary
+	}
+	for i := range language {
+		language[i] = code[i] - 0x60
+	}
+	return
+}
+
+var iso639Alpha3 = map[string]string{
+	"ar": "ara", "cs": "ces", "da": "dan", "de": "deu", "el": "ell", "en": "eng",
+	"es": "spa", "fi": "fin", "fr": "fra", "he": "heb", "hi": "hin", "hu": "hun",
+	"id": "ind", "it": "ita", "ja": "jpn", "ko": "kor", "ms": "msa", "nb": "nob",
+	"nl": "nld", "no": "nor", "pl": "pol", "pt": "por", "ro": "ron", "ru": "rus",
+	"sk": "slk", "sv": "swe", "th": "tha", "tr": "tur", "uk": "ukr", "vi": "vie",
+	"zh": "zho",
+}
+
+// TextSample is a span of the subtitle timeline, an empty Text clears the screen.
+type TextSample struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// NewTextSamples flattens possibly overlapping cues into the contiguous, non-overlapping
+// sample sequence required by tx3g, filling the gaps with empty samples.
+func NewTextSamples(spans []TextSample) (samples []TextSample) {
+	var bounds []time.Duration
+	for _, span := range spans {
+		bounds = append(bounds, span.Start, span.End)
+	}
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+
+	var cursor time.Duration
+	for idx := 0; idx+1 < len(bounds); idx++ {
+		start, end := bounds[idx], bounds[idx+1]
+		var lines []string
+		for _, span := range spans {
+			if span.Start <= start && end <= span.End && len(span.Text) != 0 {
+				lines = append(lines, span.Text)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		if start > cursor {
+			samples = append(samples, TextSample{Start: cursor, End: start})
+		}
+		text := strings.Join(lines, "\n")
+		if len(samples) > 0 && samples[len(samples)-1].End == start && samples[len(samples)-1].Text == text {
+			samples[len(samples)-1].End = end
+		} else {
+			samples = append(samples, TextSample{Start: start, End: end, Text: text})
+		}
+		cursor = end
+	}
+	return
+}
+
+func appendBox(parent *boxtree.BoxNode, box mp4.IBox) (node *boxtree.BoxNode, err error) {
+	if err = parent.Append(box.GetType(), box); err != nil {
+		return
+	}
+	node = parent.Children[len(parent.Children)-1]
+	return
+}
+
+// NewTextMuxContext builds a non-fragmented movie holding a single tx3g subtitle track,
+// ready to be merged into another movie (of the given movie timescale) via MuxContext.MuxTrack.
+func NewTextMuxContext(spans []TextSample, movieTimescale uint32, language string, name string, enabled bool) (ctx *MuxContext, err error) {
+	ctx = NewMuxContext()
+	ctx.Root = &boxtree.BoxNode{Path: mp4.BoxPath{}}
+
+	samples := NewTextSamples(spans)
+	var duration uint64
+	if len(samples) > 0 {
+		duration = uint64(samples[len(samples)-1].End / time.Millisecond)
+	}
+
+	if _, err = appendBox(ctx.Root, &mp4.Ftyp{
+		MajorBrand:   mp4.BrandM4V(),
+		MinorVersion: 0,
+		CompatibleBrands: []mp4.CompatibleBrandElem{
+			{CompatibleBrand: mp4.BrandM4V()},
+			{CompatibleBrand: mp4.BrandMP42()},
+			{CompatibleBrand: mp4.BrandISOM()},
+		},
+	}); err != nil {
+		return
+	}
+
+	var moov, trak, mdia, minf, dinf, stbl, stsd, tx3g *boxtree.BoxNode
+	if moov, err = appendBox(ctx.Root, &mp4.Moov{}); err != nil {
+		return
+	}
+	if _, err = appendBox(moov, &mp4.Mvhd{
+		Timescale:   movieTimescale,
+		DurationV0:  uint32(duration * uint64(movieTimescale) / uint64(TextTimescale)),
+		Rate:        0x00010000,
+		Volume:      0x0100,
+		Matrix:      [9]int32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000},
+		NextTrackID: 2,
+	}); err != nil {
+		return
+	}
+
+	if trak, err = appendBox(moov, &mp4.Trak{}); err != nil {
+		return
+	}
+	{ // moov.trak.tkhd
+		tkhd := &mp4.Tkhd{
+			TrackID:        1,
+			DurationV0:     uint32(duration * uint64(movieTimescale) / uint64(TextTimescale)),
+			AlternateGroup: 2,
+			Matrix:         [9]int32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000},
+		}
+		if enabled {
+			tkhd.SetFlags(0x3)
+		} else {
+			tkhd.SetFlags(0x2)
+		}
+		if _, err = appendBox(trak, tkhd); err != nil {
+			return
+		}
+	}
+
+	if mdia, err = appendBox(trak, &mp4.Mdia{}); err != nil {
+		return
+	}
+	if _, err = appendBox(mdia, &mp4.Mdhd{
+		Timescale:  TextTimescale,
+		DurationV0: uint32(duration),
+		Language:   ISO639Language(language),
+	}); err != nil {
+		return
+	}
+	if _, err = appendBox(mdia, &mp4.Hdlr{
+		HandlerType: HandlerTypeSubtitle,
+		Name:        name,
+	}); err != nil {
+		return
+	}
+
+	if minf, err = appendBox(mdia, &mp4.Minf{}); err != nil {
+		return
+	}
+	if _, err = appendBox(minf, &mp4.Nmhd{}); err != nil {
+		return
+	}
+	if dinf, err = appendBox(minf, &mp4.Dinf{}); err != nil {
+		return
+	}
+	{ // moov.trak.mdia.minf.dinf.dref
+		var dref *boxtree.BoxNode
+		if dref, err = appendBox(dinf, &mp4.Dref{EntryCount: 1}); err != nil {
+			return
+		}
+		url := &mp4.Url{}
+		url.SetFlags(mp4.UrlSelfContained)
+		if _, err = appendBox(dref, url); err != nil {
+			return
+		}
+	}
+
+	if stbl, err = appendBox(minf, &mp4.Stbl{}); err != nil {
+		return
+	}
+	if stsd, err = appendBox(stbl, &mp4.Stsd{EntryCount: 1}); err != nil {
+		return
+	}
+	{ // moov.trak.mdia.minf.stbl.stsd.tx3g
+		entry := &Tx3g{
+			SampleEntry:           mp4.SampleEntry{DataReferenceIndex: 1},
+			VerticalJustification: -1, // bottom
+			FontID:                1,
+			FontSize:              18,
+			TextColorRGBA:         [4]uint8{0xff, 0xff, 0xff, 0xff},
+		}
+		entry.SetType(BoxTypeTx3g())
+		if tx3g, err = appendBox(stsd, entry); err != nil {
+			return
+		}
+		fontName := "Sans-Serif"
+		if _, err = appendBox(tx3g, &Ftab{
+			EntryCount:  1,
+			FontRecords: append([]byte{0x00, 0x01, byte(len(fontName))}, fontName...),
+		}); err != nil {
+			return
+		}
+	}
+
+	stts := &mp4.Stts{}
+	stsz := &mp4.Stsz{}
+	var textSamples []Sample
+	for _, sample := range samples {
+		delta := uint32((sample.End - sample.Start) / time.Millisecond)
+		if n := len(stts.Entries); n > 0 && stts.Entries[n-1].SampleDelta == delta {
+			stts.Entries[n-1].SampleCount++
+		} else {
+			stts.Entries = append(stts.Entries, mp4.SttsEntry{SampleCount: 1, SampleDelta: delta})
+		}
+
+		data := binary.BigEndian.AppendUint16(nil, uint16(len(sample.Text)))
+		data = append(data, sample.Text...)
+		stsz.EntrySize = append(stsz.EntrySize, uint32(len(data)))
+		textSamples = append(textSamples, Sample{
+			Data:                   data,
+			SampleDescriptionIndex: 1,
+			SampleDuration:         delta,
+			SampleSize:             uint32(len(data)),
+		})
+	}
+	stts.EntryCount = uint32(len(stts.Entries))
+	stsz.SampleCount = uint32(len(stsz.EntrySize))
+
+	// stsc and stco are filled once the chunks are laid out
+	for _, box := range []mp4.IBox{stts, &mp4.Stsc{}, stsz, &mp4.Stco{}} {
+		if _, err = appendBox(stbl, box); err != nil {
+			return
+		}
+	}
+
+	if _, err = appendBox(ctx.Root, &mp4.Mdat{}); err != nil {
+		return
+	}
+	if ctx.Header, err = cmaf.InitializeHeader(ctx.Root); err != nil {
+		return
+	}
+	ctx.Samples[1] = textSamples
+	ctx.tracks = []muxTrack{{
+		Trak:      &ctx.Header.Moov.Trak[0],
+		Samples:   textSamples,
+		Timescale: TextTimescale,
+	}}
+	if err = ctx.layoutTracks(); err != nil {
+		return
+	}
+
+	ctx.Header, err = cmaf.InitializeHeader(ctx.Root)
+	return
+}
@@ -0,0 +1,152 @@
+package mp4utils
+
+import (
+	"bytes"
+	"downloader/internal/media/mp4/bitstruct/bitio"
+	"downloader/internal/media/mp4/boxtree"
+	"errors"
+	"fmt"
+
+	"github.com/Spidey120703/go-mp4"
+)
+
+var ErrNotVisualSampleEntry = errors.New("not a visual sample entry")
+
+// transfer_characteristics (ITU-T H.273 Table 3)
+const (
+	TransferCharacteristicsPQ  uint8 = 16 // SMPTE ST 2084
+	TransferCharacteristicsHLG uint8 = 18 // ARIB STD-B67
+)
+
+// VideoInfo describes the video stream as coded in its parameter sets (SPS, VPS) or
+// sequence header, rather than by the attributes of the playlist.
+type VideoInfo struct {
+	Codec                   string
+	Width                   uint32 // coded width, after cropping
+	Height                  uint32 // coded height, after cropping
+	FrameRate               float64
+	BitDepth                uint8
+	ColourPrimaries         uint8
+	TransferCharacteristics uint8
+	MatrixCoefficients      uint8
+	FullRange               bool
+}
+
+func (v *VideoInfo) IsHDR() bool {
+	return v.TransferCharacteristics == TransferCharacteristicsPQ || v.TransferCharacteristics == TransferCharacteristicsHLG
+}
+
+func (v *VideoInfo) Resolution() string {
+	return fmt.Sprintf("%dx%d", v.Width, v.Height)
+}
+
+// syntaxReader reads the syntax elements of parameter sets and sequence headers, keeping
+// the first error so that the parsers read like the specifications.
+type syntaxReader struct {
+	r   bitio.Reader
+	err error
+}
+
+func newSyntaxReader(data []byte) *syntaxReader {
+	return &syntaxReader{r: bitio.NewReader(bytes.NewReader(data))}
+}
+
+// u reads an unsigned integer using n bits, u(n) or f(n)
+func (s *syntaxReader) u(n uint) uint64 {
+	if s.err != nil || n == 0 {
+		return 0
+	}
+	var value uint64
+	value, s.err = bitio.ReadUint(s.r, n)
+	return value
+}
+
+func (s *syntaxReader) flag() bool {
+	return s.u(1) == 1
+}
+
+func (s *syntaxReader) skip(n uint) {
+	for ; n > 64; n -= 64 {
+		s.u(64)
+	}
+	s.u(n)
+}
+
+func (s *syntaxReader) ue() uint64 {
+	if s.err != nil {
+		return 0
+	}
+	var value uint64
+	value, s.err = bitio.ReadUE(s.r)
+	return value
+}
+
+func (s *syntaxReader) se() int64 {
+	if s.err != nil {
+		return 0
+	}
+	var value int64
+	value, s.err = bitio.ReadSE(s.r)
+	return value
+}
+
+// unescapeRBSP removes the emulation prevention bytes (0x000003) of a NAL unit
+func unescapeRBSP(nalu []byte) []byte {
+	rbsp := make([]byte, 0, len(nalu))
+	var zeros int
+	for _, b := range nalu {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return rbsp
+}
+
+// VideoInfoOf reads the visual sample entry of a track (a child of stsd), from the
+// parameter sets of its decoder configuration when they are supported.
+func VideoInfoOf(entry *boxtree.BoxNode) (info *VideoInfo, err error) {
+	sampleEntry, ok := entry.Box.(*mp4.VisualSampleEntry)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotVisualSampleEntry, entry.Info.Type)
+	}
+
+	info = &VideoInfo{
+		Width:                   uint32(sampleEntry.Width),
+		Height:                  uint32(sampleEntry.Height),
+		ColourPrimaries:         2, // unspecified
+		TransferCharacteristics: 2,
+		MatrixCoefficients:      2,
+	}
+	info.Codec, _ = CodecString(entry)
+
+	if avcC, e := findBox[*mp4.AVCDecoderConfiguration](entry, mp4.BoxTypeAvcC()); e == nil {
+		if len(avcC.SequenceParameterSets) == 0 {
+			return info, errors.New("avcC without sequence parameter set")
+		}
+		err = parseAVCSequenceParameterSet(avcC.SequenceParameterSets[0].NALUnit, info)
+	} else if hvcC, e := findBox[*mp4.HvcC](entry, mp4.BoxTypeHvcC()); e == nil {
+		err = parseHEVCParameterSets(hvcC, info)
+	} else if av1C, e := findBox[*mp4.Av1C](entry, mp4.BoxTypeAv1C()); e == nil {
+		err = parseAV1ConfigOBUs(av1C.ConfigOBUs, info)
+	}
+	return
+}
+
+// CheckVariant compares the coded stream with the resolution (e.g. "1920x1080") and frame
+// rate announced by the playlist, returning the differences.
+func (v *VideoInfo) CheckVariant(resolution string, frameRate float64) (mismatches []string) {
+	if len(resolution) != 0 && resolution != v.Resolution() {
+		mismatches = append(mismatches, fmt.Sprintf("resolution %s, expected %s", v.Resolution(), resolution))
+	}
+	if frameRate != 0 && v.FrameRate != 0 && (v.FrameRate-frameRate > 0.01 || frameRate-v.FrameRate > 0.01) {
+		mismatches = append(mismatches, fmt.Sprintf("frame rate %.3f, expected %.3f", v.FrameRate, frameRate))
+	}
+	return
+}
@@ -0,0 +1,13 @@
+package quicktime
+
+// Rating is the content rating of the rtng item.
+type Rating uint8
+
+const (
+	RatingNone Rating = iota
+	RatingExplicit
+	RatingClean
+	_
+	// RatingExplicitOld is the explicit rating written by old versions of iTunes
+	RatingExplicitOld
+)
@@ -0,0 +1,246 @@
+package webvtt
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cue is a single WebVTT cue, with timestamps on the media timeline.
+type Cue struct {
+	ID       string
+	Start    time.Duration
+	End      time.Duration
+	Settings string
+	Text     string
+}
+
+var ErrInvalidWebVTT = errors.New("invalid webvtt: missing WEBVTT signature")
+
+// parseTimestamp parses `hh:mm:ss.ttt` or `mm:ss.ttt`
+func parseTimestamp(str string) (d time.Duration, err error) {
+	str = strings.TrimSpace(str)
+	var hours, minutes, seconds, millis int
+	parts := strings.Split(str, ":")
+	switch len(parts) {
+	case 2:
+		_, err = fmt.Sscanf(str, "%d:%d.%d", &minutes, &seconds, &millis)
+	case 3:
+		_, err = fmt.Sscanf(str, "%d:%d:%d.%d", &hours, &minutes, &seconds, &millis)
+	default:
+		err = fmt.Errorf("invalid timestamp: %s", str)
+	}
+	if err != nil {
+		return
+	}
+	d = time.Duration(hours)*time.Hour +
+		time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second +
+		time.Duration(millis)*time.Millisecond
+	return
+}
+
+// parseTimestampMap parses the HLS `X-TIMESTAMP-MAP=MPEGTS:<ts>,LOCAL:<time>` header,
+// returning the offset to add to the cue timestamps.
+//
+// RFC 8216 Section 3.5
+func parseTimestampMap(str string) (offset time.Duration, err error) {
+	var mpegts int64
+	var local time.Duration
+	for _, field := range strings.Split(str, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(field), ":")
+		if !found {
+			return 0, fmt.Errorf("invalid X-TIMESTAMP-MAP: %s", str)
+		}
+		switch key {
+		case "MPEGTS":
+			if mpegts, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return
+			}
+		case "LOCAL":
+			if local, err = parseTimestamp(value); err != nil {
+				return
+			}
+		}
+	}
+	offset = time.Duration(mpegts)*time.Second/90000 - local
+	return
+}
+
+// Parse reads one WebVTT document (or HLS subtitle segment) and returns its cues.
+func Parse(reader io.Reader) (cues []Cue, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	if !scanner.Scan() {
+		return nil, ErrInvalidWebVTT
+	}
+	if !strings.HasPrefix(strings.TrimPrefix(scanner.Text(), "\ufeff"), "WEBVTT") {
+		return nil, ErrInvalidWebVTT
+	}
+
+	var offset time.Duration
+	var block []string
+	var flush = func() (err error) {
+		defer func() { block = block[:0] }()
+		if len(block) == 0 {
+			return
+		}
+		var idx int
+		if !strings.Contains(block[0], "-->") {
+			if strings.HasPrefix(block[0], "X-TIMESTAMP-MAP=") {
+				offset, err = parseTimestampMap(block[0][len("X-TIMESTAMP-MAP="):])
+				return
+			}
+			if strings.HasPrefix(block[0], "NOTE") ||
+				strings.HasPrefix(block[0], "STYLE") ||
+				strings.HasPrefix(block[0], "REGION") {
+				return
+			}
+			idx = 1
+		}
+		if idx >= len(block) || !strings.Contains(block[idx], "-->") {
+			return
+		}
+
+		cue := Cue{}
+		if idx == 1 {
+			cue.ID = block[0]
+		}
+		start, rest, _ := strings.Cut(block[idx], "-->")
+		end, settings, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		if cue.Start, err = parseTimestamp(start); err != nil {
+			return
+		}
+		if cue.End, err = parseTimestamp(end); err != nil {
+			return
+		}
+		cue.Start += offset
+		cue.End += offset
+		cue.Settings = strings.TrimSpace(settings)
+		cue.Text = strings.Join(block[idx+1:], "\n")
+		cues = append(cues, cue)
+		return
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 {
+			if err = flush(); err != nil {
+				return
+			}
+			continue
+		}
+		block = append(block, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	err = flush()
+	return
+}
+
+// Merge combines the cues of consecutive subtitle segments, sorting them by start
+// time and dropping duplicates of cues that span a segment boundary.
+func Merge(segments ...[]Cue) (cues []Cue) {
+	for _, segment := range segments {
+		for _, cue := range segment {
+			if cue.End <= cue.Start {
+				continue
+			}
+			idx := slices.IndexFunc(cues, func(c Cue) bool {
+				return c.Text == cue.Text && c.Start <= cue.End && cue.Start <= c.End
+			})
+			if idx < 0 {
+				cues = append(cues, cue)
+				continue
+			}
+			cues[idx].Start = min(cues[idx].Start, cue.Start)
+			cues[idx].End = max(cues[idx].End, cue.End)
+		}
+	}
+	slices.SortStableFunc(cues, func(a, b Cue) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	return
+}
+
+func formatTimestamp(d time.Duration, sep byte) string {
+	if d < 0 {
+		d = 0
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d",
+		d/time.Hour,
+		d%time.Hour/time.Minute,
+		d%time.Minute/time.Second,
+		sep,
+		d%time.Second/time.Millisecond)
+}
+
+// StripTags removes the WebVTT cue markup (`<i>`, `<c.xxx>`, `<00:00:01.000>`...)
+// and decodes the basic character references.
+func StripTags(text string) string {
+	var builder strings.Builder
+	var inTag bool
+	for _, r := range text {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>' && inTag:
+			inTag = false
+		case !inTag:
+			builder.WriteRune(r)
+		}
+	}
+	return strings.NewReplacer(
+		"&amp;", "&",
+		"&lt;", "<",
+		"&gt;", ">",
+		"&nbsp;", " ",
+		"&lrm;", "\u200e",
+		"&rlm;", "\u200f",
+	).Replace(builder.String())
+}
+
+// WriteVTT writes the cues as a WebVTT document.
+func WriteVTT(writer io.Writer, cues []Cue) (err error) {
+	if _, err = io.WriteString(writer, "WEBVTT\n"); err != nil {
+		return
+	}
+	for _, cue := range cues {
+		var builder strings.Builder
+		builder.WriteByte('\n')
+		if len(cue.ID) != 0 {
+			builder.WriteString(cue.ID + "\n")
+		}
+		builder.WriteString(formatTimestamp(cue.Start, '.') + " --> " + formatTimestamp(cue.End, '.'))
+		if len(cue.Settings) != 0 {
+			builder.WriteString(" " + cue.Settings)
+		}
+		builder.WriteString("\n" + cue.Text + "\n")
+		if _, err = io.WriteString(writer, builder.String()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// WriteSRT writes the cues as a SubRip document.
+func WriteSRT(writer io.Writer, cues []Cue) (err error) {
+	for idx, cue := range cues {
+		if _, err = fmt.Fprintf(writer, "%d\n%s --> %s\n%s\n\n",
+			idx+1,
+			formatTimestamp(cue.Start, ','),
+			formatTimestamp(cue.End, ','),
+			StripTags(cue.Text)); err != nil {
+			return
+		}
+	}
+	return
+}
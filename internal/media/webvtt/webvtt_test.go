@@ -0,0 +1,69 @@
+package webvtt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cues, err := Parse(strings.NewReader("\ufeffWEBVTT\r\n" +
+		"X-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\r\n" +
+		"\r\n" +
+		"NOTE a comment\r\n" +
+		"\r\n" +
+		"1\r\n" +
+		"00:00:01.000 --> 00:00:02.500 align:start line:90%\r\n" +
+		"<i>First</i>\r\n" +
+		"line\r\n" +
+		"\r\n" +
+		"01:02.000 --> 01:03.000\r\n" +
+		"Second"))
+	require.NoError(t, err)
+	assert.Equal(t, []Cue{
+		{ID: "1", Start: 11 * time.Second, End: 12500 * time.Millisecond, Settings: "align:start line:90%", Text: "<i>First</i>\nline"},
+		{Start: 72 * time.Second, End: 73 * time.Second, Text: "Second"},
+	}, cues)
+
+	t.Run("errors", func(t *testing.T) {
+		for name, document := range map[string]string{
+			"signature": "1\n00:00:01.000 --> 00:00:02.000\nText\n",
+			"empty":     "",
+			"timestamp": "WEBVTT\n\n00:01.000 --> 1.000\nText\n",
+		} {
+			_, err := Parse(strings.NewReader(document))
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestMerge(t *testing.T) {
+	cues := Merge(
+		[]Cue{
+			{Start: 3 * time.Hour, End: 3*time.Hour + time.Second, Text: "Late"},
+			{Start: time.Second, End: 6 * time.Second, Text: "Spanning"},
+			{Start: 2 * time.Second, End: 2 * time.Second, Text: "Empty"},
+		},
+		[]Cue{
+			{Start: 5 * time.Second, End: 8 * time.Second, Text: "Spanning"},
+			{Start: 0, End: time.Second, Text: "Early"},
+		},
+	)
+	assert.Equal(t, []Cue{
+		{Start: 0, End: time.Second, Text: "Early"},
+		{Start: time.Second, End: 8 * time.Second, Text: "Spanning"},
+		{Start: 3 * time.Hour, End: 3*time.Hour + time.Second, Text: "Late"},
+	}, cues)
+}
+
+func TestWriteSRT(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteSRT(&buf, []Cue{
+		{Start: 61500 * time.Millisecond, End: time.Hour, Text: "<c.yellow>Tom &amp; Jerry</c>"},
+	}))
+	assert.Equal(t, "1\n00:01:01,500 --> 01:00:00,000\nTom & Jerry\n\n", buf.String())
+}
@@ -59,3 +59,44 @@ func (b *NullWriter) Seek(offset int64, whence int) (int64, error) {
 	b.Offset = min(int64(b.Size), max(0, b.Offset))
 	return b.Offset, nil
 }
+
+// Skip counts size bytes as written, without writing them.
+func (b *NullWriter) Skip(size uint64) {
+	b.Offset += int64(size)
+	b.Size = max(b.Size, uint64(b.Offset))
+}
+
+type BufferWriter struct {
+	data   []byte
+	Offset int64
+}
+
+func NewBufferWriter() *BufferWriter {
+	return &BufferWriter{}
+}
+
+func (b *BufferWriter) Bytes() []byte { return b.data }
+
+func (b *BufferWriter) Close() error { return nil }
+
+func (b *BufferWriter) Write(data []byte) (n int, err error) {
+	if end := b.Offset + int64(len(data)); end > int64(len(b.data)) {
+		b.data = append(b.data, make([]byte, end-int64(len(b.data)))...)
+	}
+	n = copy(b.data[b.Offset:], data)
+	b.Offset += int64(n)
+	return
+}
+
+func (b *BufferWriter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		b.Offset = offset
+	case io.SeekCurrent:
+		b.Offset += offset
+	case io.SeekEnd:
+		b.Offset = int64(len(b.data)) + offset
+	}
+	b.Offset = min(int64(len(b.data)), max(0, b.Offset))
+	return b.Offset, nil
+}